@@ -0,0 +1,123 @@
+package main
+
+import (
+	autoscalingv1 "agones.dev/agones/pkg/apis/autoscaling/v1"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"matchmaker/pkg/autoscaler/scaler"
+	"matchmaker/pkg/common/appconfig"
+	"matchmaker/pkg/common/mmfcontrol"
+	"matchmaker/pkg/common/modeprofile/config"
+	"net/http"
+	"open-match.dev/open-match/pkg/pb"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// autoscalerConfig is loaded by appconfig from flags, environment variables and an optional file.
+// The Default* fields are the policy of fleets without an entry in scaler.Policies.
+type autoscalerConfig struct {
+	QueryServiceAddress string `json:"queryServiceAddress" env:"OM_QUERY_ENDPOINT" flag:"om_query_endpoint" usage:"Address of the Open Match QueryService"`
+	Port                int    `json:"port" env:"PORT" flag:"port" usage:"The port the webhook is hosted on"`
+	// ModesFile is the JSON file of the modes, see modeprofile/config.File. The default modes are used if it doesn't exist.
+	ModesFile string `json:"modesFile" env:"MODES_FILE" flag:"modes_file" usage:"JSON file of the modes and allocation definitions"`
+
+	// Countdowns of countdown modes are read from the MatchFunctionControl API, authenticated with the MatchFunctionControlToken.
+	MatchFunctionControl      string `json:"matchFunctionControl" env:"MATCH_FUNCTION_CONTROL" flag:"match_function_control" usage:"URL of the match function's control API"`
+	MatchFunctionControlToken string `json:"matchFunctionControlToken" env:"MATCH_FUNCTION_CONTROL_TOKEN" flag:"match_function_control_token" usage:"Token of the match function's control API" secret:"true"`
+
+	MinReplicas       int32         `json:"minReplicas" env:"MIN_REPLICAS" flag:"min_replicas" usage:"Minimum replicas of a fleet"`
+	MaxReplicas       int32         `json:"maxReplicas" env:"MAX_REPLICAS" flag:"max_replicas" usage:"Maximum replicas of a fleet"`
+	Headroom          int32         `json:"headroom" env:"HEADROOM" flag:"headroom" usage:"Ready GameServers kept on top of those needed by the queue"`
+	MatchesPerServer  int           `json:"matchesPerServer" env:"MATCHES_PER_SERVER" flag:"matches_per_server" usage:"Matches a GameServer runs at once"`
+	ScaleUpCooldown   time.Duration `json:"scaleUpCooldown" env:"SCALE_UP_COOLDOWN" flag:"scale_up_cooldown" usage:"Minimum time between scaling a fleet up"`
+	ScaleDownCooldown time.Duration `json:"scaleDownCooldown" env:"SCALE_DOWN_COOLDOWN" flag:"scale_down_cooldown" usage:"Minimum time between scaling a fleet down"`
+}
+
+func (c *autoscalerConfig) Validate() error {
+	if c.QueryServiceAddress == "" {
+		return fmt.Errorf("queryServiceAddress is required")
+	}
+	if c.MatchFunctionControl == "" || c.MatchFunctionControlToken == "" {
+		return fmt.Errorf("matchFunctionControl and matchFunctionControlToken are required to read countdowns")
+	}
+	if c.Port <= 0 {
+		return fmt.Errorf("port must be greater than 0")
+	}
+	if c.MinReplicas < 0 || (c.MaxReplicas > 0 && c.MaxReplicas < c.MinReplicas) {
+		return fmt.Errorf("maxReplicas is less than minReplicas")
+	}
+	return nil
+}
+
+func main() {
+	cfg := autoscalerConfig{
+		QueryServiceAddress:  "open-match-query.open-match.svc:50503",
+		Port:                 8000,
+		MatchFunctionControl: "http://matchfunction.towerdefence.svc:8080",
+		MinReplicas:          1,
+		MaxReplicas:          20,
+		Headroom:             1,
+		MatchesPerServer:     1,
+	}
+	appconfig.MustLoad("autoscaler", &cfg)
+	if err := config.Load(cfg.ModesFile); err != nil {
+		logger.Fatal("Failed to load modes", zap.Error(err))
+	}
+
+	conn, err := grpc.Dial(cfg.QueryServiceAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`),
+	)
+	if err != nil {
+		logger.Fatal("Failed to connect to Open Match", zap.Error(err))
+	}
+	defer conn.Close()
+
+	control := mmfcontrol.NewClient(cfg.MatchFunctionControl, cfg.MatchFunctionControlToken)
+	s := scaler.NewScaler(pb.NewQueryServiceClient(conn), control, scaler.Policy{
+		MinReplicas:       cfg.MinReplicas,
+		MaxReplicas:       cfg.MaxReplicas,
+		Headroom:          cfg.Headroom,
+		MatchesPerServer:  cfg.MatchesPerServer,
+		ScaleUpCooldown:   cfg.ScaleUpCooldown,
+		ScaleDownCooldown: cfg.ScaleDownCooldown,
+	})
+
+	http.HandleFunc("/scale", func(w http.ResponseWriter, r *http.Request) {
+		handleScale(s, w, r)
+	})
+
+	logger.Info("Starting autoscaler webhook", zap.Int("port", cfg.Port), zap.Any("policies", scaler.Policies))
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), nil); err != nil {
+		logger.Fatal("Autoscaler webhook failed", zap.Error(err))
+	}
+}
+
+// handleScale answers a FleetAutoscaleReview from Agones with the replicas the fleet should have.
+func handleScale(s *scaler.Scaler, w http.ResponseWriter, r *http.Request) {
+	var review autoscalingv1.FleetAutoscaleReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid FleetAutoscaleReview", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Scale(r.Context(), review.Request)
+	if err != nil {
+		logger.Error("Failed to scale fleet", zap.String("fleet", review.Request.Name), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	review.Response = resp
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		logger.Error("Failed to write response", zap.Error(err))
+	}
+}
@@ -0,0 +1,27 @@
+package scaler
+
+import "time"
+
+// Policy configures how a fleet is sized from the matchmaking queue.
+type Policy struct {
+	MinReplicas int32 `json:"minReplicas"`
+	MaxReplicas int32 `json:"maxReplicas"`
+	// Headroom is the amount of Ready GameServers kept on top of those needed by the queue
+	Headroom int32 `json:"headroom"`
+	// MatchesPerServer is how many matches a (high density) GameServer runs at once
+	MatchesPerServer int `json:"matchesPerServer"`
+	// ScaleUpCooldown and ScaleDownCooldown are the minimum time since the last scale before the fleet is scaled again
+	ScaleUpCooldown   time.Duration `json:"scaleUpCooldown"`
+	ScaleDownCooldown time.Duration `json:"scaleDownCooldown"`
+}
+
+// Policies of fleets by fleet name. Fleets not present use the default policy.
+var Policies = map[string]Policy{
+	"simulated-gameserver": {
+		MinReplicas:       2,
+		MaxReplicas:       10,
+		Headroom:          1,
+		MatchesPerServer:  10, // maxRunningGames of the simulated gameserver
+		ScaleDownCooldown: 2 * time.Minute,
+	},
+}
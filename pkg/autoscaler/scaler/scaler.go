@@ -0,0 +1,231 @@
+// Package scaler implements the Agones FleetAutoscaler webhook policy, sizing each fleet from the matchmaking queue
+// rather than a static buffer, so GameServers are ready by the time a countdown or a burst of tickets needs them.
+package scaler
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	autoscalingv1 "agones.dev/agones/pkg/apis/autoscaling/v1"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/mmfcontrol"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/modeprofile/config"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"open-match.dev/open-match/pkg/matchfunction"
+	"open-match.dev/open-match/pkg/pb"
+	"sync"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+type Scaler struct {
+	queryServiceClient pb.QueryServiceClient
+	control            *mmfcontrol.Client
+	defaultPolicy      Policy
+
+	lock sync.Mutex
+	// lastScaled map[fleetName]time
+	lastScaled map[string]time.Time
+}
+
+func NewScaler(queryServiceClient pb.QueryServiceClient, control *mmfcontrol.Client, defaultPolicy Policy) *Scaler {
+	return &Scaler{
+		queryServiceClient: queryServiceClient,
+		control:            control,
+		defaultPolicy:      defaultPolicy,
+		lastScaled:         make(map[string]time.Time),
+	}
+}
+
+// Scale decides the replicas of the fleet in the request.
+func (s *Scaler) Scale(ctx context.Context, req *autoscalingv1.FleetAutoscaleRequest) (*autoscalingv1.FleetAutoscaleResponse, error) {
+	policy := s.policy(req.Name)
+	status := req.Status
+
+	pendingMatches, err := s.pendingMatches(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	spareSlots, err := s.spareSlots(ctx, req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	needed := int32(0)
+	if pendingMatches > spareSlots {
+		needed = int32(ceilDiv(pendingMatches-spareSlots, policy.MatchesPerServer))
+	}
+	desired := clamp(status.AllocatedReplicas+status.ReservedReplicas+needed+policy.Headroom, policy.MinReplicas, policy.MaxReplicas)
+	desired = s.applyCooldown(req.Name, policy, status.Replicas, desired)
+
+	logger.Info("Scaling fleet",
+		zap.String("fleet", req.Name),
+		zap.Int("pendingMatches", pendingMatches),
+		zap.Int("spareSlots", spareSlots),
+		zap.Int32("replicas", status.Replicas),
+		zap.Int32("desired", desired),
+	)
+
+	return &autoscalingv1.FleetAutoscaleResponse{
+		UID:      req.UID,
+		Scale:    desired != status.Replicas,
+		Replicas: desired,
+	}, nil
+}
+
+func (s *Scaler) policy(fleetName string) Policy {
+	policy, ok := Policies[fleetName]
+	if !ok {
+		policy = s.defaultPolicy
+	}
+	if policy.MatchesPerServer <= 0 {
+		policy.MatchesPerServer = 1
+	}
+	return policy
+}
+
+// applyCooldown keeps the current replicas if the fleet was scaled in the same direction's cooldown.
+func (s *Scaler) applyCooldown(fleetName string, policy Policy, current int32, desired int32) int32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if desired == current {
+		return desired
+	}
+	cooldown := policy.ScaleUpCooldown
+	if desired < current {
+		cooldown = policy.ScaleDownCooldown
+	}
+	if time.Since(s.lastScaled[fleetName]) < cooldown {
+		return current
+	}
+	s.lastScaled[fleetName] = time.Now()
+	return desired
+}
+
+// pendingMatches estimates the matches the queue will create on the fleet. Countdown modes make a match for each
+// countdown the match function is running, sized by the players in its pool, other modes make matches as soon as a pool
+// has MinPlayers. Matches of weighted fleets are split by weight.
+func (s *Scaler) pendingMatches(ctx context.Context, fleetName string) (int, error) {
+	var countdowns []mmfcontrol.Countdown
+	fetchedCountdowns := false
+
+	pending := 0.0
+	for _, profile := range config.ModeProfiles {
+		if !profile.HasFleet(fleetName) {
+			continue
+		}
+
+		if profile.UseCountdown {
+			if !fetchedCountdowns {
+				var err error
+				if countdowns, err = s.control.Countdowns(ctx); err != nil {
+					return 0, fmt.Errorf("failed to get countdowns: %w", err)
+				}
+				fetchedCountdowns = true
+			}
+			for _, countdown := range countdowns {
+				if countdown.Mode != profile.Name {
+					continue
+				}
+				route, ok := profile.Route(countdown.Pool)
+				if !ok || !route.HasFleet(fleetName) {
+					continue
+				}
+				matches := ceilDiv(len(countdown.Players), profile.MaxPlayers)
+				pending += float64(matches) * fleetShare(route.Fleets, fleetName)
+			}
+			continue
+		}
+
+		var poolTickets map[string][]*pb.Ticket
+		err := deadline.OpenMatch.Call(ctx, "QueryPools", func(ctx context.Context) error {
+			var err error
+			poolTickets, err = matchfunction.QueryPools(ctx, s.queryServiceClient, profile.MatchProfile.GetPools())
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		for poolName, tickets := range poolTickets {
+			route, ok := profile.Route(poolName)
+			if !ok || !route.HasFleet(fleetName) || len(tickets) < profile.MinPlayers {
+				continue
+			}
+			matches := ceilDiv(len(tickets), profile.MaxPlayers)
+			pending += float64(matches) * fleetShare(route.Fleets, fleetName)
+		}
+	}
+	return int(pending + 0.999), nil
+}
+
+// spareSlots counts the games that allocated high density GameServers of the fleet can still run.
+// GameServers tracking games with a Counter report their free slots whether or not they are labelled for
+// reallocation yet, others can run at least one more while labelled.
+func (s *Scaler) spareSlots(ctx context.Context, namespace string, fleetName string) (int, error) {
+	selector := labels.SelectorFromSet(labels.Set{"agones.dev/fleet": fleetName})
+	var gameServers *agonesv1.GameServerList
+	err := deadline.Kubernetes.Call(ctx, "ListGameServers", func(ctx context.Context) error {
+		var err error
+		gameServers, err = kubernetes.AgonesClient.AgonesV1().GameServers(namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	spare := 0
+	for _, gs := range gameServers.Items {
+		if gs.Status.State != agonesv1.GameServerStateAllocated {
+			continue
+		}
+		if games, ok := gs.Status.Counters["games"]; ok {
+			if games.Capacity > games.Count {
+				spare += int(games.Capacity - games.Count)
+			}
+			continue
+		}
+		if gs.ObjectMeta.Labels["agones.dev/sdk-should-allocate"] == "true" {
+			spare++
+		}
+	}
+	return spare, nil
+}
+
+func fleetShare(fleets []modeprofile.WeightedFleet, fleetName string) float64 {
+	total, weight := 0, 0
+	for _, fleet := range fleets {
+		total += fleet.Weight
+		if fleet.Name == fleetName {
+			weight += fleet.Weight
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(weight) / float64(total)
+}
+
+func ceilDiv(a int, b int) int {
+	if b <= 0 {
+		return a
+	}
+	return (a + b - 1) / b
+}
+
+func clamp(v int32, min int32, max int32) int32 {
+	if v < min {
+		return min
+	}
+	if max > 0 && v > max {
+		return max
+	}
+	return v
+}
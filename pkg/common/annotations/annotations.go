@@ -0,0 +1,187 @@
+// Package annotations defines the schema of the annotations the director patches onto a GameServer
+// when it is allocated, and that the GameServer parses to find its match.
+//
+// The schema is versioned by SchemaVersionKey. Adding annotations is backwards compatible,
+// renaming or changing the format of one requires a new version.
+package annotations
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"open-match.dev/open-match/pkg/pb"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SchemaVersion = "1"
+
+	SchemaVersionKey      = "openmatch.dev/schema-version"
+	MatchIdKey            = "openmatch.dev/match-id"
+	ExpectedPlayersKey    = "openmatch.dev/expected-players"
+	ExpectedSpectatorsKey = "openmatch.dev/expected-spectators"
+	BackfillIdKey         = "openmatch.dev/backfill-id"
+	BackfilledAtKey       = "openmatch.dev/backfilled-at"
+	AssignedMatchIdKey    = "openmatch.dev/assigned-match-id"
+	CancelledMatchIdKey   = "openmatch.dev/cancelled-match-id"
+	// ExtensionsKey lists the extension keys of the match, those of previous matches remain after the patch
+	ExtensionsKey = "openmatch.dev/extensions"
+	// BackfillsKey is set by the director on a GameServer with open backfills, it isn't part of the match
+	BackfillsKey = "openmatch.dev/backfills"
+
+	// ExtensionPrefix namespaces match extensions (e.g. mode, map, teams, bots, region)
+	// so match-extension.openmatch.dev/mode is the 'mode' extension of the match.
+	ExtensionPrefix = "match-extension.openmatch.dev/"
+
+	// LastAllocatedKey is set by Agones when a GameServer is allocated
+	LastAllocatedKey = "agones.dev/last-allocated"
+)
+
+// Match is the match a GameServer has been allocated for
+type Match struct {
+	SchemaVersion      string            `json:"schemaVersion"`
+	MatchId            string            `json:"matchId"`
+	ExpectedPlayers    []string          `json:"expectedPlayers"`
+	ExpectedSpectators []string          `json:"expectedSpectators"`
+	BackfillId         string            `json:"backfillId"`
+	Extensions         map[string]string `json:"extensions"`
+}
+
+// Encode creates the annotations for the match. Expected players and spectators, the backfill ID and the list of
+// extension keys are always present as annotations are patched and would otherwise keep the previous allocation's values.
+// Extensions of a previous allocation can't be removed by the patch, so Decode only reads those listed by ExtensionsKey.
+func Encode(m Match) (map[string]string, error) {
+	players, err := encodeIds(m.ExpectedPlayers)
+	if err != nil {
+		return nil, err
+	}
+	spectators, err := encodeIds(m.ExpectedSpectators)
+	if err != nil {
+		return nil, err
+	}
+
+	extensionKeys := make([]string, 0, len(m.Extensions))
+	for k := range m.Extensions {
+		extensionKeys = append(extensionKeys, k)
+	}
+	sort.Strings(extensionKeys)
+	extensions, err := encodeIds(extensionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{
+		SchemaVersionKey:      SchemaVersion,
+		MatchIdKey:            m.MatchId,
+		ExpectedPlayersKey:    players,
+		ExpectedSpectatorsKey: spectators,
+		BackfillIdKey:         m.BackfillId,
+		ExtensionsKey:         extensions,
+	}
+	for k, v := range m.Extensions {
+		result[ExtensionPrefix+k] = v
+	}
+	return result, nil
+}
+
+// Decode parses the match from a GameServer's annotations. Without ExtensionsKey, as written by older directors,
+// every extension annotation is returned.
+func Decode(annotations map[string]string) (Match, error) {
+	version := annotations[SchemaVersionKey]
+	if version != "" && version != SchemaVersion {
+		return Match{}, fmt.Errorf("unsupported annotation schema version %s", version)
+	}
+
+	m := Match{
+		SchemaVersion: version,
+		MatchId:       annotations[MatchIdKey],
+		BackfillId:    annotations[BackfillIdKey],
+		Extensions:    make(map[string]string),
+	}
+
+	if v, ok := annotations[ExpectedPlayersKey]; ok {
+		if err := json.Unmarshal([]byte(v), &m.ExpectedPlayers); err != nil {
+			return Match{}, fmt.Errorf("could not parse player-ids (%s) annotation: %w", v, err)
+		}
+	}
+	if v, ok := annotations[ExpectedSpectatorsKey]; ok {
+		if err := json.Unmarshal([]byte(v), &m.ExpectedSpectators); err != nil {
+			return Match{}, fmt.Errorf("could not parse spectator-ids (%s) annotation: %w", v, err)
+		}
+	}
+
+	if v, ok := annotations[ExtensionsKey]; ok {
+		var keys []string
+		if err := json.Unmarshal([]byte(v), &keys); err != nil {
+			return Match{}, fmt.Errorf("could not parse extensions (%s) annotation: %w", v, err)
+		}
+		for _, k := range keys {
+			if value, ok := annotations[ExtensionPrefix+k]; ok {
+				m.Extensions[k] = value
+			}
+		}
+		return m, nil
+	}
+
+	for k, v := range annotations {
+		if strings.HasPrefix(k, ExtensionPrefix) {
+			m.Extensions[strings.TrimPrefix(k, ExtensionPrefix)] = v
+		}
+	}
+	return m, nil
+}
+
+// ExtensionsFromMatch converts the match's extensions to annotation values.
+// Wrapped primitives are written as their value, any other message as JSON.
+func ExtensionsFromMatch(match *pb.Match) (map[string]string, error) {
+	result := make(map[string]string, len(match.GetExtensions()))
+	for k, a := range match.GetExtensions() {
+		v, err := extensionValue(a)
+		if err != nil {
+			return nil, fmt.Errorf("extension %s: %w", k, err)
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+func extensionValue(a *anypb.Any) (string, error) {
+	msg, err := a.UnmarshalNew()
+	if err != nil {
+		return "", err
+	}
+
+	switch v := msg.(type) {
+	case *wrapperspb.StringValue:
+		return v.Value, nil
+	case *wrapperspb.BoolValue:
+		return strconv.FormatBool(v.Value), nil
+	case *wrapperspb.Int32Value:
+		return strconv.FormatInt(int64(v.Value), 10), nil
+	case *wrapperspb.Int64Value:
+		return strconv.FormatInt(v.Value, 10), nil
+	case *wrapperspb.DoubleValue:
+		return strconv.FormatFloat(v.Value, 'f', -1, 64), nil
+	default:
+		b, err := protojson.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+func encodeIds(ids []string) (string, error) {
+	if ids == nil {
+		ids = []string{}
+	}
+	b, err := json.Marshal(ids)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
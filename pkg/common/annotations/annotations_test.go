@@ -0,0 +1,189 @@
+package annotations
+
+import (
+	"reflect"
+	"testing"
+)
+
+// patch applies the encoded annotations over a GameServer's like the allocation's MetaPatch, keeping the others.
+func patch(gsAnnotations map[string]string, m Match) (map[string]string, error) {
+	encoded, err := Encode(m)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(gsAnnotations)+len(encoded))
+	for k, v := range gsAnnotations {
+		result[k] = v
+	}
+	for k, v := range encoded {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		match Match
+	}{
+		{
+			name: "players and extensions",
+			match: Match{
+				MatchId:         "match-1",
+				ExpectedPlayers: []string{"player-1", "player-2"},
+				Extensions:      map[string]string{"mode": "block_sumo", "map": "castle"},
+			},
+		},
+		{
+			name: "spectators",
+			match: Match{
+				MatchId:            "match-1",
+				ExpectedSpectators: []string{"spectator-1"},
+			},
+		},
+		{
+			name: "backfill",
+			match: Match{
+				MatchId:         "match-1",
+				ExpectedPlayers: []string{"player-3"},
+				BackfillId:      "backfill-1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := Encode(test.match)
+			if err != nil {
+				t.Fatalf("failed to encode: %v", err)
+			}
+			decoded, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+
+			expected := test.match
+			expected.SchemaVersion = SchemaVersion
+			if expected.ExpectedPlayers == nil {
+				expected.ExpectedPlayers = []string{}
+			}
+			if expected.ExpectedSpectators == nil {
+				expected.ExpectedSpectators = []string{}
+			}
+			if expected.Extensions == nil {
+				expected.Extensions = map[string]string{}
+			}
+			if !reflect.DeepEqual(decoded, expected) {
+				t.Errorf("expected %+v, got %+v", expected, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeIgnoresThePreviousMatchAfterAPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous Match
+		next     Match
+	}{
+		{
+			name:     "backfill id",
+			previous: Match{MatchId: "match-1", BackfillId: "backfill-1"},
+			next:     Match{MatchId: "match-2"},
+		},
+		{
+			name:     "extensions",
+			previous: Match{MatchId: "match-1", Extensions: map[string]string{"mode": "block_sumo", "map": "castle"}},
+			next:     Match{MatchId: "match-2", Extensions: map[string]string{"mode": "block_sumo"}},
+		},
+		{
+			name:     "no extensions",
+			previous: Match{MatchId: "match-1", Extensions: map[string]string{"map": "castle"}},
+			next:     Match{MatchId: "match-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gsAnnotations, err := patch(map[string]string{LastAllocatedKey: "2023-01-02T15:04:05Z"}, test.previous)
+			if err != nil {
+				t.Fatalf("failed to patch the previous match: %v", err)
+			}
+			gsAnnotations, err = patch(gsAnnotations, test.next)
+			if err != nil {
+				t.Fatalf("failed to patch the next match: %v", err)
+			}
+
+			decoded, err := Decode(gsAnnotations)
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if decoded.MatchId != test.next.MatchId {
+				t.Errorf("expected match %s, got %s", test.next.MatchId, decoded.MatchId)
+			}
+			if decoded.BackfillId != test.next.BackfillId {
+				t.Errorf("expected backfill id %q, got %q", test.next.BackfillId, decoded.BackfillId)
+			}
+			if len(decoded.Extensions) != len(test.next.Extensions) {
+				t.Errorf("expected extensions %v, got %v", test.next.Extensions, decoded.Extensions)
+			}
+			for k, v := range test.next.Extensions {
+				if decoded.Extensions[k] != v {
+					t.Errorf("expected extension %s=%s, got %q", k, v, decoded.Extensions[k])
+				}
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    Match
+		expectErr   bool
+	}{
+		{
+			name: "without extensions key",
+			annotations: map[string]string{
+				MatchIdKey:                       "match-1",
+				ExtensionPrefix + "mode":         "lobby",
+				"agones.dev/sdk-gameserver-name": "lobby-abcde",
+			},
+			expected: Match{MatchId: "match-1", Extensions: map[string]string{"mode": "lobby"}},
+		},
+		{
+			name:        "unknown schema version",
+			annotations: map[string]string{SchemaVersionKey: "2", MatchIdKey: "match-1"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid players",
+			annotations: map[string]string{MatchIdKey: "match-1", ExpectedPlayersKey: "player-1"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid extensions key",
+			annotations: map[string]string{MatchIdKey: "match-1", ExtensionsKey: "mode"},
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := Decode(test.annotations)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", decoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+// Package appconfig loads the configuration of a binary into a struct from, in increasing precedence,
+// the struct's default values, an optional JSON file, environment variables and command line flags.
+//
+// Fields are configured with tags:
+//
+//	Namespace string `json:"namespace" env:"NAMESPACE" flag:"namespace" usage:"The namespace GameServers run in"`
+//
+// Fields tagged `secret:"true"` are masked when the configuration is logged.
+// The file is given by the -config flag or the CONFIG_FILE environment variable.
+package appconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Validator is implemented by configurations that check their values after loading.
+type Validator interface {
+	Validate() error
+}
+
+// Source is where the value of a field was loaded from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Entry is the effective value of a field, as logged at startup
+type Entry struct {
+	Name   string `json:"name"`
+	Value  any    `json:"value"`
+	Source Source `json:"source"`
+}
+
+type field struct {
+	name   string
+	value  reflect.Value
+	json   string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	source Source
+}
+
+// Load loads cfg, a pointer to a struct holding the defaults, from os.Args, validates and logs it.
+func Load(name string, cfg any) error {
+	entries, err := LoadArgs(name, cfg, os.Args[1:])
+	if err != nil {
+		return err
+	}
+	logger.Info("Loaded configuration", zap.String("name", name), zap.Any("config", entries))
+	return nil
+}
+
+// MustLoad is Load, exiting if the configuration can't be loaded or is invalid.
+func MustLoad(name string, cfg any) {
+	if err := Load(name, cfg); err != nil {
+		logger.Fatal("Invalid configuration", zap.String("name", name), zap.Error(err))
+	}
+}
+
+// LoadArgs loads cfg like Load from the given arguments and returns the effective configuration without logging it.
+func LoadArgs(name string, cfg any, args []string) ([]Entry, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+	fields := collectFields(v.Elem())
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "Optional JSON configuration file")
+	flagValues := make(map[string]*flagValue)
+	for _, f := range fields {
+		if f.flag == "" {
+			continue
+		}
+		fv := &flagValue{def: format(f.value), isBool: f.value.Kind() == reflect.Bool}
+		flagValues[f.flag] = fv
+		fs.Var(fv, f.flag, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := set(f, value, SourceEnv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		fv, ok := flagValues[fl.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, f := range fields {
+			if f.flag == fl.Name {
+				flagErr = set(f, fv.value, SourceFlag)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if validator, ok := cfg.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]Entry, 0, len(fields))
+	for _, f := range fields {
+		entry := Entry{Name: f.name, Value: f.value.Interface(), Source: f.source}
+		if f.secret && !f.value.IsZero() {
+			entry.Value = "*****"
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// EnvOr returns the environment variable, or the fallback if it isn't set.
+// It is used by shared packages that connect to services when they are initialised.
+func EnvOr(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// DefaultNamespace is the namespace GameServers run in if NAMESPACE isn't set.
+const DefaultNamespace = "towerdefence"
+
+// Namespace returns the namespace GameServers run in from the NAMESPACE environment variable, which the manifests
+// set to the pod's namespace, or the DefaultNamespace. It is used by shared packages that look up GameServers,
+// and exits if the namespace isn't a valid namespace name.
+func Namespace() string {
+	namespace := EnvOr("NAMESPACE", DefaultNamespace)
+	if err := ValidateNamespace(namespace); err != nil {
+		logger.Fatal("Invalid configuration", zap.String("name", "NAMESPACE"), zap.Error(err))
+	}
+	return namespace
+}
+
+// ValidateNamespace checks the namespace is a valid namespace name.
+func ValidateNamespace(namespace string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace %q: %v", namespace, errs)
+	}
+	return nil
+}
+
+func collectFields(v reflect.Value) []*field {
+	var fields []*field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		f := &field{
+			name:   sf.Name,
+			value:  v.Field(i),
+			json:   sf.Tag.Get("json"),
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			source: SourceDefault,
+		}
+		if f.json == "" {
+			f.json = sf.Name
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func loadFile(path string, fields []*field) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for _, f := range fields {
+		value, ok := values[f.json]
+		if !ok {
+			continue
+		}
+		if err := set(f, fmt.Sprint(value), SourceFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func set(f *field, value string, source Source) error {
+	v := f.value
+	var err error
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+	case v.CanInt():
+		var n int64
+		n, err = strconv.ParseInt(value, 10, v.Type().Bits())
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(value, 64)
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.name, v.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q from %s: %w", f.name, value, source, err)
+	}
+	f.source = source
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagValue records the raw value of a flag so only flags that were set override the file and environment.
+type flagValue struct {
+	def    string
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
@@ -0,0 +1,96 @@
+// Package deadline bounds outbound calls with a timeout for each kind of dependency, derived from the caller's
+// context, so a hung dependency can't block a director loop or the match function forever.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"matchmaker/pkg/common/metrics"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	timeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "call_timeouts_total",
+		Help:      "Outbound calls that ran out of time",
+	}, []string{"dependency", "call"})
+
+	// FetchMatches bounds the FetchMatches stream, which includes the match function's run.
+	FetchMatches = New("fetchMatches", 10*time.Second)
+	// OpenMatch bounds other calls to the Open Match services, e.g. AssignTickets and ReleaseTickets.
+	OpenMatch = New("openMatch", 5*time.Second)
+	// Allocation bounds each attempt to allocate a GameServer.
+	Allocation = New("allocation", 10*time.Second)
+	// Kubernetes bounds calls to the Kubernetes API, e.g. getting pods and patching GameServers.
+	Kubernetes = New("kubernetes", 5*time.Second)
+	// PlayerTracker bounds calls to the player tracker and the friend service.
+	PlayerTracker = New("playerTracker", 3*time.Second)
+	// GameServer bounds calls to the matchmaking service of game servers, e.g. MatchFound.
+	GameServer = New("gameServer", 3*time.Second)
+	// MatchFunction bounds calls to the match function's control API, e.g. cancelling a countdown.
+	MatchFunction = New("matchFunction", 3*time.Second)
+)
+
+// TimeoutError is returned when a call ran out of time. It isn't returned when the caller's own context
+// was done first, e.g. the run timed out or the director is shutting down, as that's not the dependency's fault.
+type TimeoutError struct {
+	Dependency string
+	Call       string
+	Timeout    time.Duration
+	Err        error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s %s timed out after %s: %v", e.Dependency, e.Call, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// IsTimeout reports whether the error is, or wraps, a TimeoutError.
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+// Deadline is the timeout of calls to a dependency.
+type Deadline struct {
+	dependency string
+	timeout    atomic.Int64
+}
+
+func New(dependency string, timeout time.Duration) *Deadline {
+	d := &Deadline{dependency: dependency}
+	d.Set(timeout)
+	return d
+}
+
+// Set changes the timeout, it is called with the binary's configuration on startup.
+func (d *Deadline) Set(timeout time.Duration) {
+	d.timeout.Store(int64(timeout))
+}
+
+func (d *Deadline) Timeout() time.Duration {
+	return time.Duration(d.timeout.Load())
+}
+
+// Call runs fn with a context that is done after the timeout or when ctx is done, whichever is first.
+// A call that runs out of time is counted and its error returned as a TimeoutError.
+func (d *Deadline) Call(ctx context.Context, call string, fn func(ctx context.Context) error) error {
+	timeout := d.Timeout()
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := fn(callCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		timeouts.WithLabelValues(d.dependency, call).Inc()
+		return &TimeoutError{Dependency: d.dependency, Call: call, Timeout: timeout, Err: err}
+	}
+	return err
+}
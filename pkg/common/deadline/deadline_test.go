@@ -0,0 +1,109 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+// waitForDone blocks until the call's context is done, like a hung dependency.
+func waitForDone(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// parent returns the caller's context
+		parent        func() (context.Context, context.CancelFunc)
+		fn            func(ctx context.Context) error
+		expectErr     error
+		expectTimeout bool
+	}{
+		{
+			name:    "succeeds",
+			timeout: time.Second,
+			fn:      func(ctx context.Context) error { return nil },
+		},
+		{
+			name:      "fails before the timeout",
+			timeout:   time.Second,
+			fn:        func(ctx context.Context) error { return errFailed },
+			expectErr: errFailed,
+		},
+		{
+			name:          "runs out of time",
+			timeout:       10 * time.Millisecond,
+			fn:            waitForDone,
+			expectErr:     context.DeadlineExceeded,
+			expectTimeout: true,
+		},
+		{
+			name:    "fails after the timeout with its own error",
+			timeout: 10 * time.Millisecond,
+			fn: func(ctx context.Context) error {
+				<-ctx.Done()
+				return errFailed
+			},
+			expectErr:     errFailed,
+			expectTimeout: true,
+		},
+		{
+			name:    "caller's context is cancelled",
+			timeout: time.Second,
+			parent: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			fn:        waitForDone,
+			expectErr: context.Canceled,
+		},
+		{
+			name:    "caller's deadline is first",
+			timeout: time.Second,
+			parent: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			fn:        waitForDone,
+			expectErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if test.parent != nil {
+				ctx, cancel = test.parent()
+			}
+			defer cancel()
+
+			err := New("test", test.timeout).Call(ctx, "call", test.fn)
+			if !errors.Is(err, test.expectErr) {
+				t.Errorf("expected %v, got %v", test.expectErr, err)
+			}
+			if IsTimeout(err) != test.expectTimeout {
+				t.Errorf("expected timeout %t, got %v", test.expectTimeout, err)
+			}
+		})
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	d := New("playerTracker", time.Second)
+	d.Set(10 * time.Millisecond)
+
+	err := d.Call(context.Background(), "getPlayerServer", waitForDone)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	if timeoutErr.Dependency != "playerTracker" || timeoutErr.Call != "getPlayerServer" || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Errorf("expected the dependency, call and timeout set, got %+v", timeoutErr)
+	}
+}
@@ -0,0 +1,73 @@
+package friends
+
+import (
+	"context"
+	"fmt"
+	"github.com/EmortalMC/grpc-api-specs/gen/go/service/friend"
+	"github.com/EmortalMC/grpc-api-specs/gen/go/service/player_tracker"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"matchmaker/pkg/common/appconfig"
+	"matchmaker/pkg/common/playertracker"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+
+	friendEndpoint = appconfig.EnvOr("FRIEND_ENDPOINT", "friend-manager.towerdefence.svc:9090")
+
+	enabled = true
+	client  = createFriendClient()
+)
+
+// GetFriendServerCounts returns how many of the players' friends are online on each server.
+// The players themselves are not counted.
+func GetFriendServerCounts(ctx context.Context, playerIds []string) (map[string]int, error) {
+	if !enabled || !playertracker.Enabled {
+		return nil, fmt.Errorf("friend or player tracker service unavailable")
+	}
+
+	players := make(map[string]bool, len(playerIds))
+	for _, playerId := range playerIds {
+		players[playerId] = true
+	}
+
+	var friendIds []string
+	for _, playerId := range playerIds {
+		resp, err := client.GetFriendList(ctx, &friend.PlayerRequest{PlayerId: playerId})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range resp.GetFriends() {
+			if !players[f.GetId()] {
+				friendIds = append(friendIds, f.GetId())
+			}
+		}
+	}
+
+	if len(friendIds) == 0 {
+		return nil, nil
+	}
+
+	serverResp, err := playertracker.Client.GetPlayerServers(ctx, &player_tracker.PlayersRequest{PlayerIds: friendIds})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, server := range serverResp.GetPlayerServers() {
+		counts[server.GetServerId()]++
+	}
+	return counts, nil
+}
+
+func createFriendClient() friend.FriendClient {
+	conn, err := grpc.Dial(friendEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`))
+	if err != nil {
+		logger.Error("Failed to connect to Friend service", zap.Error(err))
+		enabled = false
+	}
+
+	return friend.NewFriendClient(conn)
+}
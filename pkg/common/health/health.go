@@ -0,0 +1,224 @@
+// Package health tracks whether the components of a binary are still working from when each last succeeded,
+// and serves the result as Kubernetes liveness and readiness probes.
+//
+// A component is stale once it hasn't succeeded for longer than its threshold. Stale components make the binary
+// unready, and stale live components, e.g. a loop that should be running, fail its liveness as well.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Checker holds the last results of the tracked components. It is safe for concurrent use.
+type Checker struct {
+	lock sync.Mutex
+	// components map[name]*component
+	components map[string]*component
+}
+
+type component struct {
+	threshold time.Duration
+	live      bool
+	// since is when the component started being tracked, it has its threshold from then to first succeed
+	since       time.Time
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastError   string
+}
+
+// Report is the state of the tracked components returned by the probes.
+type Report struct {
+	Healthy    bool                       `json:"healthy"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+type ComponentStatus struct {
+	Healthy     bool      `json:"healthy"`
+	Threshold   string    `json:"threshold"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		components: make(map[string]*component),
+	}
+}
+
+// Track starts tracking a component that must succeed at least every threshold.
+// If live, the component must also finish an attempt every threshold or the binary is considered stuck.
+// Tracking a component again resets it.
+func (c *Checker) Track(name string, threshold time.Duration, live bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.components[name] = &component{
+		threshold: threshold,
+		live:      live,
+		since:     time.Now(),
+	}
+}
+
+// Untrack stops tracking a component, e.g. a profile's loop when the director loses its Lease.
+func (c *Checker) Untrack(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.components, name)
+}
+
+// Record records the result of an attempt of a tracked component. Results of untracked components are ignored.
+func (c *Checker) Record(name string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	comp, ok := c.components[name]
+	if !ok {
+		return
+	}
+	comp.lastAttempt = time.Now()
+	if err != nil {
+		comp.lastError = err.Error()
+		return
+	}
+	comp.lastSuccess = comp.lastAttempt
+	comp.lastError = ""
+}
+
+// Probe tracks a component that is checked every interval until the context is done.
+// The first check is made straight away.
+func (c *Checker) Probe(ctx context.Context, name string, interval time.Duration, threshold time.Duration, check func(ctx context.Context) error) {
+	c.Track(name, threshold, false)
+	defer c.Untrack(name)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := check(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Health check failed", zap.String("component", name), zap.Error(err))
+		}
+		c.Record(name, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Liveness reports the live components, which are unhealthy if they haven't finished an attempt within their threshold.
+func (c *Checker) Liveness() Report {
+	return c.report(func(comp *component, now time.Time) bool {
+		return !comp.live || !isStale(comp.since, comp.lastAttempt, comp.threshold, now)
+	}, true)
+}
+
+// Readiness reports every component, which are unhealthy if they haven't succeeded within their threshold.
+func (c *Checker) Readiness() Report {
+	return c.report(func(comp *component, now time.Time) bool {
+		return !isStale(comp.since, comp.lastSuccess, comp.threshold, now)
+	}, false)
+}
+
+func (c *Checker) report(healthy func(comp *component, now time.Time) bool, liveOnly bool) Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	report := Report{Healthy: true, Components: make(map[string]ComponentStatus, len(c.components))}
+	for name, comp := range c.components {
+		if liveOnly && !comp.live {
+			continue
+		}
+		componentStatus := ComponentStatus{
+			Healthy:     healthy(comp, now),
+			Threshold:   comp.threshold.String(),
+			LastAttempt: comp.lastAttempt,
+			LastSuccess: comp.lastSuccess,
+			LastError:   comp.lastError,
+		}
+		report.Components[name] = componentStatus
+		report.Healthy = report.Healthy && componentStatus.Healthy
+	}
+	return report
+}
+
+// Unhealthy returns the names of the report's unhealthy components.
+func (r Report) Unhealthy() []string {
+	var names []string
+	for name, componentStatus := range r.Components {
+		if !componentStatus.Healthy {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isStale(since time.Time, last time.Time, threshold time.Duration, now time.Time) bool {
+	if last.Before(since) {
+		last = since
+	}
+	return now.Sub(last) > threshold
+}
+
+// LivenessHandler serves Liveness, responding 503 Service Unavailable if it isn't healthy.
+func (c *Checker) LivenessHandler() http.Handler {
+	return reportHandler(c.Liveness)
+}
+
+// ReadinessHandler serves Readiness, responding 503 Service Unavailable if it isn't healthy.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return reportHandler(c.Readiness)
+}
+
+func reportHandler(report func() Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := report()
+		code := http.StatusOK
+		if !rep.Healthy {
+			code = http.StatusServiceUnavailable
+			logger.Warn("Health probe failed", zap.String("path", r.URL.Path), zap.Strings("unhealthy", rep.Unhealthy()))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			logger.Error("Failed to write health report", zap.Error(err))
+		}
+	})
+}
+
+// CheckConn checks the server of a gRPC connection is serving with the standard health service.
+// Servers that don't implement it still answered, so are considered reachable.
+func CheckConn(ctx context.Context, conn *grpc.ClientConn) error {
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%s is %s", conn.Target(), resp.GetStatus())
+	}
+	return nil
+}
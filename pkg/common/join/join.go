@@ -0,0 +1,162 @@
+package join
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/EmortalMC/grpc-api-specs/gen/go/service/player_tracker"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/utils/strings/slices"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/appconfig"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/playertracker"
+	"matchmaker/pkg/common/utils"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+const (
+	// TargetGameServerExtension is the match extension containing the name of the GameServer to join
+	TargetGameServerExtension = "targetGameServer"
+	// TargetMatchIdExtension is the match extension containing the ID of the match to join
+	TargetMatchIdExtension = "targetMatchId"
+	// TargetClusterExtension is the match extension containing the cluster of the GameServer to join
+	TargetClusterExtension = "targetCluster"
+	// SpectatorExtension is present on matches made of spectator tickets
+	SpectatorExtension = "spectator"
+	// PlayersList is the Agones List of the players on GameServers using Counters and Lists
+	PlayersList = "players"
+)
+
+var (
+	namespace = appconfig.Namespace()
+
+	ErrTargetOffline = errors.New("target player is not online")
+	ErrTargetNoGame  = errors.New("target player is not in a matchmade game")
+	ErrTargetFull    = errors.New("target game does not have enough capacity")
+	// ErrTargetCapacityUnknown is returned when the target's GameServer tracks its players neither with player
+	// tracking nor a 'players' List, so it can't be told whether the joining players fit
+	ErrTargetCapacityUnknown = errors.New("target game does not report its player capacity")
+	// ErrTargetIncompatible is returned when the target's GameServer doesn't support the joining client's version
+	ErrTargetIncompatible = errors.New("target game does not support the client version")
+)
+
+// Target is the game a join ticket should be sent to
+type Target struct {
+	GameServerName string `json:"gameServerName"`
+	MatchId        string `json:"matchId"`
+	// Cluster the GameServer runs in, see kubernetes.GetCluster
+	Cluster string `json:"cluster,omitempty"`
+	// Labels of the target's GameServer, used to check it supports the joining clients
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// FindTarget resolves the GameServer and match the target player is currently in.
+// playerCount is the amount of players that want to join and is checked against the GameServer's capacity.
+// Spectators don't take a player slot so should pass a playerCount of 0.
+func FindTarget(ctx context.Context, targetPlayerId string, playerCount int) (Target, error) {
+	if !playertracker.Enabled {
+		return Target{}, ErrTargetOffline
+	}
+
+	var resp *player_tracker.GetPlayerServerResponse
+	err := deadline.PlayerTracker.Call(ctx, "GetPlayerServer", func(ctx context.Context) error {
+		var err error
+		resp, err = playertracker.Client.GetPlayerServer(ctx, &player_tracker.PlayerRequest{PlayerId: targetPlayerId})
+		return err
+	})
+	if err != nil {
+		return Target{}, fmt.Errorf("failed to get player server: %w", err)
+	}
+	if resp.GetServer() == nil {
+		return Target{}, ErrTargetOffline
+	}
+
+	// The server ID is the pod name, which is the same as the GameServer name.
+	var gs *agonesv1.GameServer
+	var cluster kubernetes.Cluster
+	err = deadline.Kubernetes.Call(ctx, "GetGameServer", func(ctx context.Context) error {
+		var err error
+		gs, cluster, err = kubernetes.FindGameServer(ctx, namespace, resp.GetServer().GetServerId())
+		return err
+	})
+	if err != nil {
+		return Target{}, fmt.Errorf("failed to get gameserver %s: %w", resp.GetServer().GetServerId(), err)
+	}
+
+	if gs.Status.State != agonesv1.GameServerStateAllocated {
+		return Target{}, ErrTargetNoGame
+	}
+
+	matchId := gs.ObjectMeta.Annotations[annotations.MatchIdKey]
+	if matchId == "" || !isInGame(gs, targetPlayerId) {
+		return Target{}, ErrTargetNoGame
+	}
+
+	if playerCount > 0 {
+		available, ok := availablePlayerSlots(gs)
+		if !ok {
+			return Target{}, ErrTargetCapacityUnknown
+		}
+		if available < int64(playerCount) {
+			return Target{}, ErrTargetFull
+		}
+	}
+
+	return Target{
+		GameServerName: gs.ObjectMeta.Name,
+		MatchId:        matchId,
+		Cluster:        cluster.Name,
+		Labels:         gs.ObjectMeta.Labels,
+	}, nil
+}
+
+// availablePlayerSlots returns how many more players fit on the GameServer, from player tracking or the 'players'
+// List of GameServers using Counters and Lists. It is unknown if the GameServer tracks its players with neither.
+func availablePlayerSlots(gs *agonesv1.GameServer) (int64, bool) {
+	if players := gs.Status.Players; players != nil {
+		return players.Capacity - players.Count, true
+	}
+	if players, ok := gs.Status.Lists[PlayersList]; ok {
+		return players.Capacity - int64(len(players.Values)), true
+	}
+	return 0, false
+}
+
+// isInGame checks if the player is tracked by Agones or was expected in the last allocation of the GameServer.
+func isInGame(gs *agonesv1.GameServer, playerId string) bool {
+	if players := gs.Status.Players; players != nil && slices.Contains(players.IDs, playerId) {
+		return true
+	}
+	if players, ok := gs.Status.Lists[PlayersList]; ok && slices.Contains(players.Values, playerId) {
+		return true
+	}
+
+	var expectedPlayers []string
+	if err := json.Unmarshal([]byte(gs.ObjectMeta.Annotations[annotations.ExpectedPlayersKey]), &expectedPlayers); err != nil {
+		return false
+	}
+	return slices.Contains(expectedPlayers, playerId)
+}
+
+// TargetFromMatch returns the Target of a match created for join tickets, if present.
+func TargetFromMatch(match *pb.Match) (Target, bool) {
+	gsName, ok := utils.GetMatchStringExtension(match, TargetGameServerExtension)
+	if !ok {
+		return Target{}, false
+	}
+	matchId, ok := utils.GetMatchStringExtension(match, TargetMatchIdExtension)
+	if !ok {
+		return Target{}, false
+	}
+	cluster, _ := utils.GetMatchStringExtension(match, TargetClusterExtension)
+	return Target{GameServerName: gsName, MatchId: matchId, Cluster: cluster}, true
+}
+
+// IsSpectatorMatch checks if the match was made of spectator tickets
+func IsSpectatorMatch(match *pb.Match) bool {
+	_, ok := match.GetExtensions()[SpectatorExtension]
+	return ok
+}
@@ -0,0 +1,171 @@
+package join
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"agones.dev/agones/pkg/client/clientset/versioned/fake"
+	"context"
+	"errors"
+	"github.com/EmortalMC/grpc-api-specs/gen/go/service/player_tracker"
+	"google.golang.org/grpc"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/playertracker"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"testing"
+)
+
+const targetPlayerId = "target"
+
+// fakePlayerTracker answers GetPlayerServer with the server the player is on, if any.
+type fakePlayerTracker struct {
+	player_tracker.PlayerTrackerClient
+	servers map[string]string
+}
+
+func (f *fakePlayerTracker) GetPlayerServer(_ context.Context, req *player_tracker.PlayerRequest, _ ...grpc.CallOption) (*player_tracker.GetPlayerServerResponse, error) {
+	serverId, ok := f.servers[req.PlayerId]
+	if !ok {
+		return &player_tracker.GetPlayerServerResponse{}, nil
+	}
+	return &player_tracker.GetPlayerServerResponse{Server: &player_tracker.OnlineServer{ServerId: serverId}}, nil
+}
+
+// useFakes replaces the player tracker and Agones clients until the test ends.
+func useFakes(t *testing.T, servers map[string]string, gameServers ...*agonesv1.GameServer) {
+	t.Helper()
+	enabled, client, agonesClient := playertracker.Enabled, playertracker.Client, kubernetes.AgonesClient
+	t.Cleanup(func() {
+		playertracker.Enabled, playertracker.Client, kubernetes.AgonesClient = enabled, client, agonesClient
+	})
+
+	agones := fake.NewSimpleClientset()
+	for _, gs := range gameServers {
+		if _, err := agones.AgonesV1().GameServers(namespace).Create(context.Background(), gs, v1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create gameserver: %v", err)
+		}
+	}
+	playertracker.Enabled = true
+	playertracker.Client = &fakePlayerTracker{servers: servers}
+	kubernetes.AgonesClient = agones
+}
+
+func gameServer(state agonesv1.GameServerState, matchId string, expectedPlayers string) *agonesv1.GameServer {
+	return &agonesv1.GameServer{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "block-sumo-abcde",
+			Namespace: namespace,
+			Labels:    map[string]string{"agones.dev/fleet": "block-sumo"},
+			Annotations: map[string]string{
+				annotations.MatchIdKey:         matchId,
+				annotations.ExpectedPlayersKey: expectedPlayers,
+			},
+		},
+		Status: agonesv1.GameServerStatus{State: state},
+	}
+}
+
+func withPlayerTracking(gs *agonesv1.GameServer, count int64, capacity int64, ids ...string) *agonesv1.GameServer {
+	gs.Status.Players = &agonesv1.PlayerStatus{Count: count, Capacity: capacity, IDs: ids}
+	return gs
+}
+
+func withPlayersList(gs *agonesv1.GameServer, capacity int64, values ...string) *agonesv1.GameServer {
+	gs.Status.Lists = map[string]agonesv1.ListStatus{PlayersList: {Capacity: capacity, Values: values}}
+	return gs
+}
+
+func TestFindTarget(t *testing.T) {
+	onGameServer := map[string]string{targetPlayerId: "block-sumo-abcde"}
+
+	tests := []struct {
+		name        string
+		servers     map[string]string
+		gameServer  *agonesv1.GameServer
+		playerCount int
+		expectErr   error
+	}{
+		{
+			name:        "target offline",
+			servers:     map[string]string{},
+			gameServer:  gameServer(agonesv1.GameServerStateAllocated, "match-1", `["target"]`),
+			playerCount: 1,
+			expectErr:   ErrTargetOffline,
+		},
+		{
+			name:        "gameserver not allocated",
+			servers:     onGameServer,
+			gameServer:  gameServer(agonesv1.GameServerStateReady, "", "[]"),
+			playerCount: 1,
+			expectErr:   ErrTargetNoGame,
+		},
+		{
+			name:        "target not in the game",
+			servers:     onGameServer,
+			gameServer:  withPlayerTracking(gameServer(agonesv1.GameServerStateAllocated, "match-1", `["other"]`), 1, 10, "other"),
+			playerCount: 1,
+			expectErr:   ErrTargetNoGame,
+		},
+		{
+			name:        "player tracking has capacity",
+			servers:     onGameServer,
+			gameServer:  withPlayerTracking(gameServer(agonesv1.GameServerStateAllocated, "match-1", "[]"), 8, 10, targetPlayerId),
+			playerCount: 2,
+		},
+		{
+			name:        "player tracking is full",
+			servers:     onGameServer,
+			gameServer:  withPlayerTracking(gameServer(agonesv1.GameServerStateAllocated, "match-1", "[]"), 9, 10, targetPlayerId),
+			playerCount: 2,
+			expectErr:   ErrTargetFull,
+		},
+		{
+			name:        "players list has capacity",
+			servers:     onGameServer,
+			gameServer:  withPlayersList(gameServer(agonesv1.GameServerStateAllocated, "match-1", "[]"), 3, targetPlayerId),
+			playerCount: 2,
+		},
+		{
+			name:        "players list is full",
+			servers:     onGameServer,
+			gameServer:  withPlayersList(gameServer(agonesv1.GameServerStateAllocated, "match-1", "[]"), 2, targetPlayerId),
+			playerCount: 2,
+			expectErr:   ErrTargetFull,
+		},
+		{
+			name:        "capacity unknown",
+			servers:     onGameServer,
+			gameServer:  gameServer(agonesv1.GameServerStateAllocated, "match-1", `["target"]`),
+			playerCount: 1,
+			expectErr:   ErrTargetCapacityUnknown,
+		},
+		{
+			name:        "spectators don't need capacity",
+			servers:     onGameServer,
+			gameServer:  gameServer(agonesv1.GameServerStateAllocated, "match-1", `["target"]`),
+			playerCount: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useFakes(t, test.servers, test.gameServer)
+
+			target, err := FindTarget(context.Background(), targetPlayerId, test.playerCount)
+			if test.expectErr != nil {
+				if !errors.Is(err, test.expectErr) {
+					t.Errorf("expected %v, got %v", test.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected a target, got %v", err)
+			}
+			if target.GameServerName != "block-sumo-abcde" || target.MatchId != "match-1" || target.Cluster != kubernetes.LocalCluster {
+				t.Errorf("expected match-1 on block-sumo-abcde in the local cluster, got %+v", target)
+			}
+			if target.Labels["agones.dev/fleet"] != "block-sumo" {
+				t.Errorf("expected the gameserver's labels, got %v", target.Labels)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package matchprofile
 
 import (
 	"fmt"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils"
 	"open-match.dev/open-match/pkg/pb"
 )
 
@@ -10,13 +12,40 @@ func CommonProfile(name string, gameName string) *pb.MatchProfile {
 		Name: name,
 		Pools: []*pb.Pool{
 			{
-				Name: "all",
-				TagPresentFilters: []*pb.TagPresentFilter{
-					{
-						Tag: fmt.Sprintf("game.%s", gameName),
-					},
+				Name:              modeprofile.DefaultPool,
+				TagPresentFilters: gameFilters(gameName),
+			},
+		},
+	}
+}
+
+// VersionedProfile creates a pool for each route that only contains tickets of clients the route supports.
+func VersionedProfile(name string, gameName string, routes []modeprofile.VersionRoute) *pb.MatchProfile {
+	pools := make([]*pb.Pool, 0, len(routes))
+	for _, route := range routes {
+		pools = append(pools, &pb.Pool{
+			Name:              route.PoolName,
+			TagPresentFilters: gameFilters(gameName),
+			DoubleRangeFilters: []*pb.DoubleRangeFilter{
+				{
+					DoubleArg: utils.ClientVersionArg,
+					Min:       float64(route.MinVersion),
+					Max:       float64(route.MaxVersion),
 				},
 			},
+		})
+	}
+
+	return &pb.MatchProfile{
+		Name:  name,
+		Pools: pools,
+	}
+}
+
+func gameFilters(gameName string) []*pb.TagPresentFilter {
+	return []*pb.TagPresentFilter{
+		{
+			Tag: fmt.Sprintf("game.%s", gameName),
 		},
 	}
 }
@@ -0,0 +1,40 @@
+// Package metrics holds what the Prometheus metrics of the matchmaker have in common.
+// Each package registers its own metrics, named matchmaker_* and labelled by the ModeProfile.Name they belong to.
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const (
+	Namespace = "matchmaker"
+
+	// ProfileLabel is the label of the ModeProfile.Name a metric belongs to
+	ProfileLabel = "profile"
+	// FleetLabel is the label of the fleet a match was sent to
+	FleetLabel = "fleet"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Handler serves the metrics of every package in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Serve hosts the metrics on /metrics of the given port, for binaries without an HTTP server of their own.
+// This blocks until the server fails.
+func Serve(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	logger.Info("Serving metrics", zap.Int("port", port))
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		logger.Error("Metrics server failed", zap.Error(err))
+	}
+}
@@ -0,0 +1,59 @@
+package mmf
+
+import (
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/modeprofile"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// FillBackfills fills backfills requested by GameServers with tickets in the pool
+// and updates the open slots of each backfill. No GameServer is allocated for these matches,
+// the director assigns the tickets to the GameServer when it acknowledges the backfill.
+// returns: created matches, remaining tickets that are unused.
+func FillBackfills(profile modeprofile.ModeProfile, tickets []*pb.Ticket, backfills []*pb.Backfill) ([]*pb.Match, []*pb.Ticket) {
+	var matches []*pb.Match
+	for _, backfill := range backfills {
+		if len(tickets) == 0 {
+			break
+		}
+
+		slots, err := GetBackfillSlots(backfill)
+		if err != nil {
+			logger.Error("Failed to get backfill slots", zap.String("backfillId", backfill.Id), zap.Error(err))
+			continue
+		}
+		if slots <= 0 {
+			continue
+		}
+
+		var matchTickets []*pb.Ticket
+		for slots > 0 && len(tickets) > 0 {
+			matchTickets = append(matchTickets, tickets[0])
+			tickets = tickets[1:]
+			slots--
+		}
+
+		if err := setBackfillSlots(backfill, slots); err != nil {
+			logger.Error("Failed to set backfill slots", zap.String("backfillId", backfill.Id), zap.Error(err))
+			tickets = append(matchTickets, tickets...)
+			continue
+		}
+
+		matches = append(matches, newBackfillMatch(profile, matchTickets, backfill))
+	}
+
+	return matches, tickets
+}
+
+func newBackfillMatch(profile modeprofile.ModeProfile, tickets []*pb.Ticket, backfill *pb.Backfill) *pb.Match {
+	return &pb.Match{
+		MatchId:       uuid.New().String(),
+		MatchProfile:  profile.Name,
+		MatchFunction: profile.Name,
+		Tickets:       tickets,
+		Backfill:      backfill,
+		// the GameServer is already running the match
+		AllocateGameserver: false,
+	}
+}
@@ -1,12 +1,20 @@
 package mmf
 
 import (
+	"context"
+	"errors"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
 	"log"
+	"matchmaker/pkg/common/metrics"
 	"matchmaker/pkg/common/modeprofile"
 	"matchmaker/pkg/common/notifier"
 	"matchmaker/pkg/common/utils"
 	"open-match.dev/open-match/pkg/pb"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -17,31 +25,121 @@ import (
 // todo: we should return fewer errors, log them and handle gracefully to avoid 'freezes' in matchmaking on an error.
 
 var (
-	// countdowns map[poolName]teleportTime
-	countdowns = make(map[string]time.Time)
-	// countdownPlayers map[poolName][]playerId
-	countdownPlayers = make(map[string][]string)
+	countdownsActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "countdowns_active",
+		Help:      "Countdowns waiting for more players before their match is made",
+	}, []string{metrics.ProfileLabel})
+	countdownsCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "countdowns_cancelled_total",
+		Help:      "Countdowns cancelled because players left the pool",
+	}, []string{metrics.ProfileLabel})
+
+	// countdownLock guards the countdowns, which are changed by runs of every profile and the control API
+	countdownLock sync.Mutex
+	// countdowns map[countdownKey]teleportTime
+	countdowns = make(map[countdownKey]time.Time)
+	// countdownPlayers map[countdownKey][]playerId
+	countdownPlayers = make(map[countdownKey][]string)
 )
 
+// ErrNoCountdown is returned when acting on a pool that has no countdown.
+var ErrNoCountdown = errors.New("pool has no countdown")
+
+// countdownKey is a pool of a mode, pools of different modes can have the same name
+type countdownKey struct {
+	mode string
+	pool string
+}
+
+// Countdown is a pool waiting for more players before its match is made at TeleportTime.
+type Countdown struct {
+	Mode         string    `json:"mode"`
+	Pool         string    `json:"pool"`
+	TeleportTime time.Time `json:"teleportTime"`
+	Players      []string  `json:"players"`
+}
+
+// Countdowns returns the active countdowns, ordered by mode and pool.
+func Countdowns() []Countdown {
+	countdownLock.Lock()
+	defer countdownLock.Unlock()
+
+	result := make([]Countdown, 0, len(countdowns))
+	for key, teleportTime := range countdowns {
+		result = append(result, Countdown{
+			Mode:         key.mode,
+			Pool:         key.pool,
+			TeleportTime: teleportTime,
+			Players:      countdownPlayers[key],
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Mode != result[j].Mode {
+			return result[i].Mode < result[j].Mode
+		}
+		return result[i].Pool < result[j].Pool
+	})
+	return result
+}
+
+// ForceStartCountdown ends the pool's countdown now, so its match is made by the next run with the players in the pool.
+func ForceStartCountdown(mode string, poolName string) error {
+	countdownLock.Lock()
+	defer countdownLock.Unlock()
+
+	key := countdownKey{mode: mode, pool: poolName}
+	if _, ok := countdowns[key]; !ok {
+		return ErrNoCountdown
+	}
+	countdowns[key] = time.Now()
+	logger.Info("Countdown force started", zap.String("mode", mode), zap.String("pool", poolName))
+	return nil
+}
+
+// CancelCountdown cancels the pool's countdown and notifies its players. The pool starts a new countdown at the next
+// run if it still has enough players, so the mode should be paused to stop it entirely.
+func CancelCountdown(mode string, poolName string) error {
+	countdownLock.Lock()
+	key := countdownKey{mode: mode, pool: poolName}
+	if _, ok := countdowns[key]; !ok {
+		countdownLock.Unlock()
+		return ErrNoCountdown
+	}
+	endCountdown(key, true)
+	players := countdownPlayers[key]
+	countdownLock.Unlock()
+
+	logger.Info("Countdown cancelled", zap.String("mode", mode), zap.String("pool", poolName))
+	notifier.NotifyPlayersOfCancelledCountdown(context.Background(), players)
+	return nil
+}
+
 // MakeCountdownMatches
 // if ticket count >= max players, create a match
 // if ticket count < min players, stop
 // if ticket count < max players, create a countdown until the match is made anyway
 // if ticket count < max players and countdown is over, create a match
 func MakeCountdownMatches(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) {
+	countdownLock.Lock()
+	defer countdownLock.Unlock()
+
+	key := countdownKey{mode: profile.Name, pool: pool.Name}
 	// update countdownPlayers. We need to update this every time, because players can leave the pool.
-	countdownPlayers[pool.Name] = utils.ExtractPlayerIdsFromTickets(tickets)
+	countdownPlayers[key] = utils.ExtractPlayerIdsFromTickets(tickets)
 
 	if len(tickets) == 0 {
 		return nil, nil
 	}
 
-	countdown, hasCountdown := countdowns[pool.Name]
+	countdown, hasCountdown := countdowns[key]
 
 	// delete countdown if players have left the queue
 	if hasCountdown && len(tickets) < profile.MinPlayers {
-		delete(countdowns, pool.Name)
-		notifier.NotifyPlayersOfCancelledCountdown(countdownPlayers[pool.Name])
+		endCountdown(key, true)
+		// players are notified without holding the countdownLock
+		go notifier.NotifyPlayersOfCancelledCountdown(context.Background(), countdownPlayers[key])
 	}
 
 	var matches []*pb.Match
@@ -53,7 +151,7 @@ func MakeCountdownMatches(profile modeprofile.ModeProfile, pool *pb.Pool, ticket
 
 		// delete the countdown as we have made a match
 		// there is no need to notify here as the server is notified by the director when a gameserver is assigned instead
-		delete(countdowns, pool.Name)
+		endCountdown(key, false)
 
 		log.Printf("makeFullMatches done: tickets: %d", len(tickets))
 	}
@@ -71,17 +169,18 @@ func MakeCountdownMatches(profile modeprofile.ModeProfile, pool *pb.Pool, ticket
 
 		// delete the countdown as we have made a match
 		// there is no need to notify here as the server is notified by the director when a gameserver is assigned instead
-		delete(countdowns, pool.Name)
+		endCountdown(key, false)
 		return matches, nil
 	}
 
 	// still tickets left, create a countdown
 	if len(tickets) >= profile.MinPlayers {
-		if !hasCountdown {
-			countdowns[pool.Name] = time.Now().Add(10 * time.Second)
+		if _, ok := countdowns[key]; !ok {
+			countdowns[key] = time.Now().Add(10 * time.Second)
+			countdownsActive.WithLabelValues(profile.Name).Inc()
 		}
 		// notify players of the countdown
-		notifier.NotifyPlayersOfPendingMatch(countdownPlayers[pool.Name], countdowns[pool.Name])
+		go notifier.NotifyPlayersOfPendingMatch(context.Background(), "", countdownPlayers[key], countdowns[key])
 	}
 
 	log.Printf("MakeCountdownMatches finished: tickets: %d", len(tickets))
@@ -89,43 +188,18 @@ func MakeCountdownMatches(profile modeprofile.ModeProfile, pool *pb.Pool, ticket
 	return matches, nil
 }
 
-//// handleBackfills fills current backfills with tickets in the pool
-//// and updates the backfill with: the new tickets, the new open slots
-//func handleBackfills(profile modeprofile.ModeProfile, tickets []*pb.Ticket, backfills []*pb.Backfill) ([]*pb.Match, []*pb.Ticket, []*pb.Backfill, error) {
-//	var matches []*pb.Match
-//	for _, backfill := range backfills {
-//		slots, err := getBackfillSlots(backfill)
-//		if err != nil {
-//			return nil, tickets, backfills, err
-//		}
-//		if slots == 0 {
-//			continue
-//		}
-//		// fill the backfill with tickets from the tickets array
-//		// remove tickets from the tickets array
-//		// update the open slots of the backfill
-//		var matchTickets []*pb.Ticket
-//		for slots > 0 && len(tickets) > 0 {
-//			matchTickets = append(matchTickets, tickets[0])
-//			tickets = tickets[1:]
-//			slots--
-//		}
-//
-//		// the backfill has been updated. we must update the available slots
-//		// and create a Match to send players to the game server
-//		if len(matchTickets) > 0 {
-//			err := setBackfillSlots(backfill, slots)
-//			if err != nil {
-//				return matches, tickets, backfills, err
-//			}
-//			match := newMatch(uuid.New(), profile, matchTickets, backfill)
-//			matches = append(matches, match)
-//		}
-//
-//	}
-//
-//	return matches, tickets, backfills, nil
-//}
+// endCountdown removes the pool's countdown if it has one, either because it was cancelled or its match was made.
+// The countdownLock must be held.
+func endCountdown(key countdownKey, cancelled bool) {
+	if _, ok := countdowns[key]; !ok {
+		return
+	}
+	delete(countdowns, key)
+	countdownsActive.WithLabelValues(key.mode).Dec()
+	if cancelled {
+		countdownsCancelled.WithLabelValues(key.mode).Inc()
+	}
+}
 
 // makeFullMatches creates full matches from tickets in the pool.
 // returns: creates matches, remaining tickets that are unused.
@@ -179,6 +253,11 @@ func newMatch(id uuid.UUID, profile modeprofile.ModeProfile, tickets []*pb.Ticke
 		AllocateGameserver: true,
 	}
 
+	// the mode is propagated to the GameServer as a match extension annotation
+	if err := utils.SetMatchStringExtension(match, "mode", profile.Name); err != nil {
+		logger.Error("Failed to set mode extension", zap.String("matchId", match.MatchId), zap.Error(err))
+	}
+
 	return match
 }
 
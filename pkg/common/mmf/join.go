@@ -0,0 +1,116 @@
+package mmf
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/join"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+var logger, _ = zap.NewProduction()
+
+// MakeJoinMatches creates a match for every group of tickets that target the same player.
+// Tickets whose target can't be joined (offline, not in a game or the game is full) fall back
+// to normal matchmaking and are returned with the tickets that have no target.
+// The target's GameServer must be on the route's fleet, otherwise the tickets' clients may not be able to join it.
+func MakeJoinMatches(ctx context.Context, profile modeprofile.ModeProfile, route modeprofile.VersionRoute, tickets []*pb.Ticket) ([]*pb.Match, []*pb.Ticket) {
+	var remaining []*pb.Ticket
+	// joinTickets map[targetPlayerId][]ticket
+	joinTickets := make(map[string][]*pb.Ticket)
+	for _, ticket := range tickets {
+		target, ok := utils.ExtractTargetPlayerIdFromTicket(ticket)
+		if !ok {
+			remaining = append(remaining, ticket)
+			continue
+		}
+		joinTickets[target] = append(joinTickets[target], ticket)
+	}
+
+	var matches []*pb.Match
+	for targetPlayerId, targetTickets := range joinTickets {
+		target, err := join.FindTarget(ctx, targetPlayerId, len(targetTickets))
+		if err == nil && !route.Selects(target.Labels) {
+			err = join.ErrTargetIncompatible
+		}
+		if err != nil {
+			logger.Info("Falling back to normal matchmaking for join tickets", zap.String("targetPlayerId", targetPlayerId), zap.Error(err))
+			remaining = append(remaining, targetTickets...)
+			continue
+		}
+
+		match, err := newJoinMatch(profile, targetTickets, target)
+		if err != nil {
+			logger.Error("Failed to create join match", zap.String("targetPlayerId", targetPlayerId), zap.Error(err))
+			remaining = append(remaining, targetTickets...)
+			continue
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, remaining
+}
+
+func newJoinMatch(profile modeprofile.ModeProfile, tickets []*pb.Ticket, target join.Target) (*pb.Match, error) {
+	match := newMatch(uuid.New(), profile, tickets)
+	if err := utils.SetMatchStringExtension(match, join.TargetGameServerExtension, target.GameServerName); err != nil {
+		return nil, err
+	}
+	if err := utils.SetMatchStringExtension(match, join.TargetMatchIdExtension, target.MatchId); err != nil {
+		return nil, err
+	}
+	if err := utils.SetMatchStringExtension(match, join.TargetClusterExtension, target.Cluster); err != nil {
+		return nil, err
+	}
+	return match, nil
+}
+
+// MakeSpectatorMatches creates a match for every group of spectator tickets that target the same player.
+// Spectators are never counted against MaxPlayers. Spectator tickets without a target, or whose target can't be
+// spectated, fall back to normal matchmaking like join tickets and are returned with the other tickets.
+func MakeSpectatorMatches(ctx context.Context, profile modeprofile.ModeProfile, route modeprofile.VersionRoute, tickets []*pb.Ticket) ([]*pb.Match, []*pb.Ticket) {
+	var remaining []*pb.Ticket
+	// spectatorTickets map[targetPlayerId][]ticket
+	spectatorTickets := make(map[string][]*pb.Ticket)
+	for _, ticket := range tickets {
+		if !utils.IsSpectatorTicket(ticket) {
+			remaining = append(remaining, ticket)
+			continue
+		}
+		target, ok := utils.ExtractTargetPlayerIdFromTicket(ticket)
+		if !ok {
+			logger.Info("Falling back to normal matchmaking for spectator ticket with no target player", zap.String("ticketId", ticket.Id))
+			remaining = append(remaining, ticket)
+			continue
+		}
+		spectatorTickets[target] = append(spectatorTickets[target], ticket)
+	}
+
+	var matches []*pb.Match
+	for targetPlayerId, targetTickets := range spectatorTickets {
+		target, err := join.FindTarget(ctx, targetPlayerId, 0)
+		if err == nil && !route.Selects(target.Labels) {
+			err = join.ErrTargetIncompatible
+		}
+		if err != nil {
+			logger.Info("Falling back to normal matchmaking for spectator tickets", zap.String("targetPlayerId", targetPlayerId), zap.Error(err))
+			remaining = append(remaining, targetTickets...)
+			continue
+		}
+
+		match, err := newJoinMatch(profile, targetTickets, target)
+		if err == nil {
+			err = utils.SetMatchStringExtension(match, join.SpectatorExtension, "true")
+		}
+		if err != nil {
+			logger.Error("Failed to create spectator match", zap.String("targetPlayerId", targetPlayerId), zap.Error(err))
+			remaining = append(remaining, targetTickets...)
+			continue
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, remaining
+}
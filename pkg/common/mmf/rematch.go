@@ -0,0 +1,47 @@
+package mmf
+
+import (
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// MakeRematchMatches keeps players that opted in to a rematch together by creating a new match
+// for every rematch group. Groups larger than MaxPlayers are split into multiple matches.
+// Players left over that can't make MinPlayers fall back to normal matchmaking and are returned with the
+// tickets that aren't part of a rematch.
+func MakeRematchMatches(profile modeprofile.ModeProfile, tickets []*pb.Ticket) ([]*pb.Match, []*pb.Ticket) {
+	var remaining []*pb.Ticket
+	// groups map[rematchId][]ticket
+	groups := make(map[string][]*pb.Ticket)
+	for _, ticket := range tickets {
+		rematchId, ok := utils.ExtractRematchIdFromTicket(ticket)
+		if !ok {
+			remaining = append(remaining, ticket)
+			continue
+		}
+		groups[rematchId] = append(groups[rematchId], ticket)
+	}
+
+	var matches []*pb.Match
+	for rematchId, groupTickets := range groups {
+		for len(groupTickets) >= profile.MinPlayers && len(groupTickets) > 0 {
+			size := len(groupTickets)
+			if size > profile.MaxPlayers {
+				size = profile.MaxPlayers
+			}
+			matches = append(matches, newMatch(uuid.New(), profile, groupTickets[:size]))
+			groupTickets = groupTickets[size:]
+		}
+
+		if len(groupTickets) > 0 {
+			logger.Info("Not enough players for rematch, falling back to normal matchmaking",
+				zap.String("rematchId", rematchId), zap.Int("players", len(groupTickets)))
+			remaining = append(remaining, groupTickets...)
+		}
+	}
+
+	return matches, remaining
+}
@@ -3,10 +3,12 @@ package mmf
 import (
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"open-match.dev/open-match/pkg/pb"
 )
 
-func getBackfillSlots(backfill *pb.Backfill) (int32, error) {
+// GetBackfillSlots returns the amount of players the backfill is still waiting for
+func GetBackfillSlots(backfill *pb.Backfill) (int32, error) {
 	if backfill.GetExtensions() != nil {
 		if wrappedValue, ok := backfill.GetExtensions()["open_slots"]; ok {
 			var val wrappers.Int32Value
@@ -45,7 +47,8 @@ func newSearchFields(pool *pb.Pool) *pb.SearchFields {
 	if rangeFilters != nil {
 		doubleArgs := make(map[string]float64)
 		for _, f := range rangeFilters {
-			doubleArgs[f.DoubleArg] = (f.Max - f.Min) / 2
+			// the middle of the range so the backfill is in the pool
+			doubleArgs[f.DoubleArg] = f.Min + (f.Max-f.Min)/2
 		}
 
 		if len(doubleArgs) > 0 {
@@ -69,7 +72,7 @@ func newSearchFields(pool *pb.Pool) *pb.SearchFields {
 	tagFilters := pool.GetTagPresentFilters()
 
 	if tagFilters != nil {
-		tags := make([]string, len(tagFilters))
+		tags := make([]string, 0, len(tagFilters))
 		for _, f := range tagFilters {
 			tags = append(tags, f.Tag)
 		}
@@ -81,3 +84,28 @@ func newSearchFields(pool *pb.Pool) *pb.SearchFields {
 
 	return &searchFields
 }
+
+// NewBackfill creates a backfill for a match running on a GameServer that needs more players
+func NewBackfill(pool *pb.Pool, matchId string, gameServerName string, slots int32) (*pb.Backfill, error) {
+	originalMatchId, err := anypb.New(wrapperspb.String(matchId))
+	if err != nil {
+		return nil, err
+	}
+	gameServer, err := anypb.New(wrapperspb.String(gameServerName))
+	if err != nil {
+		return nil, err
+	}
+
+	backfill := &pb.Backfill{
+		SearchFields: newSearchFields(pool),
+		Extensions: map[string]*anypb.Any{
+			"originalMatchId": originalMatchId,
+			"gameServer":      gameServer,
+		},
+	}
+
+	if err := setBackfillSlots(backfill, slots); err != nil {
+		return nil, err
+	}
+	return backfill, nil
+}
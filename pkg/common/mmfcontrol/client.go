@@ -0,0 +1,113 @@
+// Package mmfcontrol is the client of the match function's control API, used by the director's admin API and the
+// autoscaler to read and act on countdowns, which only the match function knows about.
+package mmfcontrol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"matchmaker/pkg/common/deadline"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Countdown is an active countdown as reported by the match function.
+type Countdown struct {
+	Mode         string    `json:"mode"`
+	Pool         string    `json:"pool"`
+	TeleportTime time.Time `json:"teleportTime"`
+	Players      []string  `json:"players"`
+}
+
+type CountdownRequest struct {
+	Mode string `json:"mode"`
+	Pool string `json:"pool"`
+}
+
+// Error is an error response of the control API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("match function: %s", e.Message)
+}
+
+// Client calls the control API at endpoint, authenticated with the token shared with the match function.
+// Calls are bounded by deadline.MatchFunction.
+type Client struct {
+	endpoint string
+	token    string
+}
+
+func NewClient(endpoint string, token string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+	}
+}
+
+// Countdowns returns the active countdowns, ordered by mode and pool.
+func (c *Client) Countdowns(ctx context.Context) ([]Countdown, error) {
+	var countdowns []Countdown
+	err := deadline.MatchFunction.Call(ctx, "GetCountdowns", func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+"/v1/countdowns", nil)
+		if err != nil {
+			return err
+		}
+		return c.do(req, &countdowns)
+	})
+	return countdowns, err
+}
+
+// StartCountdown ends the pool's countdown now, so its match is made by the mode's next run.
+func (c *Client) StartCountdown(ctx context.Context, mode string, pool string) error {
+	return c.post(ctx, "StartCountdown", "/v1/countdowns/start", CountdownRequest{Mode: mode, Pool: pool})
+}
+
+// CancelCountdown cancels the pool's countdown and notifies its players.
+func (c *Client) CancelCountdown(ctx context.Context, mode string, pool string) error {
+	return c.post(ctx, "CancelCountdown", "/v1/countdowns/cancel", CountdownRequest{Mode: mode, Pool: pool})
+}
+
+func (c *Client) post(ctx context.Context, call string, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return deadline.MatchFunction.Call(ctx, call, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return c.do(req, nil)
+	})
+}
+
+// do makes an authenticated request, returning an Error with the status code of failed requests.
+func (c *Client) do(req *http.Request, result any) error {
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+			body.Error = resp.Status
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -1,72 +1,63 @@
 package config
 
 import (
-	v1 "agones.dev/agones/pkg/apis/allocation/v1"
+	_ "embed"
+	"errors"
 	"fmt"
-	"matchmaker/pkg/common/matchprofile"
-	"matchmaker/pkg/common/mmf"
+	"go.uber.org/zap"
 	"matchmaker/pkg/common/modeprofile"
-	"matchmaker/pkg/common/selector"
-	"open-match.dev/open-match/pkg/pb"
+	"os"
 )
 
-var ModeProfiles = map[string]modeprofile.ModeProfile{
-	"marathon": {
-		Name:       "marathon",
-		PoolName:   "marathon",
-		FleetName:  "marathon",
-		MinPlayers: 1,
-		MaxPlayers: 100, // The server knows this is a singleplayer game, we can reduce load by using backfilling.
-		Selector: func(profile modeprofile.ModeProfile, match *pb.Match) *v1.GameServerAllocation {
-			return selector.CommonPlayerBasedSelector(profile, match, int64(len(match.Tickets)))
-		},
-		MatchProfile: matchprofile.CommonProfile("marathon", "marathon"),
-		MatchFunction: func(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) {
-			return mmf.MakeInstantMatches(profile, tickets)
-		},
-	},
-	"lobby": {
-		Name:       "lobby",
-		PoolName:   "lobby",
-		FleetName:  "lobby",
-		MinPlayers: 1,
-		MaxPlayers: 50,
-		Selector: func(profile modeprofile.ModeProfile, match *pb.Match) *v1.GameServerAllocation {
-			return selector.CommonPlayerBasedSelector(profile, match, int64(len(match.Tickets)))
-		},
-		MatchProfile: matchprofile.CommonProfile("lobby", "lobby"),
-		MatchFunction: func(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) {
-			return mmf.MakeInstantMatches(profile, tickets)
-		},
-	},
-	"block_sumo": {
-		Name:       "block_sumo",
-		PoolName:   "block_sumo",
-		FleetName:  "block-sumo",
-		MinPlayers: 2,
-		MaxPlayers: 12,
-		Selector: func(profile modeprofile.ModeProfile, match *pb.Match) *v1.GameServerAllocation {
-			return selector.CommonSelector(profile, match)
-		},
-		MatchProfile: matchprofile.CommonProfile("block_sumo", "block_sumo"),
-		MatchFunction: func(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) {
-			return mmf.MakeCountdownMatches(profile, pool, tickets)
-		},
-	},
-	"minesweeper": {
-		Name:       "minesweeper",
-		PoolName:   "minesweeper",
-		FleetName:  "minesweeper",
-		MinPlayers: 1,
-		MaxPlayers: 5,
-		Selector: func(profile modeprofile.ModeProfile, match *pb.Match) *v1.GameServerAllocation {
-			return selector.CommonPlayerBasedSelector(profile, match, int64(len(match.Tickets)))
-		},
-		MatchProfile: matchprofile.CommonProfile("minesweeper", "minesweeper"),
-		MatchFunction: func(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) {
-			return mmf.MakeInstantMatches(profile, tickets)
-		},
-	},
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// defaultModes are the modes used without a modes file, in the File schema.
+//
+//go:embed modes.json
+var defaultModes []byte
+
+// ModeProfiles are the modes by name, the defaultModes until Load is called.
+//
+// Modes supporting several client versions set versionRoutes with a pool each, e.g.
+//
+//	"versionRoutes": [{"poolName": "761", "minVersion": 761, "maxVersion": 761, "fleets": [{"name": "lobby", "weight": 1}]}, ...]
+//
+// A new GameServer build can be rolled out to a share of new matches with a weighted canary fleet, e.g.
+//
+//	"fleets": [{"name": "lobby", "weight": 95}, {"name": "lobby-canary", "weight": 5}]
+var ModeProfiles = mustParseDefaults()
+
+func mustParseDefaults() map[string]modeprofile.ModeProfile {
+	profiles, err := Parse(defaultModes)
+	if err != nil {
+		logger.Fatal("Invalid default modes", zap.Error(err))
+	}
+	return profiles
+}
+
+// Load replaces the ModeProfiles with the modes file at path, e.g. a ConfigMap mounted by the manifests.
+// The defaults are kept if the path isn't set or the file doesn't exist, so the ConfigMap is optional.
+// It must be called before the profiles are used.
+func Load(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Warn("No modes file, using the default modes", zap.String("modesFile", path))
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read modes file: %w", err)
+	}
+
+	profiles, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid modes file %s: %w", path, err)
+	}
+	ModeProfiles = profiles
+	return nil
 }
 
 func GetModeProfileByMatchProfileName(name string) (modeprofile.ModeProfile, error) {
@@ -77,3 +68,12 @@ func GetModeProfileByMatchProfileName(name string) (modeprofile.ModeProfile, err
 	}
 	return modeprofile.ModeProfile{}, fmt.Errorf("no mode profile found for match profile %s", name)
 }
+
+func GetModeProfileByFleetName(name string) (modeprofile.ModeProfile, error) {
+	for _, profile := range ModeProfiles {
+		if profile.HasFleet(name) {
+			return profile, nil
+		}
+	}
+	return modeprofile.ModeProfile{}, fmt.Errorf("no mode profile found for fleet %s", name)
+}
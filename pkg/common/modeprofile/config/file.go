@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"matchmaker/pkg/common/matchprofile"
+	"matchmaker/pkg/common/mmf"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/selector"
+	"matchmaker/pkg/common/selector/definition"
+	"open-match.dev/open-match/pkg/pb"
+	"time"
+)
+
+// File is the schema of the modes file, e.g.
+//
+//	{
+//	  "definitions": {"lobbyFirst": {"scheduling": "Packed", "selectors": [...]}},
+//	  "modes": [{"name": "lobby", "fleets": [{"name": "lobby", "weight": 1}], "minPlayers": 1, "maxPlayers": 50,
+//	             "allocation": "lobbyFirst", "playerBased": true, "runInterval": "250ms", "matchFunction": "instant"}]
+//	}
+type File struct {
+	// Definitions are allocation definitions the modes can name, next to the built-in selector.Definitions
+	Definitions map[string]definition.Definition `json:"definitions"`
+	Modes       []Mode                           `json:"modes"`
+}
+
+// Mode is a modeprofile.ModeProfile as configured in the modes file.
+type Mode struct {
+	Name string `json:"name"`
+	// PoolName is the game of the mode's tickets, tagged game.{PoolName}. It defaults to the Name.
+	PoolName string `json:"poolName"`
+
+	Fleets        []modeprofile.WeightedFleet `json:"fleets"`
+	Clusters      []string                    `json:"clusters"`
+	VersionRoutes []modeprofile.VersionRoute  `json:"versionRoutes"`
+
+	// Allocation names the definition a GameServer is allocated with, see File.Definitions
+	Allocation  string `json:"allocation"`
+	MinPlayers  int    `json:"minPlayers"`
+	MaxPlayers  int    `json:"maxPlayers"`
+	PlayerBased bool   `json:"playerBased"`
+	// RunInterval and RunTimeout are durations, e.g. "500ms", empty uses the director's defaults
+	RunInterval string `json:"runInterval"`
+	RunTimeout  string `json:"runTimeout"`
+	// MatchFunction names how matches are made, see matchFunctions
+	MatchFunction string `json:"matchFunction"`
+}
+
+type matchFunction func(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error)
+
+// matchFunctions are the match functions a mode can name. Countdown modes are also scaled by their countdowns.
+var matchFunctions = map[string]matchFunction{
+	"instant": func(profile modeprofile.ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) {
+		return mmf.MakeInstantMatches(profile, tickets)
+	},
+	"countdown": mmf.MakeCountdownMatches,
+}
+
+// Parse reads the modes file into ModeProfiles by mode name, checking every mode can be run.
+func Parse(data []byte) (map[string]modeprofile.ModeProfile, error) {
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	definitions := make(map[string]definition.Definition, len(selector.Definitions)+len(file.Definitions))
+	for name, def := range selector.Definitions {
+		definitions[name] = def
+	}
+	for name, def := range file.Definitions {
+		definitions[name] = def
+	}
+
+	profiles := make(map[string]modeprofile.ModeProfile, len(file.Modes))
+	for i, mode := range file.Modes {
+		if mode.Name == "" {
+			return nil, fmt.Errorf("mode %d: name is required", i)
+		}
+		if _, ok := profiles[mode.Name]; ok {
+			return nil, fmt.Errorf("mode %s: defined twice", mode.Name)
+		}
+		profile, err := mode.profile(definitions)
+		if err != nil {
+			return nil, fmt.Errorf("mode %s: %w", mode.Name, err)
+		}
+		profiles[mode.Name] = profile
+	}
+	return profiles, nil
+}
+
+func (m Mode) profile(definitions map[string]definition.Definition) (modeprofile.ModeProfile, error) {
+	allocation, ok := definitions[m.Allocation]
+	if !ok {
+		return modeprofile.ModeProfile{}, fmt.Errorf("unknown allocation definition %q", m.Allocation)
+	}
+	if err := allocation.Validate(); err != nil {
+		return modeprofile.ModeProfile{}, fmt.Errorf("allocation %s: %w", m.Allocation, err)
+	}
+	makeMatches, ok := matchFunctions[m.MatchFunction]
+	if !ok {
+		return modeprofile.ModeProfile{}, fmt.Errorf("unknown match function %q", m.MatchFunction)
+	}
+	if m.MinPlayers <= 0 || m.MaxPlayers < m.MinPlayers {
+		return modeprofile.ModeProfile{}, fmt.Errorf("minPlayers must be greater than 0 and at most maxPlayers")
+	}
+	runInterval, err := parseDuration(m.RunInterval)
+	if err != nil {
+		return modeprofile.ModeProfile{}, fmt.Errorf("runInterval: %w", err)
+	}
+	runTimeout, err := parseDuration(m.RunTimeout)
+	if err != nil {
+		return modeprofile.ModeProfile{}, fmt.Errorf("runTimeout: %w", err)
+	}
+
+	poolName := m.PoolName
+	if poolName == "" {
+		poolName = m.Name
+	}
+	matchProfile := matchprofile.CommonProfile(m.Name, poolName)
+	if len(m.VersionRoutes) > 0 {
+		matchProfile = matchprofile.VersionedProfile(m.Name, poolName, m.VersionRoutes)
+	}
+
+	profile := modeprofile.ModeProfile{
+		Name:          m.Name,
+		PoolName:      poolName,
+		Fleets:        m.Fleets,
+		Clusters:      m.Clusters,
+		VersionRoutes: m.VersionRoutes,
+		Allocation:    allocation,
+		MatchProfile:  matchProfile,
+		MinPlayers:    m.MinPlayers,
+		MaxPlayers:    m.MaxPlayers,
+		UseCountdown:  m.MatchFunction == "countdown",
+		PlayerBased:   m.PlayerBased,
+		RunInterval:   runInterval,
+		RunTimeout:    runTimeout,
+		MatchFunction: makeMatches,
+	}
+	if err := profile.ValidateRoutes(); err != nil {
+		return modeprofile.ModeProfile{}, err
+	}
+	return profile, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return d, nil
+}
@@ -0,0 +1,82 @@
+package modeprofile
+
+import (
+	"fmt"
+	"hash/fnv"
+	"matchmaker/pkg/common/utils"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+const (
+	// FleetExtension is the match extension containing the fleet picked for the match, so retries of
+	// the match's allocation stay on the same fleet.
+	FleetExtension = "fleet"
+)
+
+// WeightedFleet is a fleet that gets Weight / (sum of all weights) of the new matches of a route,
+// e.g. a canary fleet running a new GameServer build with a weight of 5 next to the stable fleet with a weight of 95.
+type WeightedFleet struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// SingleFleet sends every match to one fleet.
+func SingleFleet(name string) []WeightedFleet {
+	return []WeightedFleet{{Name: name, Weight: 1}}
+}
+
+// PickFleet picks a fleet for the match by its weight. The pick is sticky by match ID.
+// overrides replaces the weight of the fleets it contains, e.g. to roll back a bad canary at runtime.
+func PickFleet(fleets []WeightedFleet, matchId string, overrides map[string]int) (string, error) {
+	total := 0
+	weights := make([]int, len(fleets))
+	for i, fleet := range fleets {
+		weights[i] = fleet.Weight
+		if weight, ok := overrides[fleet.Name]; ok {
+			weights[i] = weight
+		}
+		total += weights[i]
+	}
+	if total <= 0 {
+		return "", fmt.Errorf("no fleet has a weight")
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(matchId))
+	n := int(h.Sum32() % uint32(total))
+	for i, fleet := range fleets {
+		if n < weights[i] {
+			return fleet.Name, nil
+		}
+		n -= weights[i]
+	}
+	return "", fmt.Errorf("no fleet has a weight")
+}
+
+// FleetForMatch returns the fleet picked for the match, or picks one of its route without overrides.
+func (p ModeProfile) FleetForMatch(match *pb.Match) (string, error) {
+	if fleet, ok := utils.GetMatchStringExtension(match, FleetExtension); ok {
+		return fleet, nil
+	}
+	return PickFleet(p.RouteForMatch(match).Fleets, match.GetMatchId(), nil)
+}
+
+func validateFleets(fleets []WeightedFleet) error {
+	if len(fleets) == 0 {
+		return fmt.Errorf("at least one fleet is required")
+	}
+	total := 0
+	for _, fleet := range fleets {
+		if fleet.Name == "" {
+			return fmt.Errorf("fleet name is required")
+		}
+		if fleet.Weight < 0 {
+			return fmt.Errorf("fleet %s: weight must not be negative", fleet.Name)
+		}
+		total += fleet.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("no fleet has a weight")
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package modeprofile
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"open-match.dev/open-match/pkg/pb"
+	"testing"
+)
+
+var canaryFleets = []WeightedFleet{{Name: "lobby", Weight: 95}, {Name: "lobby-canary", Weight: 5}}
+
+// pickCounts picks a fleet for n matches and counts the matches of each fleet.
+func pickCounts(t *testing.T, fleets []WeightedFleet, overrides map[string]int, n int) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		fleet, err := PickFleet(fleets, fmt.Sprintf("match-%d", i), overrides)
+		if err != nil {
+			t.Fatalf("failed to pick a fleet: %v", err)
+		}
+		counts[fleet]++
+	}
+	return counts
+}
+
+func TestPickFleetSplitsMatchesByWeight(t *testing.T) {
+	const matches = 10000
+
+	tests := []struct {
+		name      string
+		fleets    []WeightedFleet
+		overrides map[string]int
+		// expected share of the matches of each fleet, in percent, within tolerance
+		expected  map[string]int
+		tolerance int
+	}{
+		{
+			name:     "single fleet",
+			fleets:   SingleFleet("lobby"),
+			expected: map[string]int{"lobby": 100},
+		},
+		{
+			name:      "canary",
+			fleets:    canaryFleets,
+			expected:  map[string]int{"lobby": 95, "lobby-canary": 5},
+			tolerance: 2,
+		},
+		{
+			name:      "even split",
+			fleets:    []WeightedFleet{{Name: "lobby-a", Weight: 1}, {Name: "lobby-b", Weight: 1}},
+			expected:  map[string]int{"lobby-a": 50, "lobby-b": 50},
+			tolerance: 3,
+		},
+		{
+			name:      "override rolls back the canary",
+			fleets:    canaryFleets,
+			overrides: map[string]int{"lobby-canary": 0},
+			expected:  map[string]int{"lobby": 100},
+		},
+		{
+			name:      "override promotes the canary",
+			fleets:    canaryFleets,
+			overrides: map[string]int{"lobby": 0, "lobby-canary": 1},
+			expected:  map[string]int{"lobby-canary": 100},
+		},
+		{
+			name:      "override of an unknown fleet is ignored",
+			fleets:    canaryFleets,
+			overrides: map[string]int{"minesweeper": 100},
+			expected:  map[string]int{"lobby": 95, "lobby-canary": 5},
+			tolerance: 2,
+		},
+		{
+			name:     "fleet without weight",
+			fleets:   []WeightedFleet{{Name: "lobby", Weight: 1}, {Name: "lobby-drained", Weight: 0}},
+			expected: map[string]int{"lobby": 100},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := pickCounts(t, test.fleets, test.overrides, matches)
+			for fleet, count := range counts {
+				if _, ok := test.expected[fleet]; !ok {
+					t.Errorf("expected no matches on %s, got %d", fleet, count)
+				}
+			}
+			for fleet, share := range test.expected {
+				actual := counts[fleet] * 100 / matches
+				if actual < share-test.tolerance || actual > share+test.tolerance {
+					t.Errorf("expected %d%% of the matches on %s, got %d%%", share, fleet, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestPickFleetIsStickyByMatchId(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		matchId := fmt.Sprintf("match-%d", i)
+		first, err := PickFleet(canaryFleets, matchId, nil)
+		if err != nil {
+			t.Fatalf("failed to pick a fleet: %v", err)
+		}
+		for j := 0; j < 3; j++ {
+			if fleet, _ := PickFleet(canaryFleets, matchId, nil); fleet != first {
+				t.Fatalf("expected %s to stay on %s, got %s", matchId, first, fleet)
+			}
+		}
+	}
+}
+
+func TestPickFleetFailsWithoutWeight(t *testing.T) {
+	tests := []struct {
+		name      string
+		fleets    []WeightedFleet
+		overrides map[string]int
+	}{
+		{name: "no fleets"},
+		{name: "zero weights", fleets: []WeightedFleet{{Name: "lobby", Weight: 0}}},
+		{name: "overridden to zero", fleets: canaryFleets, overrides: map[string]int{"lobby": 0, "lobby-canary": 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fleet, err := PickFleet(test.fleets, "match-1", test.overrides); err == nil {
+				t.Errorf("expected an error, got %s", fleet)
+			}
+		})
+	}
+}
+
+func TestFleetForMatchKeepsThePickedFleet(t *testing.T) {
+	fleet, err := anypb.New(wrapperspb.String("lobby-canary"))
+	if err != nil {
+		t.Fatalf("failed to create the extension: %v", err)
+	}
+	profile := ModeProfile{Name: "lobby", Fleets: SingleFleet("lobby")}
+	match := &pb.Match{MatchId: "match-1", Extensions: map[string]*anypb.Any{FleetExtension: fleet}}
+
+	picked, err := profile.FleetForMatch(match)
+	if err != nil {
+		t.Fatalf("failed to get the fleet: %v", err)
+	}
+	if picked != "lobby-canary" {
+		t.Errorf("expected the fleet of the match's extension, got %s", picked)
+	}
+}
@@ -1,21 +1,33 @@
 package modeprofile
 
 import (
-	v1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"matchmaker/pkg/common/selector/definition"
 	"open-match.dev/open-match/pkg/pb"
+	"time"
 )
 
 type ModeProfile struct {
-	Name      string `json:"name"`
-	PoolName  string `json:"poolName"`
-	FleetName string `json:"fleetName"`
+	Name     string `json:"name"`
+	PoolName string `json:"poolName"`
+	// Fleets new matches are sent to by weight, see PickFleet
+	Fleets   []WeightedFleet `json:"fleets"`
+	Clusters []string        `json:"clusters"` // cluster preference when allocating through the allocator service, in order
+	// VersionRoutes split the mode's tickets by client protocol version and send each version range to fleets
+	// that support it. Without routes every client is sent to Fleets, see Routes.
+	VersionRoutes []VersionRoute `json:"versionRoutes"`
 	//TeamSize   int // currently unused but can be used for parties later.
 
-	Selector     func(profile ModeProfile, match *pb.Match) *v1.GameServerAllocation `json:"-"`
-	MatchProfile *pb.MatchProfile                                                    `json:"matchProfile"`
+	Allocation   definition.Definition `json:"allocation"` // how a GameServer is selected, see selector.Compile
+	MatchProfile *pb.MatchProfile      `json:"matchProfile"`
+
+	MinPlayers   int  `json:"minPlayers"`
+	MaxPlayers   int  `json:"maxPlayers"`
+	UseCountdown bool `json:"useCountdown"`
+	PlayerBased  bool `json:"playerBased"` // players can be added to an existing GameServer, see selector.CommonPlayerBasedDefinition
+	// RunInterval is the minimum time between the director fetching matches for the profile and RunTimeout bounds
+	// each run. Zero uses the director's defaults.
+	RunInterval time.Duration `json:"runInterval"`
+	RunTimeout  time.Duration `json:"runTimeout"`
 
-	MinPlayers    int                                                                                 `json:"minPlayers"`
-	MaxPlayers    int                                                                                 `json:"maxPlayers"`
-	UseCountdown  bool                                                                                `json:"useCountdown"`
 	MatchFunction func(profile ModeProfile, pool *pb.Pool, tickets []*pb.Ticket) ([]*pb.Match, error) `json:"-"`
 }
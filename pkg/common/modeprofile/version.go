@@ -0,0 +1,137 @@
+package modeprofile
+
+import (
+	"fmt"
+	"matchmaker/pkg/common/utils"
+	"math"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+const (
+	// DefaultPool is the pool of a ModeProfile without VersionRoutes, it contains every ticket of the mode
+	DefaultPool = "all"
+	// PoolExtension is the match extension containing the name of the pool the match was made from
+	PoolExtension = "pool"
+)
+
+// VersionRoute sends clients with a protocol version in [MinVersion, MaxVersion] to its fleets.
+// Every route is its own pool, so tickets are only matched with tickets of compatible clients.
+type VersionRoute struct {
+	PoolName   string          `json:"poolName"`
+	MinVersion int             `json:"minVersion"`
+	MaxVersion int             `json:"maxVersion"`
+	Fleets     []WeightedFleet `json:"fleets"`
+	// FleetLabels are additional labels the GameServers must have, e.g. to select a version of a fleet
+	// during a rolling update. They are added to every selector of the allocation.
+	FleetLabels map[string]string `json:"fleetLabels"`
+}
+
+// Supports checks if a client of the protocol version can play on the route's fleet.
+func (r VersionRoute) Supports(version int) bool {
+	return version >= r.MinVersion && version <= r.MaxVersion
+}
+
+// Selects checks if a GameServer with the labels belongs to one of the route's fleets.
+func (r VersionRoute) Selects(labels map[string]string) bool {
+	if !r.HasFleet(labels["agones.dev/fleet"]) {
+		return false
+	}
+	for k, v := range r.FleetLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// HasFleet checks if the route sends matches to the fleet.
+func (r VersionRoute) HasFleet(fleetName string) bool {
+	for _, fleet := range r.Fleets {
+		if fleet.Name == fleetName {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes returns the VersionRoutes of the profile. A profile without VersionRoutes has a single route
+// of every version to its Fleets.
+func (p ModeProfile) Routes() []VersionRoute {
+	if len(p.VersionRoutes) > 0 {
+		return p.VersionRoutes
+	}
+	return []VersionRoute{{PoolName: DefaultPool, MaxVersion: math.MaxInt, Fleets: p.Fleets}}
+}
+
+// Route returns the VersionRoute of the pool.
+func (p ModeProfile) Route(poolName string) (VersionRoute, bool) {
+	for _, route := range p.Routes() {
+		if route.PoolName == poolName {
+			return route, true
+		}
+	}
+	return VersionRoute{}, false
+}
+
+// RouteForMatch returns the VersionRoute of the pool the match was made from.
+// Matches without a pool use the first route.
+func (p ModeProfile) RouteForMatch(match *pb.Match) VersionRoute {
+	if poolName, ok := utils.GetMatchStringExtension(match, PoolExtension); ok {
+		if route, ok := p.Route(poolName); ok {
+			return route
+		}
+	}
+	return p.Routes()[0]
+}
+
+// RouteForGameServer returns the VersionRoute that selects a GameServer with the labels.
+func (p ModeProfile) RouteForGameServer(labels map[string]string) (VersionRoute, bool) {
+	for _, route := range p.Routes() {
+		if route.Selects(labels) {
+			return route, true
+		}
+	}
+	return VersionRoute{}, false
+}
+
+// HasFleet checks if any route of the profile sends players to the fleet.
+func (p ModeProfile) HasFleet(fleetName string) bool {
+	for _, route := range p.Routes() {
+		if route.HasFleet(fleetName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pool returns the MatchProfile pool of the route.
+func (p ModeProfile) Pool(route VersionRoute) (*pb.Pool, bool) {
+	for _, pool := range p.MatchProfile.GetPools() {
+		if pool.GetName() == route.PoolName {
+			return pool, true
+		}
+	}
+	return nil, false
+}
+
+// ValidateRoutes checks every route has a pool in the MatchProfile and fleets, and no version is sent to two routes.
+func (p ModeProfile) ValidateRoutes() error {
+	routes := p.Routes()
+	for i, route := range routes {
+		if route.MaxVersion < route.MinVersion {
+			return fmt.Errorf("route %s: maxVersion is less than minVersion", route.PoolName)
+		}
+		if err := validateFleets(route.Fleets); err != nil {
+			return fmt.Errorf("route %s: %w", route.PoolName, err)
+		}
+		if _, ok := p.Pool(route); !ok {
+			return fmt.Errorf("route %s: no pool in match profile", route.PoolName)
+		}
+		for _, other := range routes[i+1:] {
+			if route.MinVersion <= other.MaxVersion && other.MinVersion <= route.MaxVersion {
+				return fmt.Errorf("routes %s and %s overlap", route.PoolName, other.PoolName)
+			}
+		}
+	}
+	return nil
+}
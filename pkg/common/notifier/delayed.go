@@ -0,0 +1,16 @@
+package notifier
+
+// DelayReason is why a match was delayed. It is logged and traced when its players are notified, the published
+// GameServerMatchmaking API has no MatchDelayed to send it with yet.
+type DelayReason string
+
+const (
+	// DelayNoCapacity is a match held until its fleet has capacity.
+	DelayNoCapacity DelayReason = "NO_CAPACITY"
+	// DelayNoFleet is a match whose mode has no fleet for its players, its tickets are back in the queue.
+	DelayNoFleet DelayReason = "NO_FLEET"
+	// DelayAllocationFailed is a match no GameServer could be allocated for, its tickets are back in the queue.
+	DelayAllocationFailed DelayReason = "ALLOCATION_FAILED"
+	// DelayAssignmentFailed is a match whose tickets couldn't be assigned to its GameServer, they are back in the queue.
+	DelayAssignmentFailed DelayReason = "ASSIGNMENT_FAILED"
+)
@@ -7,100 +7,182 @@ import (
 	"github.com/EmortalMC/grpc-api-specs/gen/go/service/player_tracker"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	v12 "k8s.io/api/core/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"matchmaker/pkg/common/appconfig"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/metrics"
+	"matchmaker/pkg/common/playertracker"
+	"matchmaker/pkg/common/tracing"
 	"matchmaker/pkg/common/utils/kubernetes"
 	"open-match.dev/open-match/pkg/pb"
-	"os"
 	"time"
 )
 
 var (
-	enabled = true
-
-	logger, _           = zap.NewProduction()
-	playerTrackerClient = createPlayerTrackerClient()
-	namespace           = os.Getenv("NAMESPACE")
+	logger, _ = zap.NewProduction()
+	namespace = appconfig.Namespace()
+
+	results = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "notifier_calls_total",
+		Help:      "Results of the notifier's calls to the player tracker, Kubernetes and game servers",
+	}, []string{"rpc", "result"})
 )
 
 // NotifyPlayersOfMatch notifies the player of a match that will begin immediately
-func NotifyPlayersOfMatch(match *pb.Match) {
-	NotifyPlayersOfPendingMatch(getPlayerIdsFromMatch(match), time.Now())
+func NotifyPlayersOfMatch(ctx context.Context, match *pb.Match) {
+	NotifyPlayersOfPendingMatch(ctx, match.GetMatchId(), getPlayerIdsFromMatch(match), time.Now())
 }
 
 // NotifyPlayersOfPendingMatch notifies the player of a match that will begin at the teleportTime
-// A Match does not exist at this time so the raw playerIds are passed in
-func NotifyPlayersOfPendingMatch(playerIds []string, teleportTime time.Time) {
-	if !enabled {
+// A Match may not exist at this time so the raw playerIds are passed in, and matchId is empty if it doesn't
+// The calls are traced as children of the span in ctx and bounded by their deadline, not by ctx being done.
+func NotifyPlayersOfPendingMatch(ctx context.Context, matchId string, playerIds []string, teleportTime time.Time) {
+	if !playertracker.Enabled {
 		return
 	}
-	serverResp, err := playerTrackerClient.GetPlayerServers(context.Background(), &player_tracker.PlayersRequest{PlayerIds: playerIds})
-
+	ctx = tracing.Detach(ctx)
+	serverResp, err := getPlayerServers(ctx, playerIds)
 	if err != nil {
 		logger.Error("Failed to get player servers", zap.Error(err))
 		return
 	}
 
 	for playerId, server := range serverResp.GetPlayerServers() {
-		go notify(playerId, server, uint32(len(playerIds)), teleportTime)
+		go notify(ctx, playerId, matchId, server, uint32(len(playerIds)), teleportTime)
 	}
 }
 
 // NotifyPlayersOfCancelledCountdown notifies the player that the countdown has been cancelled
-func NotifyPlayersOfCancelledCountdown(playerIds []string) {
-	if !enabled {
+func NotifyPlayersOfCancelledCountdown(ctx context.Context, playerIds []string) {
+	if !playertracker.Enabled {
+		return
+	}
+	ctx = tracing.Detach(ctx)
+	serverResp, err := getPlayerServers(ctx, playerIds)
+	if err != nil {
+		logger.Error("Failed to get player servers", zap.Error(err))
+		return
+	}
+
+	for playerId, server := range serverResp.GetPlayerServers() {
+		go notifyCancelledCountdown(ctx, playerId, server)
+	}
+}
+
+// NotifyPlayersOfDelayedMatch notifies the players of a match that it was delayed with MatchCancelled, the reason and
+// retryTime are only logged and traced. Their tickets are held or back in the queue, so they must not teleport.
+// The calls are traced as children of the span in ctx and bounded by their deadline, not by ctx being done.
+func NotifyPlayersOfDelayedMatch(ctx context.Context, match *pb.Match, reason DelayReason, retryTime time.Time) {
+	if !playertracker.Enabled {
 		return
 	}
-	serverResp, err := playerTrackerClient.GetPlayerServers(context.Background(), &player_tracker.PlayersRequest{PlayerIds: playerIds})
+	playerIds := getPlayerIdsFromMatch(match)
+	logger.Info("Notifying players of delayed match",
+		zap.String("matchId", match.GetMatchId()),
+		zap.Strings("playerIds", playerIds),
+		zap.String("reason", string(reason)),
+		zap.Time("retryTime", retryTime),
+	)
 
+	ctx = tracing.Detach(ctx)
+	serverResp, err := getPlayerServers(ctx, playerIds)
 	if err != nil {
 		logger.Error("Failed to get player servers", zap.Error(err))
 		return
 	}
 
 	for playerId, server := range serverResp.GetPlayerServers() {
-		go notifyCancelledCountdown(playerId, server)
+		go notifyDelayed(ctx, playerId, match.GetMatchId(), server, reason, retryTime)
 	}
 }
 
-func notify(playerId string, server *player_tracker.OnlineServer, playerCount uint32, teleportTime time.Time) {
-	client, err := getMatchmakingClient(server)
+func notify(ctx context.Context, playerId string, matchId string, server *player_tracker.OnlineServer, playerCount uint32, teleportTime time.Time) {
+	client, err := getMatchmakingClient(ctx, server)
 	if err != nil {
 		logger.Error("Failed to get matchmaking client", zap.Error(err))
 		return
 	}
 
-	_, err = client.MatchFound(context.Background(), &matchmaking.MatchFoundRequest{
-		PlayerId:     playerId,
-		PlayerCount:  playerCount,
-		TeleportTime: timestamppb.New(teleportTime),
-	})
+	err = call(ctx, deadline.GameServer, "MatchFound", func(ctx context.Context) error {
+		_, err := client.MatchFound(ctx, &matchmaking.MatchFoundRequest{
+			PlayerId:     playerId,
+			MatchId:      matchId,
+			PlayerCount:  playerCount,
+			TeleportTime: timestamppb.New(teleportTime),
+		})
+		return err
+	}, attribute.String("player.id", playerId), attribute.String("match.id", matchId))
 	if err != nil {
 		logger.Error("Failed to notify matchmaking client", zap.Error(err))
 		return
 	}
 }
 
-func notifyCancelledCountdown(playerId string, server *player_tracker.OnlineServer) {
-	client, err := getMatchmakingClient(server)
+func notifyCancelledCountdown(ctx context.Context, playerId string, server *player_tracker.OnlineServer) {
+	client, err := getMatchmakingClient(ctx, server)
 	if err != nil {
 		logger.Error("Failed to get matchmaking client", zap.Error(err))
 		return
 	}
+	sendCancelled(ctx, client, playerId)
+}
 
-	_, err = client.MatchCancelled(context.Background(), &matchmaking.MatchCancelledRequest{PlayerId: playerId})
+// notifyDelayed sends MatchCancelled, the published GameServerMatchmaking API has no MatchDelayed yet.
+func notifyDelayed(ctx context.Context, playerId string, matchId string, server *player_tracker.OnlineServer, reason DelayReason, retryTime time.Time) {
+	client, err := getMatchmakingClient(ctx, server)
+	if err != nil {
+		logger.Error("Failed to get matchmaking client", zap.Error(err))
+		return
+	}
+	sendCancelled(ctx, client, playerId, attribute.String("match.id", matchId), attribute.String("reason", string(reason)),
+		attribute.String("retry.time", retryTime.Format(time.RFC3339)))
+}
 
+func sendCancelled(ctx context.Context, client matchmaking.GameServerMatchmakingClient, playerId string, attrs ...attribute.KeyValue) {
+	err := call(ctx, deadline.GameServer, "MatchCancelled", func(ctx context.Context) error {
+		_, err := client.MatchCancelled(ctx, &matchmaking.MatchCancelledRequest{PlayerId: playerId})
+		return err
+	}, append([]attribute.KeyValue{attribute.String("player.id", playerId)}, attrs...)...)
 	if err != nil {
 		logger.Error("Failed to notify matchmaking client", zap.Error(err))
 		return
 	}
 }
 
-func getMatchmakingClient(server *player_tracker.OnlineServer) (matchmaking.GameServerMatchmakingClient, error) {
-	result, err := kubernetes.KubeClient.CoreV1().Pods(namespace).Get(context.Background(), server.ServerId, v1.GetOptions{})
+func getPlayerServers(ctx context.Context, playerIds []string) (*player_tracker.GetPlayerServersResponse, error) {
+	var resp *player_tracker.GetPlayerServersResponse
+	err := call(ctx, deadline.PlayerTracker, "GetPlayerServers", func(ctx context.Context) error {
+		var err error
+		resp, err = playertracker.Client.GetPlayerServers(ctx, &player_tracker.PlayersRequest{PlayerIds: playerIds})
+		return err
+	}, attribute.StringSlice("player.ids", playerIds))
+	return resp, err
+}
+
+// call makes an outbound call in its own span, bounded by the dependency's deadline, and records its result.
+func call(ctx context.Context, d *deadline.Deadline, rpc string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := tracing.Start(ctx, "notifier."+rpc, attrs...)
+	err := d.Call(ctx, rpc, fn)
+	tracing.End(span, err)
+	recordResult(rpc, err)
+	return err
+}
+
+func getMatchmakingClient(ctx context.Context, server *player_tracker.OnlineServer) (matchmaking.GameServerMatchmakingClient, error) {
+	var result *v12.Pod
+	err := call(ctx, deadline.Kubernetes, "GetPod", func(ctx context.Context) error {
+		var err error
+		result, err = kubernetes.FindPod(ctx, namespace, server.ServerId)
+		return err
+	}, attribute.String("server.id", server.ServerId))
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +192,7 @@ func getMatchmakingClient(server *player_tracker.OnlineServer) (matchmaking.Game
 		return nil, err
 	}
 
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", ip, port), grpc.WithInsecure())
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", ip, port), grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, err
 	}
@@ -143,13 +225,3 @@ func getPlayerIdsFromMatch(match *pb.Match) []string {
 
 	return playerIds
 }
-
-func createPlayerTrackerClient() player_tracker.PlayerTrackerClient {
-	conn, err := grpc.Dial("localhost:50502", grpc.WithInsecure(), grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`))
-	if err != nil {
-		logger.Error("Failed to connect to Player Tracker", zap.Error(err))
-		enabled = false
-	}
-
-	return player_tracker.NewPlayerTrackerClient(conn)
-}
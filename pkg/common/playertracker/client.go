@@ -0,0 +1,29 @@
+package playertracker
+
+import (
+	"github.com/EmortalMC/grpc-api-specs/gen/go/service/player_tracker"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"matchmaker/pkg/common/appconfig"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+
+	playerTrackerEndpoint = appconfig.EnvOr("PLAYER_TRACKER_ENDPOINT", "localhost:50502")
+
+	// Enabled is false if the connection to the Player Tracker could not be created
+	Enabled = true
+	Client  = createPlayerTrackerClient()
+)
+
+func createPlayerTrackerClient() player_tracker.PlayerTrackerClient {
+	conn, err := grpc.Dial(playerTrackerEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`))
+	if err != nil {
+		logger.Error("Failed to connect to Player Tracker", zap.Error(err))
+		Enabled = false
+	}
+
+	return player_tracker.NewPlayerTrackerClient(conn)
+}
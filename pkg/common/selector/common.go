@@ -4,14 +4,10 @@ import (
 	"agones.dev/agones/pkg/apis"
 	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
 	allocatorv1 "agones.dev/agones/pkg/apis/allocation/v1"
-	"github.com/golang/protobuf/proto"
-	"github.com/golang/protobuf/ptypes/wrappers"
-	"github.com/google/uuid"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/json"
-	"log"
+	"matchmaker/pkg/common/join"
 	"matchmaker/pkg/common/modeprofile"
-	"math"
+	"matchmaker/pkg/common/selector/definition"
+	"matchmaker/pkg/common/utils"
 	"open-match.dev/open-match/pkg/pb"
 )
 
@@ -20,111 +16,197 @@ var (
 	ReadyState     = agonesv1.GameServerStateReady
 )
 
-// contains some common selectors
-
-func CommonSelector(profile modeprofile.ModeProfile, match *pb.Match) *allocatorv1.GameServerAllocation {
-	return &allocatorv1.GameServerAllocation{
-		Spec: allocatorv1.GameServerAllocationSpec{
-			Scheduling: apis.Packed,
-			Selectors: []allocatorv1.GameServerSelector{
-				{
-					LabelSelector: v1.LabelSelector{
-						MatchLabels: map[string]string{
-							"agones.dev/fleet":               profile.FleetName,
-							"agones.dev/sdk-should-allocate": "true",
-						},
-					},
-					GameServerState: &AllocatedState,
+// contains some common selector definitions
+
+var (
+	// CommonDefinition selects a GameServer that can run more games (high density) or a new Ready GameServer.
+	CommonDefinition = definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors: []definition.Selector{
+			{
+				MatchLabels: map[string]string{
+					"agones.dev/fleet":               "{{.FleetName}}",
+					"agones.dev/sdk-should-allocate": "true",
 				},
-				{
-					LabelSelector: v1.LabelSelector{
-						MatchLabels: map[string]string{"agones.dev/fleet": profile.FleetName},
-					},
-					GameServerState: &ReadyState,
+				State: definition.StateAllocated,
+			},
+			{
+				MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"},
+				State:       definition.StateReady,
+			},
+		},
+	}
+
+	// CommonPlayerBasedDefinition selects a GameServer where there is no 'match'.
+	// This could be a singleplayer game (e.g. marathon) or a stateless drop-in drop-out game (e.g. the lobby)
+	// If tickets in the match prefer a GameServer (e.g. one hosting their friends), it is tried first.
+	CommonPlayerBasedDefinition = definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors: []definition.Selector{
+			{
+				MatchLabels: map[string]string{
+					"agones.dev/fleet":               "{{.FleetName}}",
+					"agones.dev/sdk-gameserver-name": "{{.PreferredGameServer}}",
 				},
+				State:    definition.StateAllocated,
+				Players:  &definition.PlayerRange{MatchPlayers: true},
+				Optional: true,
+			},
+			{
+				MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"},
+				State:       definition.StateAllocated,
+				Players:     &definition.PlayerRange{MatchPlayers: true}, // will need to change for party support
 			},
-			MetaPatch: allocatorv1.MetaPatch{
-				Annotations: createPatchedAnnotations(match),
+			{
+				MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"},
+				State:       definition.StateReady,
 			},
 		},
 	}
-}
 
-// CommonPlayerBasedSelector selects a GameServer where there is no 'match'.
-// This could be a singleplayer game (e.g. marathon) or a stateless drop-in drop-out game (e.g. the lobby)
-func CommonPlayerBasedSelector(profile modeprofile.ModeProfile, match *pb.Match, playerCount int64) *allocatorv1.GameServerAllocation {
-	return &allocatorv1.GameServerAllocation{
-		Spec: allocatorv1.GameServerAllocationSpec{
-			Scheduling: apis.Packed,
-			Selectors: []allocatorv1.GameServerSelector{
-				{
-					LabelSelector: v1.LabelSelector{
-						MatchLabels: map[string]string{
-							"agones.dev/fleet": profile.FleetName,
-						},
-					},
-					Players: &allocatorv1.PlayerSelector{
-						MinAvailable: playerCount, // will need to change for party support
-						MaxAvailable: math.MaxInt,
-					},
-					GameServerState: &AllocatedState,
+	// CountsAndListsDefinition is an alternative to CommonDefinition for high density GameServers.
+	// The 'games' Counter tracks how many more games a GameServer can run and the 'players' List the players on it.
+	// Both are updated atomically by Agones on allocation, rather than the GameServer updating
+	// the agones.dev/sdk-should-allocate label after it has been allocated.
+	CountsAndListsDefinition = definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors: []definition.Selector{
+			{
+				MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"},
+				State:       definition.StateAllocated,
+				Counters:    map[string]definition.CounterRange{"games": {MinAvailable: 1}},
+				Lists:       map[string]definition.ListRange{"players": {MatchPlayers: true}},
+			},
+			{
+				MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"},
+				State:       definition.StateReady,
+				Counters:    map[string]definition.CounterRange{"games": {MinAvailable: 1}},
+			},
+		},
+		Counters: map[string]definition.CounterAction{"games": {Action: definition.Increment, Amount: 1}},
+		Lists:    map[string]definition.ListAction{"players": {AddMatchPlayers: true}},
+	}
+
+	// Definitions are the definitions a mode can name as its allocation in the modes file, see modeprofile/config.File
+	Definitions = map[string]definition.Definition{
+		"common":            CommonDefinition,
+		"commonPlayerBased": CommonPlayerBasedDefinition,
+		"countsAndLists":    CountsAndListsDefinition,
+	}
+
+	// joinDefinition selects the GameServer the target of a join match is on, if it has player slots for the match.
+	// The fleet isn't selected as the target may be on any fleet of the match's route, not the one picked for the match.
+	joinDefinition = definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors: []definition.Selector{
+			{
+				MatchLabels: map[string]string{
+					"agones.dev/sdk-gameserver-name": "{{.TargetGameServer}}",
 				},
-				{
-					LabelSelector: v1.LabelSelector{
-						MatchLabels: map[string]string{"agones.dev/fleet": profile.FleetName},
-					},
-					GameServerState: &ReadyState,
+				State:   definition.StateAllocated,
+				Players: &definition.PlayerRange{MatchPlayers: true},
+			},
+		},
+	}
+
+	// countsAndListsJoinDefinition is joinDefinition for modes allocated with Counters and Lists, where the 'players'
+	// List holds the players and its capacity. The joining players are added to the List, the 'games' Counter isn't
+	// incremented as the players join a game that is already counted.
+	countsAndListsJoinDefinition = definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors: []definition.Selector{
+			{
+				MatchLabels: map[string]string{
+					"agones.dev/sdk-gameserver-name": "{{.TargetGameServer}}",
 				},
+				State: definition.StateAllocated,
+				Lists: map[string]definition.ListRange{join.PlayersList: {MatchPlayers: true}},
 			},
-			MetaPatch: allocatorv1.MetaPatch{
-				Annotations: createPatchedAnnotations(match),
+		},
+		Lists: map[string]definition.ListAction{join.PlayersList: {AddMatchPlayers: true}},
+	}
+
+	// spectatorDefinition selects the GameServer the target of a spectator match is on.
+	// Player capacity is not checked as spectators don't take a player slot.
+	spectatorDefinition = definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors: []definition.Selector{
+			{
+				MatchLabels: map[string]string{
+					"agones.dev/sdk-gameserver-name": "{{.TargetGameServer}}",
+				},
+				State: definition.StateAllocated,
 			},
 		},
 	}
+)
+
+// Allocation creates the GameServerAllocation for a new match from the ModeProfile's definition.
+func Allocation(profile modeprofile.ModeProfile, match *pb.Match) (*allocatorv1.GameServerAllocation, error) {
+	data, err := newTemplateData(profile, match, match.GetMatchId())
+	if err != nil {
+		return nil, err
+	}
+	if preferred, ok := getPreferredGameServer(match); ok {
+		data.PreferredGameServer = preferred
+	}
+	return Compile(profile.Allocation, data)
 }
 
-func createPatchedAnnotations(match *pb.Match) map[string]string {
-	expectedPlayers, err := createExpectedPlayers(match)
+// JoinAllocation creates the GameServerAllocation for a join match, only allocated if the target GameServer
+// has a player slot for every ticket. The original match ID is reused so the GameServer adds the players to the running game.
+func JoinAllocation(profile modeprofile.ModeProfile, match *pb.Match, target join.Target) (*allocatorv1.GameServerAllocation, error) {
+	data, err := newTemplateData(profile, match, target.MatchId)
 	if err != nil {
-		log.Printf("Error creating expected players: %v", err)
-		return nil
+		return nil, err
+	}
+	data.TargetGameServer = target.GameServerName
+	if _, ok := profile.Allocation.Lists[join.PlayersList]; ok {
+		return Compile(countsAndListsJoinDefinition, data)
 	}
+	return Compile(joinDefinition, data)
+}
 
-	var matchId string
-	//if backfill != nil {
-	//	matchIdAny := backfill.Extensions["originalMatchId"]
-	//
-	//	matchIdProto, err := anypb.UnmarshalNew(matchIdAny, proto2.UnmarshalOptions{})
-	//	if err != nil {
-	//		log.Printf("Error unmarshalling backfill id: %v", err)
-	//		return nil
-	//	}
-	//	matchId = matchIdProto.(*wrappers.StringValue).Value
-	//} else {
-	matchId = uuid.New().String()
-	//}
-
-	return map[string]string{
-		"openmatch.dev/match-id":         matchId,
-		"openmatch.dev/expected-players": expectedPlayers,
-		//"openmatch.dev/backfill-id":      backfill.GetId(),
+// SpectatorAllocation creates the GameServerAllocation for a spectator match.
+// The players of the match are annotated as spectators rather than players.
+func SpectatorAllocation(profile modeprofile.ModeProfile, match *pb.Match, target join.Target) (*allocatorv1.GameServerAllocation, error) {
+	data, err := newTemplateData(profile, match, target.MatchId)
+	if err != nil {
+		return nil, err
 	}
+	data.TargetGameServer = target.GameServerName
+	data.match.ExpectedSpectators = data.match.ExpectedPlayers
+	data.match.ExpectedPlayers = nil
+	return Compile(spectatorDefinition, data)
 }
 
-func createExpectedPlayers(match *pb.Match) (string, error) {
-	var expectedIds []string
+// getPreferredGameServer returns the GameServer preferred by the most tickets in the match.
+func getPreferredGameServer(match *pb.Match) (string, bool) {
+	counts := make(map[string]int)
+	var preferred string
 	for _, ticket := range match.GetTickets() {
-		a := ticket.PersistentField["playerId"]
-		var value wrappers.StringValue
-		err := proto.Unmarshal(a.Value, &value)
-		if err != nil {
-			return "", err
+		gsName, ok := utils.ExtractPreferredGameServerFromTicket(ticket)
+		if !ok {
+			continue
+		}
+		counts[gsName]++
+		if counts[gsName] > counts[preferred] {
+			preferred = gsName
 		}
-		expectedIds = append(expectedIds, value.Value)
 	}
-	jBytes, err := json.Marshal(expectedIds)
-	if err != nil {
-		return "", err
+	return preferred, preferred != ""
+}
+
+func toScheduling(scheduling string) apis.SchedulingStrategy {
+	if scheduling == definition.Distributed {
+		return apis.Distributed
+	}
+	return apis.Packed
+}
+
+func toState(state string) *agonesv1.GameServerState {
+	if state == definition.StateReady {
+		return &ReadyState
 	}
-	return string(jBytes), nil
+	return &AllocatedState
 }
@@ -0,0 +1,235 @@
+package selector
+
+import (
+	allocatorv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"bytes"
+	"fmt"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/selector/definition"
+	"matchmaker/pkg/common/utils"
+	"math"
+	"open-match.dev/open-match/pkg/pb"
+	"text/template"
+)
+
+// TemplateData is available to the label and annotation templates of a definition.Definition
+type TemplateData struct {
+	ProfileName string
+	// FleetName is the fleet picked for the match from its version route, see modeprofile.FleetForMatch
+	FleetName string
+	// FleetLabels of the version route are added to every selector
+	FleetLabels map[string]string
+	// MatchId is the match the GameServer runs, for a join match this is the match being joined.
+	MatchId     string
+	PlayerCount int
+	// PlayerIds is a JSON array of the players in the match's tickets
+	PlayerIds string
+
+	PreferredGameServer string
+	TargetGameServer    string
+
+	// match is encoded as the allocation's annotations, see annotations.Encode
+	match annotations.Match
+}
+
+func newTemplateData(profile modeprofile.ModeProfile, match *pb.Match, matchId string) (TemplateData, error) {
+	players := utils.ExtractPlayerIdsFromTickets(match.GetTickets())
+	playerIds, err := json.Marshal(players)
+	if err != nil {
+		return TemplateData{}, err
+	}
+	extensions, err := annotations.ExtensionsFromMatch(match)
+	if err != nil {
+		return TemplateData{}, err
+	}
+
+	route := profile.RouteForMatch(match)
+	fleetName, err := profile.FleetForMatch(match)
+	if err != nil {
+		return TemplateData{}, err
+	}
+
+	return TemplateData{
+		ProfileName: profile.Name,
+		FleetName:   fleetName,
+		FleetLabels: route.FleetLabels,
+		MatchId:     matchId,
+		PlayerCount: len(match.GetTickets()),
+		PlayerIds:   string(playerIds),
+		match: annotations.Match{
+			MatchId:         matchId,
+			ExpectedPlayers: players,
+			Extensions:      extensions,
+		},
+	}, nil
+}
+
+// Compile creates a GameServerAllocation from the definition.
+func Compile(def definition.Definition, data TemplateData) (*allocatorv1.GameServerAllocation, error) {
+	var selectors []allocatorv1.GameServerSelector
+	for i, s := range def.Selectors {
+		labels, err := executeAll(s.MatchLabels, data)
+		if err != nil {
+			return nil, fmt.Errorf("selector %d: %w", i, err)
+		}
+		if s.Optional && hasEmptyValue(labels) {
+			continue
+		}
+		for k, v := range data.FleetLabels {
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+
+		selector := allocatorv1.GameServerSelector{
+			LabelSelector:   v1.LabelSelector{MatchLabels: labels},
+			GameServerState: toState(s.State),
+		}
+		if s.Players != nil {
+			selector.Players = toPlayerSelector(*s.Players, data)
+		}
+		if len(s.Counters) > 0 {
+			selector.Counters = toCounterSelectors(s.Counters)
+		}
+		if len(s.Lists) > 0 {
+			selector.Lists = toListSelectors(s.Lists, data)
+		}
+		selectors = append(selectors, selector)
+	}
+
+	// the definition's annotations are added to the match annotations shared with the GameServer
+	patchedAnnotations, err := annotations.Encode(data.match)
+	if err != nil {
+		return nil, err
+	}
+	extraAnnotations, err := executeAll(def.Annotations, data)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extraAnnotations {
+		patchedAnnotations[k] = v
+	}
+
+	allocation := &allocatorv1.GameServerAllocation{
+		Spec: allocatorv1.GameServerAllocationSpec{
+			Scheduling: toScheduling(def.Scheduling),
+			Selectors:  selectors,
+			MetaPatch: allocatorv1.MetaPatch{
+				Annotations: patchedAnnotations,
+			},
+		},
+	}
+	if len(def.Counters) > 0 {
+		allocation.Spec.Counters = toCounterActions(def.Counters)
+	}
+	if len(def.Lists) > 0 {
+		allocation.Spec.Lists = toListActions(def.Lists, data)
+	}
+	return allocation, nil
+}
+
+func toPlayerSelector(players definition.PlayerRange, data TemplateData) *allocatorv1.PlayerSelector {
+	minAvailable := players.MinAvailable
+	if players.MatchPlayers {
+		minAvailable += int64(data.PlayerCount)
+	}
+	maxAvailable := players.MaxAvailable
+	if maxAvailable == 0 {
+		maxAvailable = math.MaxInt
+	}
+
+	return &allocatorv1.PlayerSelector{
+		MinAvailable: minAvailable,
+		MaxAvailable: maxAvailable,
+	}
+}
+
+func toCounterSelectors(counters map[string]definition.CounterRange) map[string]allocatorv1.CounterSelector {
+	result := make(map[string]allocatorv1.CounterSelector, len(counters))
+	for k, c := range counters {
+		result[k] = allocatorv1.CounterSelector{
+			MinCount:     c.MinCount,
+			MaxCount:     c.MaxCount,
+			MinAvailable: c.MinAvailable,
+			MaxAvailable: c.MaxAvailable,
+		}
+	}
+	return result
+}
+
+func toListSelectors(lists map[string]definition.ListRange, data TemplateData) map[string]allocatorv1.ListSelector {
+	result := make(map[string]allocatorv1.ListSelector, len(lists))
+	for k, l := range lists {
+		minAvailable := l.MinAvailable
+		if l.MatchPlayers {
+			minAvailable += int64(data.PlayerCount)
+		}
+		result[k] = allocatorv1.ListSelector{
+			MinAvailable: minAvailable,
+			MaxAvailable: l.MaxAvailable,
+		}
+	}
+	return result
+}
+
+func toCounterActions(counters map[string]definition.CounterAction) map[string]allocatorv1.CounterAction {
+	result := make(map[string]allocatorv1.CounterAction, len(counters))
+	for k, c := range counters {
+		action := c.Action
+		amount := c.Amount
+		result[k] = allocatorv1.CounterAction{
+			Action: &action,
+			Amount: &amount,
+		}
+	}
+	return result
+}
+
+func toListActions(lists map[string]definition.ListAction, data TemplateData) map[string]allocatorv1.ListAction {
+	result := make(map[string]allocatorv1.ListAction, len(lists))
+	for k, l := range lists {
+		var values []string
+		if l.AddMatchPlayers {
+			values = append(values, data.match.ExpectedPlayers...)
+		}
+		result[k] = allocatorv1.ListAction{AddValues: values}
+	}
+	return result
+}
+
+func executeAll(templates map[string]string, data TemplateData) (map[string]string, error) {
+	result := make(map[string]string, len(templates))
+	for k, v := range templates {
+		value, err := execute(k, v, data)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = value
+	}
+	return result, nil
+}
+
+func execute(name string, text string, data TemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+func hasEmptyValue(labels map[string]string) bool {
+	for _, v := range labels {
+		if v == "" {
+			return true
+		}
+	}
+	return false
+}
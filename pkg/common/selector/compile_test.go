@@ -0,0 +1,169 @@
+package selector
+
+import (
+	"agones.dev/agones/pkg/apis"
+	allocatorv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/selector/definition"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func testData() TemplateData {
+	return TemplateData{
+		ProfileName: "block_sumo",
+		FleetName:   "block-sumo",
+		FleetLabels: map[string]string{"version": "760"},
+		MatchId:     "match-1",
+		PlayerCount: 2,
+		PlayerIds:   `["player-1","player-2"]`,
+		match: annotations.Match{
+			MatchId:         "match-1",
+			ExpectedPlayers: []string{"player-1", "player-2"},
+			Extensions:      map[string]string{"mode": "block_sumo"},
+		},
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		def    definition.Definition
+		data   func(data *TemplateData)
+		verify func(t *testing.T, allocation *allocatorv1.GameServerAllocation)
+	}{
+		{
+			name: "labels are templated and the fleet labels added",
+			def:  CommonDefinition,
+			verify: func(t *testing.T, allocation *allocatorv1.GameServerAllocation) {
+				if allocation.Spec.Scheduling != apis.Packed {
+					t.Errorf("expected Packed scheduling, got %s", allocation.Spec.Scheduling)
+				}
+				if len(allocation.Spec.Selectors) != 2 {
+					t.Fatalf("expected 2 selectors, got %d", len(allocation.Spec.Selectors))
+				}
+				expected := map[string]string{"agones.dev/fleet": "block-sumo", "agones.dev/sdk-should-allocate": "true", "version": "760"}
+				if labels := allocation.Spec.Selectors[0].MatchLabels; !reflect.DeepEqual(labels, expected) {
+					t.Errorf("expected labels %v, got %v", expected, labels)
+				}
+				if state := allocation.Spec.Selectors[0].GameServerState; state == nil || *state != AllocatedState {
+					t.Errorf("expected the first selector to select Allocated GameServers, got %v", state)
+				}
+				if state := allocation.Spec.Selectors[1].GameServerState; state == nil || *state != ReadyState {
+					t.Errorf("expected the second selector to select Ready GameServers, got %v", state)
+				}
+			},
+		},
+		{
+			name: "optional selector without a preferred GameServer is skipped",
+			def:  CommonPlayerBasedDefinition,
+			verify: func(t *testing.T, allocation *allocatorv1.GameServerAllocation) {
+				if len(allocation.Spec.Selectors) != 2 {
+					t.Fatalf("expected 2 selectors, got %d", len(allocation.Spec.Selectors))
+				}
+				if _, ok := allocation.Spec.Selectors[0].MatchLabels["agones.dev/sdk-gameserver-name"]; ok {
+					t.Error("expected the preferred GameServer selector to be skipped")
+				}
+			},
+		},
+		{
+			name: "optional selector with a preferred GameServer is kept",
+			def:  CommonPlayerBasedDefinition,
+			data: func(data *TemplateData) { data.PreferredGameServer = "lobby-abcde" },
+			verify: func(t *testing.T, allocation *allocatorv1.GameServerAllocation) {
+				if len(allocation.Spec.Selectors) != 3 {
+					t.Fatalf("expected 3 selectors, got %d", len(allocation.Spec.Selectors))
+				}
+				if name := allocation.Spec.Selectors[0].MatchLabels["agones.dev/sdk-gameserver-name"]; name != "lobby-abcde" {
+					t.Errorf("expected the preferred GameServer to be selected first, got %q", name)
+				}
+			},
+		},
+		{
+			name: "player range adds the match's players",
+			def:  CommonPlayerBasedDefinition,
+			verify: func(t *testing.T, allocation *allocatorv1.GameServerAllocation) {
+				players := allocation.Spec.Selectors[0].Players
+				if players == nil || players.MinAvailable != 2 || players.MaxAvailable != math.MaxInt {
+					t.Errorf("expected 2 to unlimited available players, got %+v", players)
+				}
+			},
+		},
+		{
+			name: "counters and lists",
+			def:  CountsAndListsDefinition,
+			verify: func(t *testing.T, allocation *allocatorv1.GameServerAllocation) {
+				if games := allocation.Spec.Selectors[0].Counters["games"]; games.MinAvailable != 1 {
+					t.Errorf("expected a game slot to be required, got %+v", games)
+				}
+				if players := allocation.Spec.Selectors[0].Lists["players"]; players.MinAvailable != 2 {
+					t.Errorf("expected the match's players to be required, got %+v", players)
+				}
+				action := allocation.Spec.Counters["games"]
+				if action.Action == nil || *action.Action != definition.Increment || action.Amount == nil || *action.Amount != 1 {
+					t.Errorf("expected the games Counter to be incremented by 1, got %+v", action)
+				}
+				if values := allocation.Spec.Lists["players"].AddValues; !reflect.DeepEqual(values, []string{"player-1", "player-2"}) {
+					t.Errorf("expected the match's players to be added, got %v", values)
+				}
+			},
+		},
+		{
+			name: "match and definition annotations",
+			def: definition.Definition{
+				Scheduling:  definition.Distributed,
+				Selectors:   []definition.Selector{{MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"}, State: definition.StateReady}},
+				Annotations: map[string]string{"example.com/profile": "{{.ProfileName}}"},
+			},
+			verify: func(t *testing.T, allocation *allocatorv1.GameServerAllocation) {
+				if allocation.Spec.Scheduling != apis.Distributed {
+					t.Errorf("expected Distributed scheduling, got %s", allocation.Spec.Scheduling)
+				}
+				gsAnnotations := allocation.Spec.MetaPatch.Annotations
+				if gsAnnotations["example.com/profile"] != "block_sumo" {
+					t.Errorf("expected the definition's annotation, got %v", gsAnnotations)
+				}
+				match, err := annotations.Decode(gsAnnotations)
+				if err != nil {
+					t.Fatalf("failed to decode the match annotations: %v", err)
+				}
+				if match.MatchId != "match-1" || len(match.ExpectedPlayers) != 2 || match.Extensions["mode"] != "block_sumo" {
+					t.Errorf("expected the match in the annotations, got %+v", match)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := testData()
+			if test.data != nil {
+				test.data(&data)
+			}
+			allocation, err := Compile(test.def, data)
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			test.verify(t, allocation)
+		})
+	}
+}
+
+func TestCompileFailsOnAMissingTemplateField(t *testing.T) {
+	def := definition.Definition{
+		Scheduling: definition.Packed,
+		Selectors:  []definition.Selector{{MatchLabels: map[string]string{"agones.dev/fleet": "{{.Fleet}}"}, State: definition.StateReady}},
+	}
+	if _, err := Compile(def, testData()); err == nil {
+		t.Error("expected a template of an unknown field to fail")
+	}
+}
+
+func TestDefinitionsAreValid(t *testing.T) {
+	for name, def := range Definitions {
+		if err := def.Validate(); err != nil {
+			t.Errorf("definition %s: %v", name, err)
+		}
+	}
+}
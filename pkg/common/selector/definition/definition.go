@@ -0,0 +1,134 @@
+package definition
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// Scheduling strategies, see https://agones.dev/site/docs/advanced/scheduling-and-autoscaling/
+const (
+	Packed      = "Packed"
+	Distributed = "Distributed"
+)
+
+// GameServer states a selector can match
+const (
+	StateReady     = "Ready"
+	StateAllocated = "Allocated"
+)
+
+// Counter actions
+const (
+	Increment = "Increment"
+	Decrement = "Decrement"
+)
+
+// Definition describes how a GameServer is allocated for a match so a mode can pick its allocation
+// behaviour through configuration alone. The director compiles it into a GameServerAllocation.
+//
+// Label values and annotations are templates (text/template) executed with selector.TemplateData.
+type Definition struct {
+	Scheduling  string            `json:"scheduling"`
+	Selectors   []Selector        `json:"selectors"` // in order of preference
+	Annotations map[string]string `json:"annotations"`
+
+	// Counters and Lists are updated atomically by Agones on allocation (requires the CountsAndLists feature gate)
+	Counters map[string]CounterAction `json:"counters,omitempty"`
+	Lists    map[string]ListAction    `json:"lists,omitempty"`
+}
+
+type Selector struct {
+	MatchLabels map[string]string `json:"matchLabels"`
+	State       string            `json:"state"`
+	Players     *PlayerRange      `json:"players,omitempty"`
+	// Optional selectors are skipped if one of their labels is empty, e.g. there is no preferred GameServer.
+	Optional bool `json:"optional,omitempty"`
+
+	Counters map[string]CounterRange `json:"counters,omitempty"`
+	Lists    map[string]ListRange    `json:"lists,omitempty"`
+}
+
+// CounterRange selects GameServers by the count and available capacity of an Agones Counter. 0 values are ignored.
+type CounterRange struct {
+	MinCount     int64 `json:"minCount"`
+	MaxCount     int64 `json:"maxCount"`
+	MinAvailable int64 `json:"minAvailable"`
+	MaxAvailable int64 `json:"maxAvailable"`
+}
+
+// ListRange selects GameServers by the available capacity of an Agones List.
+type ListRange struct {
+	MinAvailable int64 `json:"minAvailable"`
+	MaxAvailable int64 `json:"maxAvailable"` // 0 is unlimited
+	// MatchPlayers adds the amount of players in the match to MinAvailable
+	MatchPlayers bool `json:"matchPlayers"`
+}
+
+// CounterAction increments or decrements an Agones Counter on allocation
+type CounterAction struct {
+	Action string `json:"action"` // Increment or Decrement
+	Amount int64  `json:"amount"` // greater than 0
+}
+
+// ListAction adds values to an Agones List on allocation
+type ListAction struct {
+	// AddMatchPlayers adds the IDs of the players in the match
+	AddMatchPlayers bool `json:"addMatchPlayers"`
+}
+
+// PlayerRange selects GameServers by their available player capacity
+type PlayerRange struct {
+	MinAvailable int64 `json:"minAvailable"`
+	MaxAvailable int64 `json:"maxAvailable"` // 0 is unlimited
+	// MatchPlayers adds the amount of players in the match to MinAvailable
+	MatchPlayers bool `json:"matchPlayers"`
+}
+
+func (d Definition) Validate() error {
+	if d.Scheduling != Packed && d.Scheduling != Distributed {
+		return fmt.Errorf("invalid scheduling %s", d.Scheduling)
+	}
+	if len(d.Selectors) == 0 {
+		return fmt.Errorf("at least one selector is required")
+	}
+
+	for i, s := range d.Selectors {
+		if s.State != StateReady && s.State != StateAllocated {
+			return fmt.Errorf("selector %d: invalid state %s", i, s.State)
+		}
+		if s.Players != nil && s.Players.MaxAvailable != 0 && s.Players.MaxAvailable < s.Players.MinAvailable {
+			return fmt.Errorf("selector %d: maxAvailable is less than minAvailable", i)
+		}
+		for k, c := range s.Counters {
+			if c.MaxCount != 0 && c.MaxCount < c.MinCount {
+				return fmt.Errorf("selector %d: counter %s maxCount is less than minCount", i, k)
+			}
+		}
+		for k, v := range s.MatchLabels {
+			if _, err := template.New(k).Parse(v); err != nil {
+				return fmt.Errorf("selector %d: invalid label %s: %w", i, k, err)
+			}
+		}
+	}
+
+	for k, a := range d.Counters {
+		if a.Action != Increment && a.Action != Decrement {
+			return fmt.Errorf("counter %s: invalid action %s", k, a.Action)
+		}
+		if a.Amount <= 0 {
+			return fmt.Errorf("counter %s: amount must be greater than 0", k)
+		}
+	}
+	for k, a := range d.Lists {
+		if !a.AddMatchPlayers {
+			return fmt.Errorf("list %s: the action adds no values", k)
+		}
+	}
+
+	for k, v := range d.Annotations {
+		if _, err := template.New(k).Parse(v); err != nil {
+			return fmt.Errorf("invalid annotation %s: %w", k, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package definition
+
+import (
+	"testing"
+)
+
+func validDefinition() Definition {
+	return Definition{
+		Scheduling: Packed,
+		Selectors: []Selector{
+			{MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"}, State: StateAllocated},
+			{MatchLabels: map[string]string{"agones.dev/fleet": "{{.FleetName}}"}, State: StateReady},
+		},
+		Counters: map[string]CounterAction{"games": {Action: Increment, Amount: 1}},
+		Lists:    map[string]ListAction{"players": {AddMatchPlayers: true}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(d *Definition)
+		expectErr bool
+	}{
+		{
+			name:   "valid",
+			change: func(d *Definition) {},
+		},
+		{
+			name:      "invalid scheduling",
+			change:    func(d *Definition) { d.Scheduling = "Spread" },
+			expectErr: true,
+		},
+		{
+			name:      "no selectors",
+			change:    func(d *Definition) { d.Selectors = nil },
+			expectErr: true,
+		},
+		{
+			name:      "invalid state",
+			change:    func(d *Definition) { d.Selectors[0].State = "Shutdown" },
+			expectErr: true,
+		},
+		{
+			name:      "player range max less than min",
+			change:    func(d *Definition) { d.Selectors[0].Players = &PlayerRange{MinAvailable: 5, MaxAvailable: 2} },
+			expectErr: true,
+		},
+		{
+			name:   "unlimited player range",
+			change: func(d *Definition) { d.Selectors[0].Players = &PlayerRange{MinAvailable: 5} },
+		},
+		{
+			name: "counter range max less than min",
+			change: func(d *Definition) {
+				d.Selectors[0].Counters = map[string]CounterRange{"games": {MinCount: 3, MaxCount: 1}}
+			},
+			expectErr: true,
+		},
+		{
+			name:      "invalid label template",
+			change:    func(d *Definition) { d.Selectors[0].MatchLabels["agones.dev/fleet"] = "{{.FleetName" },
+			expectErr: true,
+		},
+		{
+			name:      "invalid counter action",
+			change:    func(d *Definition) { d.Counters["games"] = CounterAction{Action: "Set", Amount: 1} },
+			expectErr: true,
+		},
+		{
+			name:      "zero counter amount",
+			change:    func(d *Definition) { d.Counters["games"] = CounterAction{Action: Increment} },
+			expectErr: true,
+		},
+		{
+			name:      "negative counter amount",
+			change:    func(d *Definition) { d.Counters["games"] = CounterAction{Action: Decrement, Amount: -1} },
+			expectErr: true,
+		},
+		{
+			name:      "list action without values",
+			change:    func(d *Definition) { d.Lists["players"] = ListAction{} },
+			expectErr: true,
+		},
+		{
+			name:      "invalid annotation template",
+			change:    func(d *Definition) { d.Annotations = map[string]string{"mode": "{{.ProfileName"} },
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := validDefinition()
+			test.change(&d)
+			err := d.Validate()
+			if test.expectErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Package tracing follows a match with OpenTelemetry from the match function's proposal, through the director's
+// allocation and assignment, to the GameServer. The trace context travels with the match in its extensions
+// (see TraceParentExtension), which are also patched onto the allocated GameServer as annotations.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/utils"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+const (
+	// TraceParentExtension and TraceStateExtension are the match extensions holding the W3C trace context
+	// of the match's latest span, named after their headers. On a GameServer they are the match-extension.openmatch.dev/traceparent
+	// and match-extension.openmatch.dev/tracestate annotations.
+	TraceParentExtension = "traceparent"
+	TraceStateExtension  = "tracestate"
+
+	// Exporters supported by Init
+	NoExporter     = "none"
+	StdoutExporter = "stdout"
+	OtlpExporter   = "otlp"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+
+	tracer     = otel.Tracer("matchmaker")
+	propagator = propagation.TraceContext{}
+)
+
+// Init sets up the global tracer provider with the exporter named by exporter. The OTLP exporter sends spans
+// over gRPC to the collector at endpoint (host:port). The returned function flushes and stops the exporter.
+func Init(serviceName string, exporter string, endpoint string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case NoExporter, "":
+		return func(context.Context) error { return nil }, nil
+	case StdoutExporter:
+		spanExporter, err = stdouttrace.New()
+	case OtlpExporter:
+		spanExporter, err = otlptracegrpc.New(context.Background(),
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %s", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Exporting traces", zap.String("service", serviceName), zap.String("exporter", exporter), zap.String("endpoint", endpoint))
+	return Use(serviceName, spanExporter), nil
+}
+
+// Use sets up the global tracer provider to batch spans to the exporter,
+// e.g. tracetest.NewInMemoryExporter to check the spans of a test.
+func Use(serviceName string, exporter sdktrace.SpanExporter) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown
+}
+
+// Start starts a span that is a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartMatch starts a span in the match's trace, continuing from the trace context in its extensions.
+// The span is linked to the span in ctx, e.g. the director's run that fetched the match.
+func StartMatch(ctx context.Context, name string, match *pb.Match, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	link := trace.LinkFromContext(ctx)
+	return tracer.Start(ContextFromMatch(ctx, match), name,
+		trace.WithLinks(link),
+		trace.WithAttributes(append(MatchAttributes(match), attrs...)...),
+	)
+}
+
+// StartProposal starts the first span of a match proposal's trace. Every match has a trace of its own,
+// linked to the span in ctx, e.g. the match function's run.
+func StartProposal(ctx context.Context, name string, match *pb.Match, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	link := trace.LinkFromContext(ctx)
+	return tracer.Start(ctx, name,
+		trace.WithNewRoot(),
+		trace.WithLinks(link),
+		trace.WithAttributes(append(MatchAttributes(match), attrs...)...),
+	)
+}
+
+// Detach returns a context with the span of ctx that is never done, for calls that must be made even if ctx
+// was cancelled, e.g. releasing tickets, while staying in the trace.
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// MatchAttributes key a span by the match and its tickets, so the trace of a player's ticket can be found.
+func MatchAttributes(match *pb.Match) []attribute.KeyValue {
+	ticketIds := make([]string, 0, len(match.GetTickets()))
+	for _, t := range match.GetTickets() {
+		ticketIds = append(ticketIds, t.GetId())
+	}
+	return []attribute.KeyValue{
+		attribute.String("match.id", match.GetMatchId()),
+		attribute.String("match.profile", match.GetMatchProfile()),
+		attribute.StringSlice("ticket.ids", ticketIds),
+	}
+}
+
+// InjectMatch stores the trace context of the span in ctx in the match's extensions,
+// so whoever handles the match next continues the trace from that span.
+func InjectMatch(ctx context.Context, match *pb.Match) error {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	for _, extension := range []string{TraceParentExtension, TraceStateExtension} {
+		value := carrier.Get(extension)
+		if value == "" {
+			continue
+		}
+		if err := utils.SetMatchStringExtension(match, extension, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContextFromMatch returns ctx with the trace context in the match's extensions as the parent span.
+// ctx is returned unchanged if the match has no trace context.
+func ContextFromMatch(ctx context.Context, match *pb.Match) context.Context {
+	extensions := make(map[string]string, 2)
+	for _, extension := range []string{TraceParentExtension, TraceStateExtension} {
+		if v, ok := utils.GetMatchStringExtension(match, extension); ok {
+			extensions[extension] = v
+		}
+	}
+	return ContextFromExtensions(ctx, extensions)
+}
+
+// ContextFromExtensions is ContextFromMatch for the decoded extensions of a GameServer's annotations,
+// see annotations.Match, so a GameServer can continue the trace of the match it was allocated for.
+func ContextFromExtensions(ctx context.Context, extensions map[string]string) context.Context {
+	if extensions[TraceParentExtension] == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{
+		TraceParentExtension: extensions[TraceParentExtension],
+		TraceStateExtension:  extensions[TraceStateExtension],
+	}
+	return propagator.Extract(ctx, carrier)
+}
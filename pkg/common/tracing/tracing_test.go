@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"matchmaker/pkg/common/utils"
+	"open-match.dev/open-match/pkg/pb"
+	"os"
+	"testing"
+)
+
+// exporter holds the spans of the tests. The global tracer provider can only be set up once, see Use.
+var exporter = tracetest.NewInMemoryExporter()
+
+func TestMain(m *testing.M) {
+	stop := Use("test", exporter)
+	code := m.Run()
+	_ = stop(context.Background())
+	os.Exit(code)
+}
+
+// endedSpans flushes the batched spans and returns them by name, clearing the exporter for the next test.
+func endedSpans(t *testing.T) map[string]tracetest.SpanStub {
+	t.Helper()
+	if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+		t.Fatalf("failed to flush spans: %v", err)
+	}
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	exporter.Reset()
+	return spans
+}
+
+func testMatch() *pb.Match {
+	return &pb.Match{
+		MatchId:      "match-1",
+		MatchProfile: "lobby",
+		Tickets:      []*pb.Ticket{{Id: "ticket-1"}, {Id: "ticket-2"}},
+	}
+}
+
+func isLinked(span tracetest.SpanStub, to trace.SpanContext) bool {
+	for _, link := range span.Links {
+		if link.SpanContext.Equal(to) {
+			return true
+		}
+	}
+	return false
+}
+
+func isChildOf(span tracetest.SpanStub, parent tracetest.SpanStub) bool {
+	return span.Parent.IsValid() && span.Parent.SpanID() == parent.SpanContext.SpanID() &&
+		span.SpanContext.TraceID() == parent.SpanContext.TraceID()
+}
+
+// TestMatchSpansFormOneTrace follows a match from the match function's proposal through the director to its
+// GameServer, handing the trace context over in the match's extensions like the components do.
+func TestMatchSpansFormOneTrace(t *testing.T) {
+	match := testMatch()
+
+	// match function
+	runCtx, run := Start(context.Background(), "mmf.run")
+	proposalCtx, proposal := StartProposal(runCtx, "mmf.proposal", match)
+	if err := InjectMatch(proposalCtx, match); err != nil {
+		t.Fatalf("failed to inject proposal: %v", err)
+	}
+	proposal.End()
+	run.End()
+
+	// director
+	directorRunCtx, directorRun := Start(context.Background(), "director.run")
+	matchCtx, matchSpan := StartMatch(directorRunCtx, "director.match", match)
+	allocateCtx, allocate := Start(matchCtx, "director.allocate")
+	if err := InjectMatch(allocateCtx, match); err != nil {
+		t.Fatalf("failed to inject allocation: %v", err)
+	}
+	allocate.End()
+	matchSpan.End()
+	directorRun.End()
+
+	// GameServer, from the extensions patched onto its annotations
+	extensions := make(map[string]string)
+	for _, extension := range []string{TraceParentExtension, TraceStateExtension} {
+		if v, ok := utils.GetMatchStringExtension(match, extension); ok {
+			extensions[extension] = v
+		}
+	}
+	_, gameServer := Start(ContextFromExtensions(context.Background(), extensions), "gameserver.match")
+	gameServer.End()
+
+	spans := endedSpans(t)
+	for _, name := range []string{"mmf.run", "mmf.proposal", "director.run", "director.match", "director.allocate", "gameserver.match"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("expected a %s span, got %v", name, spans)
+		}
+	}
+
+	if spans["mmf.proposal"].Parent.IsValid() {
+		t.Error("expected the proposal to start a trace of its own")
+	}
+	if spans["mmf.proposal"].SpanContext.TraceID() == spans["mmf.run"].SpanContext.TraceID() {
+		t.Error("expected the proposal not to be in the trace of the match function's run")
+	}
+	if !isLinked(spans["mmf.proposal"], spans["mmf.run"].SpanContext) {
+		t.Error("expected the proposal to be linked to the match function's run")
+	}
+
+	if !isChildOf(spans["director.match"], spans["mmf.proposal"]) {
+		t.Error("expected the director's match span to continue from the proposal")
+	}
+	if !isLinked(spans["director.match"], spans["director.run"].SpanContext) {
+		t.Error("expected the director's match span to be linked to the director's run")
+	}
+	if !isChildOf(spans["director.allocate"], spans["director.match"]) {
+		t.Error("expected the allocation to be a child of the director's match span")
+	}
+	if !isChildOf(spans["gameserver.match"], spans["director.allocate"]) {
+		t.Error("expected the GameServer to continue from the allocation")
+	}
+}
+
+func TestStartMatchWithoutTraceContextContinuesTheSpanInContext(t *testing.T) {
+	runCtx, run := Start(context.Background(), "director.run")
+	_, matchSpan := StartMatch(runCtx, "director.match", testMatch())
+	matchSpan.End()
+	run.End()
+
+	spans := endedSpans(t)
+	if !isChildOf(spans["director.match"], spans["director.run"]) {
+		t.Error("expected a match without trace context to be a child of the director's run")
+	}
+}
+
+func TestMatchAttributesKeyTheSpanByMatchAndTickets(t *testing.T) {
+	_, proposal := StartProposal(context.Background(), "mmf.proposal", testMatch())
+	proposal.End()
+
+	attributes := make(map[string]any)
+	for _, attribute := range endedSpans(t)["mmf.proposal"].Attributes {
+		attributes[string(attribute.Key)] = attribute.Value.AsInterface()
+	}
+	if attributes["match.id"] != "match-1" || attributes["match.profile"] != "lobby" {
+		t.Errorf("expected the match's id and profile, got %v", attributes)
+	}
+	if ticketIds, ok := attributes["ticket.ids"].([]string); !ok || len(ticketIds) != 2 || ticketIds[0] != "ticket-1" {
+		t.Errorf("expected the ticket ids, got %v", attributes["ticket.ids"])
+	}
+}
+
+func TestEndRecordsTheError(t *testing.T) {
+	_, span := Start(context.Background(), "director.allocate")
+	End(span, errors.New("no capacity"))
+
+	ended := endedSpans(t)["director.allocate"]
+	if ended.Status.Code != codes.Error || ended.Status.Description != "no capacity" {
+		t.Errorf("expected an error status, got %v", ended.Status)
+	}
+	if len(ended.Events) != 1 || ended.Events[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded, got %v", ended.Events)
+	}
+}
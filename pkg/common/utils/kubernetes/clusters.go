@@ -0,0 +1,168 @@
+package kubernetes
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"agones.dev/agones/pkg/client/clientset/versioned"
+	"context"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"sync"
+)
+
+// LocalCluster is the name of the cluster the component runs in. It matches the source Agones reports for
+// allocations that weren't forwarded to another cluster.
+const LocalCluster = "local"
+
+// Cluster is a cluster GameServers run in, with the clients of its Kubernetes API.
+type Cluster struct {
+	Name      string
+	Namespace string
+	Agones    versioned.Interface
+	Kube      kubernetes.Interface
+
+	// local is true if the clients are those of the cluster the component runs in
+	local bool
+}
+
+// ClusterConfig is an entry of the clusters file, the same file as the director's allocator clusters so
+// other fields are ignored.
+type ClusterConfig struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// KubeconfigFile is the kubeconfig of the cluster's Kubernetes API. If empty it is the cluster the component runs in.
+	KubeconfigFile string `json:"kubeconfigFile"`
+}
+
+var (
+	clustersLock sync.RWMutex
+	// clusters are the added clusters in the order they were added
+	clusters []Cluster
+)
+
+// LoadClusters adds the clusters of a JSON file, see ClusterConfig. An empty path adds none.
+func LoadClusters(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var configs []ClusterConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return fmt.Errorf("failed to parse clusters file %s: %w", path, err)
+	}
+	for _, c := range configs {
+		if err := AddCluster(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddCluster makes the GameServers of the cluster visible to GetCluster, Clusters and FindGameServer.
+func AddCluster(config ClusterConfig) error {
+	if config.Name == "" || config.Name == LocalCluster {
+		return fmt.Errorf("cluster name %q is reserved", config.Name)
+	}
+
+	cluster := Cluster{Name: config.Name, Namespace: config.Namespace, Agones: AgonesClient, Kube: KubeClient, local: true}
+	if config.KubeconfigFile != "" {
+		restConfig, err := clientcmd.BuildConfigFromFlags("", config.KubeconfigFile)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", config.Name, err)
+		}
+		if cluster.Agones, err = versioned.NewForConfig(restConfig); err != nil {
+			return fmt.Errorf("cluster %s: %w", config.Name, err)
+		}
+		if cluster.Kube, err = kubernetes.NewForConfig(restConfig); err != nil {
+			return fmt.Errorf("cluster %s: %w", config.Name, err)
+		}
+		cluster.local = false
+	}
+
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+	for _, c := range clusters {
+		if c.Name == config.Name {
+			return fmt.Errorf("cluster %s is added twice", config.Name)
+		}
+	}
+	clusters = append(clusters, cluster)
+	return nil
+}
+
+// GetCluster returns the named cluster. LocalCluster, or an empty name, is the cluster the component runs in
+// with the given namespace.
+func GetCluster(name string, namespace string) (Cluster, error) {
+	if name == "" || name == LocalCluster {
+		return localCluster(namespace), nil
+	}
+
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	for _, c := range clusters {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Cluster{}, fmt.Errorf("unknown cluster %s", name)
+}
+
+// Clusters returns the cluster the component runs in, with the given namespace, followed by the added clusters.
+// An added cluster without a kubeconfig for the same namespace is the local one, so it is only returned once.
+func Clusters(namespace string) []Cluster {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+
+	result := []Cluster{localCluster(namespace)}
+	for _, c := range clusters {
+		if c.local && c.Namespace == namespace {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// FindGameServer gets the named GameServer from whichever cluster runs it, trying the cluster the component
+// runs in first. A cluster that can't be reached doesn't stop the others being tried, its error is only returned
+// if no cluster has the GameServer.
+func FindGameServer(ctx context.Context, namespace string, name string) (*agonesv1.GameServer, Cluster, error) {
+	var firstErr error
+	for _, c := range Clusters(namespace) {
+		gs, err := c.Agones.AgonesV1().GameServers(c.Namespace).Get(ctx, name, v1.GetOptions{})
+		if err == nil {
+			return gs, c, nil
+		}
+		if firstErr == nil || (k8serrors.IsNotFound(firstErr) && !k8serrors.IsNotFound(err)) {
+			firstErr = err
+		}
+	}
+	return nil, Cluster{}, firstErr
+}
+
+// FindPod gets the named Pod, e.g. of a GameServer, from whichever cluster runs it, like FindGameServer.
+func FindPod(ctx context.Context, namespace string, name string) (*corev1.Pod, error) {
+	var firstErr error
+	for _, c := range Clusters(namespace) {
+		pod, err := c.Kube.CoreV1().Pods(c.Namespace).Get(ctx, name, v1.GetOptions{})
+		if err == nil {
+			return pod, nil
+		}
+		if firstErr == nil || (k8serrors.IsNotFound(firstErr) && !k8serrors.IsNotFound(err)) {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func localCluster(namespace string) Cluster {
+	return Cluster{Name: LocalCluster, Namespace: namespace, Agones: AgonesClient, Kube: KubeClient, local: true}
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"flag"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
@@ -20,15 +19,13 @@ func CreateKubernetesConfig() (*rest.Config, error) {
 	if IsInCluster() {
 		config, err = rest.InClusterConfig()
 	} else {
-		var kubeConfig *string
-		if home := homedir.HomeDir(); home != "" {
-			kubeConfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-		} else {
-			kubeConfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+		// the command line belongs to each binary's appconfig, so the kubeconfig is only taken from the environment
+		kubeConfig := env.GetString("KUBECONFIG", "")
+		if home := homedir.HomeDir(); kubeConfig == "" && home != "" {
+			kubeConfig = filepath.Join(home, ".kube", "config")
 		}
-		flag.Parse()
 
-		config, err = clientcmd.BuildConfigFromFlags("", *kubeConfig)
+		config, err = clientcmd.BuildConfigFromFlags("", kubeConfig)
 	}
 
 	if err != nil {
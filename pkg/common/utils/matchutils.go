@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+func GetMatchStringExtension(match *pb.Match, key string) (string, bool) {
+	a, ok := match.GetExtensions()[key]
+	if !ok {
+		return "", false
+	}
+	var value wrapperspb.StringValue
+	err := a.UnmarshalTo(&value)
+	if err != nil {
+		logger.Error("Failed to unmarshal match extension", zap.String("matchId", match.MatchId), zap.String("key", key), zap.Error(err))
+		return "", false
+	}
+	return value.Value, true
+}
+
+func SetMatchStringExtension(match *pb.Match, key string, value string) error {
+	if match.Extensions == nil {
+		match.Extensions = make(map[string]*anypb.Any)
+	}
+	a, err := anypb.New(wrapperspb.String(value))
+	if err != nil {
+		return err
+	}
+	match.Extensions[key] = a
+	return nil
+}
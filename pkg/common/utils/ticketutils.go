@@ -1,14 +1,23 @@
 package utils
 
 import (
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"go.uber.org/zap"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"open-match.dev/open-match/pkg/pb"
 )
 
 var logger, _ = zap.NewProduction()
 
+const (
+	// ClientVersionArg is the search field containing the protocol version of the player's client
+	ClientVersionArg = "clientVersion"
+)
+
 func ExtractPlayerIdFromTicket(ticket *pb.Ticket) (string, error) {
 	a := ticket.PersistentField["playerId"]
 	var value wrappers.StringValue
@@ -31,3 +40,124 @@ func ExtractPlayerIdsFromTickets(tickets []*pb.Ticket) []string {
 	}
 	return playerIds
 }
+
+// ExtractTargetPlayerIdFromTicket returns the player ID the ticket wants to join, if present.
+func ExtractTargetPlayerIdFromTicket(ticket *pb.Ticket) (string, bool) {
+	a, ok := ticket.PersistentField["targetPlayerId"]
+	if !ok {
+		return "", false
+	}
+	var value wrappers.StringValue
+	err := proto.Unmarshal(a.Value, &value)
+	if err != nil {
+		logger.Error("Failed to extract target player id from ticket", zap.String("ticketId", ticket.Id), zap.Error(err))
+		return "", false
+	}
+	return value.Value, value.Value != ""
+}
+
+// IsSpectatorTicket checks if the ticket is for a spectator, who doesn't take a player slot.
+func IsSpectatorTicket(ticket *pb.Ticket) bool {
+	a, ok := ticket.PersistentField["spectator"]
+	if !ok {
+		return false
+	}
+	var value wrappers.BoolValue
+	err := proto.Unmarshal(a.Value, &value)
+	if err != nil {
+		logger.Error("Failed to extract spectator from ticket", zap.String("ticketId", ticket.Id), zap.Error(err))
+		return false
+	}
+	return value.Value
+}
+
+// NewPlayerTicket creates a ticket for the player in the given pool.
+// persistentFields are added alongside the playerId.
+func NewPlayerTicket(poolName string, playerId string, persistentFields map[string]protov2.Message) (*pb.Ticket, error) {
+	fields := make(map[string]*anypb.Any, len(persistentFields)+1)
+
+	playerIdAny, err := anypb.New(wrapperspb.String(playerId))
+	if err != nil {
+		return nil, err
+	}
+	fields["playerId"] = playerIdAny
+
+	for k, v := range persistentFields {
+		a, err := anypb.New(v)
+		if err != nil {
+			return nil, err
+		}
+		fields[k] = a
+	}
+
+	return &pb.Ticket{
+		SearchFields: &pb.SearchFields{
+			Tags: []string{fmt.Sprintf("game.%s", poolName)},
+		},
+		PersistentField: fields,
+	}, nil
+}
+
+// ExtractRematchIdFromTicket returns the ID of the rematch group the ticket is part of, if present.
+func ExtractRematchIdFromTicket(ticket *pb.Ticket) (string, bool) {
+	a, ok := ticket.PersistentField["rematchId"]
+	if !ok {
+		return "", false
+	}
+	var value wrappers.StringValue
+	err := proto.Unmarshal(a.Value, &value)
+	if err != nil {
+		logger.Error("Failed to extract rematch id from ticket", zap.String("ticketId", ticket.Id), zap.Error(err))
+		return "", false
+	}
+	return value.Value, value.Value != ""
+}
+
+// ExtractPriorityFromTicket returns the priority of the ticket, tickets with a higher priority are matched first.
+// Tickets without a priority have a priority of 0.
+func ExtractPriorityFromTicket(ticket *pb.Ticket) int32 {
+	a, ok := ticket.PersistentField["priority"]
+	if !ok {
+		return 0
+	}
+	var value wrappers.Int32Value
+	err := proto.Unmarshal(a.Value, &value)
+	if err != nil {
+		logger.Error("Failed to extract priority from ticket", zap.String("ticketId", ticket.Id), zap.Error(err))
+		return 0
+	}
+	return value.Value
+}
+
+// ExtractPreferredGameServerFromTicket returns the name of the GameServer the ticket would prefer to be allocated to, if present.
+func ExtractPreferredGameServerFromTicket(ticket *pb.Ticket) (string, bool) {
+	a, ok := ticket.PersistentField["preferredGameServer"]
+	if !ok {
+		return "", false
+	}
+	var value wrappers.StringValue
+	err := proto.Unmarshal(a.Value, &value)
+	if err != nil {
+		logger.Error("Failed to extract preferred gameserver from ticket", zap.String("ticketId", ticket.Id), zap.Error(err))
+		return "", false
+	}
+	return value.Value, value.Value != ""
+}
+
+// SetTicketClientVersion sets the protocol version of the player's client so the ticket is only
+// matched in pools of fleets that support it, see modeprofile.VersionRoute.
+func SetTicketClientVersion(ticket *pb.Ticket, version int) {
+	if ticket.SearchFields == nil {
+		ticket.SearchFields = &pb.SearchFields{}
+	}
+	if ticket.SearchFields.DoubleArgs == nil {
+		ticket.SearchFields.DoubleArgs = make(map[string]float64)
+	}
+	ticket.SearchFields.DoubleArgs[ClientVersionArg] = float64(version)
+}
+
+// ExtractClientVersionFromTicket returns the protocol version of the player's client, if present.
+func ExtractClientVersionFromTicket(ticket *pb.Ticket) (int, bool) {
+	version, ok := ticket.GetSearchFields().GetDoubleArgs()[ClientVersionArg]
+	return int(version), ok
+}
@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/metrics"
+	"net/http"
+)
+
+const (
+	resultOk     = "ok"
+	resultDenied = "denied"
+	resultError  = "error"
+)
+
+var (
+	// auditLogger writes one entry for every admin request, named so the entries can be shipped separately
+	auditLogger = logger.Named("audit")
+
+	actions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "admin_actions_total",
+		Help:      "Admin API requests by action and result",
+	}, []string{"action", "result"})
+)
+
+// audit records an admin request: who made it, what it asked for and how it ended.
+// Denied requests are audited as well, with an empty actor.
+func audit(r *http.Request, actor string, action string, params any, result string, err error) {
+	actions.WithLabelValues(action, result).Inc()
+
+	fields := []zap.Field{
+		zap.String("actor", actor),
+		zap.String("action", action),
+		zap.Any("params", params),
+		zap.String("result", result),
+		zap.String("remoteAddr", r.RemoteAddr),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	auditLogger.Info("Admin action", fields...)
+}
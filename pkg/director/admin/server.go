@@ -0,0 +1,461 @@
+// Package admin is the authenticated HTTP API operators use to act on a running matchmaker. Every request is audited.
+//
+// Pausing a mode and requesting a run are shared with the other director replicas through the State, countdowns
+// are forwarded to the match function's control API as only it knows about them.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/mmfcontrol"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils"
+	"net/http"
+	"open-match.dev/open-match/pkg/matchfunction"
+	"open-match.dev/open-match/pkg/pb"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Server serves the admin API. Requests must have an `Authorization: Bearer <token>` header with one of its tokens.
+type Server struct {
+	// tokens map[token]actor, the actor is the name audited for the token's requests
+	tokens   map[string]string
+	profiles map[string]modeprofile.ModeProfile
+	state    *State
+	fe       pb.FrontendServiceClient
+	query    pb.QueryServiceClient
+	control  *mmfcontrol.Client
+	mux      *http.ServeMux
+}
+
+// ModeStatus is a mode with its queue and countdowns.
+type ModeStatus struct {
+	Name   string `json:"name"`
+	Paused bool   `json:"paused"`
+	// Queued is the number of tickets in the mode's pools, PoolTickets by pool
+	Queued      int                    `json:"queued"`
+	PoolTickets map[string]int         `json:"poolTickets"`
+	Countdowns  []mmfcontrol.Countdown `json:"countdowns"`
+}
+
+type ModesResponse struct {
+	Modes []ModeStatus `json:"modes"`
+	// Errors of the queues or countdowns that couldn't be fetched, the rest of the response is still returned
+	Errors []string `json:"errors,omitempty"`
+}
+
+type ModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+type CountdownRequest struct {
+	Mode string `json:"mode"`
+	Pool string `json:"pool"`
+}
+
+type FleetWeightsRequest struct {
+	Mode string `json:"mode"`
+	// Weights maps a fleet name to its new weight, fleets not present keep their weight
+	Weights map[string]int `json:"weights"`
+	// Reset drops all runtime weights of the mode instead, so the configured weights are used
+	Reset bool `json:"reset"`
+}
+
+type ModeFleets struct {
+	Routes []modeprofile.VersionRoute `json:"routes"`
+	// Overrides are the weights set at runtime, replacing the configured weight of the fleet
+	Overrides map[string]int `json:"overrides"`
+}
+
+type KickRequest struct {
+	PlayerId string `json:"playerId"`
+}
+
+type KickResponse struct {
+	TicketIds []string `json:"ticketIds"`
+}
+
+// statusError is an error that is returned to the caller with its status code
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// LoadTokens reads the admin tokens from a JSON file of actor names to tokens, e.g. {"alice": "s3cr3t"}.
+func LoadTokens(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var actorTokens map[string]string
+	if err := json.Unmarshal(data, &actorTokens); err != nil {
+		return nil, fmt.Errorf("could not parse admin tokens: %w", err)
+	}
+
+	tokens := make(map[string]string, len(actorTokens))
+	for actor, token := range actorTokens {
+		if token == "" {
+			return nil, fmt.Errorf("admin token of %s is empty", actor)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("admin token of %s is used by another actor", actor)
+		}
+		tokens[token] = actor
+	}
+	return tokens, nil
+}
+
+func NewServer(tokens map[string]string, profiles map[string]modeprofile.ModeProfile, state *State,
+	fe pb.FrontendServiceClient, query pb.QueryServiceClient, control *mmfcontrol.Client) *Server {
+
+	s := &Server{
+		tokens:   tokens,
+		profiles: profiles,
+		state:    state,
+		fe:       fe,
+		query:    query,
+		control:  control,
+		mux:      http.NewServeMux(),
+	}
+
+	s.mux.Handle("/admin/v1/modes", s.action("listModes", http.MethodGet, s.listModes))
+	s.mux.Handle("/admin/v1/modes/pause", s.action("pauseMode", http.MethodPost, s.pauseMode))
+	s.mux.Handle("/admin/v1/modes/resume", s.action("resumeMode", http.MethodPost, s.resumeMode))
+	s.mux.Handle("/admin/v1/modes/run", s.action("runMode", http.MethodPost, s.runMode))
+	s.mux.Handle("/admin/v1/countdowns/start", s.action("startCountdown", http.MethodPost, s.startCountdown))
+	s.mux.Handle("/admin/v1/countdowns/cancel", s.action("cancelCountdown", http.MethodPost, s.cancelCountdown))
+	s.mux.Handle("/admin/v1/players/kick", s.action("kickPlayer", http.MethodPost, s.kickPlayer))
+	s.mux.Handle("/admin/v1/fleets", s.action("listFleets", http.MethodGet, s.listFleets))
+	s.mux.Handle("/admin/v1/fleets/weights", s.action("setFleetWeights", http.MethodPost, s.setFleetWeights))
+
+	return s
+}
+
+// Start hosts the admin API on the given port. This blocks until the server fails.
+func (s *Server) Start(port int) {
+	logger.Info("Starting admin API", zap.Int("port", port), zap.Int("actors", len(s.tokens)))
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), s.mux); err != nil {
+		logger.Error("Admin API failed", zap.Error(err))
+	}
+}
+
+// action authenticates and audits the requests of an action. handle returns the request's parameters for the audit,
+// decoded from the body, and the response.
+func (s *Server) action(name string, method string, handle func(r *http.Request) (params any, resp any, err error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := s.authenticate(r)
+		if !ok {
+			audit(r, "", name, nil, resultDenied, nil)
+			writeError(w, http.StatusUnauthorized, errors.New("a valid bearer token is required"))
+			return
+		}
+		if r.Method != method {
+			err := fmt.Errorf("method %s not allowed", r.Method)
+			audit(r, actor, name, nil, resultError, err)
+			writeError(w, http.StatusMethodNotAllowed, err)
+			return
+		}
+
+		params, resp, err := handle(r)
+		if err != nil {
+			audit(r, actor, name, params, resultError, err)
+			code := http.StatusInternalServerError
+			var statusErr *statusError
+			if errors.As(err, &statusErr) {
+				code = statusErr.code
+			}
+			writeError(w, code, err)
+			return
+		}
+
+		audit(r, actor, name, params, resultOk, nil)
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// authenticate returns the actor of the request's bearer token. Every token is compared in constant time.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == "" {
+		return "", false
+	}
+
+	actor, found := "", false
+	for t, a := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			actor, found = a, true
+		}
+	}
+	return actor, found
+}
+
+// listModes returns every mode with its queue and countdowns. Queues and countdowns that can't be fetched are
+// reported in the errors so the rest can still be seen.
+func (s *Server) listModes(r *http.Request) (any, any, error) {
+	var resp ModesResponse
+
+	countdowns, err := s.control.Countdowns(r.Context())
+	if err != nil {
+		resp.Errors = append(resp.Errors, fmt.Sprintf("countdowns: %s", err))
+	}
+
+	for name, profile := range s.profiles {
+		mode := ModeStatus{
+			Name:        name,
+			Paused:      s.state.IsPaused(name),
+			PoolTickets: make(map[string]int),
+			Countdowns:  []mmfcontrol.Countdown{},
+		}
+
+		poolTickets, err := s.queryPools(r.Context(), profile)
+		if err != nil {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("queue of %s: %s", name, err))
+		}
+		for pool, tickets := range poolTickets {
+			mode.PoolTickets[pool] = len(tickets)
+			mode.Queued += len(tickets)
+		}
+		for _, countdown := range countdowns {
+			if countdown.Mode == name {
+				mode.Countdowns = append(mode.Countdowns, countdown)
+			}
+		}
+		resp.Modes = append(resp.Modes, mode)
+	}
+
+	sort.Slice(resp.Modes, func(i, j int) bool {
+		return resp.Modes[i].Name < resp.Modes[j].Name
+	})
+	return nil, resp, nil
+}
+
+func (s *Server) pauseMode(r *http.Request) (any, any, error) {
+	return s.setPaused(r, true)
+}
+
+func (s *Server) resumeMode(r *http.Request) (any, any, error) {
+	return s.setPaused(r, false)
+}
+
+// setPaused pauses or resumes a mode on every replica. Matches of a paused mode aren't fetched,
+// so its tickets wait in the queue and its countdowns stop.
+func (s *Server) setPaused(r *http.Request, paused bool) (any, any, error) {
+	var req ModeRequest
+	if err := s.readMode(r, &req, &req.Mode); err != nil {
+		return req, nil, err
+	}
+	if err := s.state.SetPaused(r.Context(), req.Mode, paused); err != nil {
+		return req, nil, err
+	}
+	return req, struct{}{}, nil
+}
+
+// runMode runs a mode straight away on the replica running it, instead of waiting for its next interval.
+func (s *Server) runMode(r *http.Request) (any, any, error) {
+	var req ModeRequest
+	if err := s.readMode(r, &req, &req.Mode); err != nil {
+		return req, nil, err
+	}
+	if err := s.state.RequestRun(r.Context(), req.Mode); err != nil {
+		return req, nil, err
+	}
+	return req, struct{}{}, nil
+}
+
+// startCountdown ends a countdown now and runs its mode, so the match is made with the players in the pool.
+func (s *Server) startCountdown(r *http.Request) (any, any, error) {
+	var req CountdownRequest
+	if err := s.readCountdown(r, &req); err != nil {
+		return req, nil, err
+	}
+	if err := s.control.StartCountdown(r.Context(), req.Mode, req.Pool); err != nil {
+		return req, nil, controlError(err)
+	}
+	if err := s.state.RequestRun(r.Context(), req.Mode); err != nil {
+		return req, nil, err
+	}
+	return req, struct{}{}, nil
+}
+
+// cancelCountdown cancels a countdown and notifies its players. The pool starts a new countdown at its next run
+// if it still has enough players, unless the mode is paused.
+func (s *Server) cancelCountdown(r *http.Request) (any, any, error) {
+	var req CountdownRequest
+	if err := s.readCountdown(r, &req); err != nil {
+		return req, nil, err
+	}
+	if err := s.control.CancelCountdown(r.Context(), req.Mode, req.Pool); err != nil {
+		return req, nil, controlError(err)
+	}
+	return req, struct{}{}, nil
+}
+
+// kickPlayer deletes the player's tickets waiting in the queue of any mode. Tickets already in a match
+// that is being allocated can't be found, so are not kicked.
+func (s *Server) kickPlayer(r *http.Request) (any, any, error) {
+	var req KickRequest
+	if err := readJSON(r, &req); err != nil {
+		return req, nil, err
+	}
+	if req.PlayerId == "" {
+		return req, nil, &statusError{code: http.StatusBadRequest, err: errors.New("playerId is required")}
+	}
+
+	ticketIds := make(map[string]bool)
+	for name, profile := range s.profiles {
+		poolTickets, err := s.queryPools(r.Context(), profile)
+		if err != nil {
+			return req, nil, fmt.Errorf("failed to query the queue of %s: %w", name, err)
+		}
+		for _, tickets := range poolTickets {
+			for _, ticket := range tickets {
+				if playerId, err := utils.ExtractPlayerIdFromTicket(ticket); err == nil && playerId == req.PlayerId {
+					ticketIds[ticket.GetId()] = true
+				}
+			}
+		}
+	}
+	if len(ticketIds) == 0 {
+		return req, nil, &statusError{code: http.StatusNotFound, err: fmt.Errorf("player %s has no tickets in the queue", req.PlayerId)}
+	}
+
+	resp := KickResponse{TicketIds: make([]string, 0, len(ticketIds))}
+	for ticketId := range ticketIds {
+		err := deadline.OpenMatch.Call(r.Context(), "DeleteTicket", func(ctx context.Context) error {
+			_, err := s.fe.DeleteTicket(ctx, &pb.DeleteTicketRequest{TicketId: ticketId})
+			return err
+		})
+		if err != nil {
+			return req, nil, fmt.Errorf("failed to delete ticket %s after deleting %v: %w", ticketId, resp.TicketIds, err)
+		}
+		resp.TicketIds = append(resp.TicketIds, ticketId)
+	}
+	return req, resp, nil
+}
+
+// listFleets returns the fleets of every mode with their configured and runtime weights.
+func (s *Server) listFleets(r *http.Request) (any, any, error) {
+	resp := make(map[string]ModeFleets, len(s.profiles))
+	for name, profile := range s.profiles {
+		resp[name] = ModeFleets{
+			Routes:    profile.Routes(),
+			Overrides: s.state.FleetWeights(name),
+		}
+	}
+	return nil, resp, nil
+}
+
+// setFleetWeights changes the weights of a mode's fleets on every replica, e.g. setting a bad canary's weight to 0
+// rolls it back. Matches that already picked a fleet keep it.
+func (s *Server) setFleetWeights(r *http.Request) (any, any, error) {
+	var req FleetWeightsRequest
+	if err := s.readMode(r, &req, &req.Mode); err != nil {
+		return req, nil, err
+	}
+	profile := s.profiles[req.Mode]
+
+	if req.Reset {
+		if err := s.state.ResetFleetWeights(r.Context(), req.Mode); err != nil {
+			return req, nil, err
+		}
+	} else {
+		if len(req.Weights) == 0 {
+			return req, nil, &statusError{code: http.StatusBadRequest, err: errors.New("weights or reset are required")}
+		}
+		if err := s.state.SetFleetWeights(r.Context(), profile, req.Weights); err != nil {
+			return req, nil, err
+		}
+	}
+
+	return req, ModeFleets{
+		Routes:    profile.Routes(),
+		Overrides: s.state.FleetWeights(req.Mode),
+	}, nil
+}
+
+// readMode reads the request and checks its mode exists.
+func (s *Server) readMode(r *http.Request, req any, mode *string) error {
+	if err := readJSON(r, req); err != nil {
+		return err
+	}
+	if _, ok := s.profiles[*mode]; !ok {
+		return &statusError{code: http.StatusNotFound, err: fmt.Errorf("mode %q not found", *mode)}
+	}
+	return nil
+}
+
+func (s *Server) readCountdown(r *http.Request, req *CountdownRequest) error {
+	if err := s.readMode(r, req, &req.Mode); err != nil {
+		return err
+	}
+	if req.Pool == "" {
+		return &statusError{code: http.StatusBadRequest, err: errors.New("pool is required")}
+	}
+	return nil
+}
+
+func (s *Server) queryPools(ctx context.Context, profile modeprofile.ModeProfile) (map[string][]*pb.Ticket, error) {
+	var poolTickets map[string][]*pb.Ticket
+	err := deadline.OpenMatch.Call(ctx, "QueryPools", func(ctx context.Context) error {
+		var err error
+		poolTickets, err = matchfunction.QueryPools(ctx, s.query, profile.MatchProfile.GetPools())
+		return err
+	})
+	return poolTickets, err
+}
+
+// controlError passes the match function's errors on with their status code. Its failures, and it not accepting
+// the director's token, are bad gateways as the caller's request was fine.
+func controlError(err error) error {
+	var controlErr *mmfcontrol.Error
+	if !errors.As(err, &controlErr) {
+		return err
+	}
+	code := controlErr.StatusCode
+	if code >= http.StatusInternalServerError || code == http.StatusUnauthorized {
+		code = http.StatusBadGateway
+	}
+	return &statusError{code: code, err: err}
+}
+
+func readJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &statusError{code: http.StatusBadRequest, err: fmt.Errorf("invalid request body: %w", err)}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Failed to write response", zap.Error(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,306 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/director/fleets"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	pausedModesKey  = "paused-modes"
+	runRequestsKey  = "run-requests"
+	fleetWeightsKey = "fleet-weights"
+)
+
+// State is the admin state shared by every director replica, paused modes, requested runs and fleet weight overrides,
+// stored in a ConfigMap as any replica can serve an admin request while another runs the profile. Each replica polls the ConfigMap, so changes apply within the sync interval.
+type State struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	lock        sync.RWMutex
+	pausedModes map[string]bool
+	// runRequests map[mode]requestedAt of the last run requested for each mode
+	runRequests map[string]time.Time
+	// fleetWeights map[mode]map[fleetName]weight overrides the configured weights of the mode's fleets
+	fleetWeights map[string]map[string]int
+	// onRunRequested is called for runs requested since the last sync
+	onRunRequested func(mode string)
+}
+
+type sharedState struct {
+	pausedModes  map[string]bool
+	runRequests  map[string]time.Time
+	fleetWeights map[string]map[string]int
+}
+
+// NewState creates the State stored in the ConfigMap. onRunRequested is called with the mode of each run requested,
+// by any replica, after the State was created.
+func NewState(client kubernetes.Interface, namespace string, name string, onRunRequested func(mode string)) *State {
+	return &State{
+		client:         client,
+		namespace:      namespace,
+		name:           name,
+		pausedModes:    make(map[string]bool),
+		runRequests:    make(map[string]time.Time),
+		fleetWeights:   make(map[string]map[string]int),
+		onRunRequested: onRunRequested,
+	}
+}
+
+// Start syncs the State from the ConfigMap every interval until the context is done.
+// It syncs once before returning so paused modes aren't run at startup.
+func (s *State) Start(ctx context.Context, interval time.Duration) {
+	if err := s.sync(ctx, true); err != nil {
+		logger.Error("Failed to sync admin state", zap.Error(err))
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.sync(ctx, false); err != nil {
+				logger.Error("Failed to sync admin state", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// IsPaused reports whether runs of the mode are paused.
+func (s *State) IsPaused(mode string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.pausedModes[mode]
+}
+
+// SetPaused pauses or resumes runs of the mode on every replica.
+func (s *State) SetPaused(ctx context.Context, mode string, paused bool) error {
+	err := s.update(ctx, func(state *sharedState) error {
+		if paused {
+			state.pausedModes[mode] = true
+		} else {
+			delete(state.pausedModes, mode)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.sync(ctx, false)
+}
+
+// RequestRun asks the replica running the mode to run it straight away.
+func (s *State) RequestRun(ctx context.Context, mode string) error {
+	err := s.update(ctx, func(state *sharedState) error {
+		state.runRequests[mode] = time.Now()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.sync(ctx, false)
+}
+
+// FleetWeights returns the runtime weight overrides of the mode's fleets.
+func (s *State) FleetWeights(mode string) map[string]int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := make(map[string]int, len(s.fleetWeights[mode]))
+	for k, v := range s.fleetWeights[mode] {
+		result[k] = v
+	}
+	return result
+}
+
+// SetFleetWeights overrides the weights of the profile's fleets on every replica, merged with its current overrides.
+// Fleets that aren't overridden keep their configured weight.
+func (s *State) SetFleetWeights(ctx context.Context, profile modeprofile.ModeProfile, overrides map[string]int) error {
+	err := s.update(ctx, func(state *sharedState) error {
+		merged := make(map[string]int, len(state.fleetWeights[profile.Name])+len(overrides))
+		for k, v := range state.fleetWeights[profile.Name] {
+			merged[k] = v
+		}
+		for k, v := range overrides {
+			merged[k] = v
+		}
+		if err := fleets.ValidateWeights(profile, merged); err != nil {
+			return &statusError{code: http.StatusBadRequest, err: err}
+		}
+		state.fleetWeights[profile.Name] = merged
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.sync(ctx, false)
+}
+
+// ResetFleetWeights drops the runtime overrides of the mode's fleets so the configured weights are used again.
+func (s *State) ResetFleetWeights(ctx context.Context, mode string) error {
+	err := s.update(ctx, func(state *sharedState) error {
+		delete(state.fleetWeights, mode)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.sync(ctx, false)
+}
+
+// sync reads the ConfigMap and triggers the runs requested since the last sync.
+// On the first sync the requests are only recorded, as they were made before this replica started.
+func (s *State) sync(ctx context.Context, first bool) error {
+	state, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	var requested []string
+	for mode, requestedAt := range state.runRequests {
+		if !first && requestedAt.After(s.runRequests[mode]) {
+			requested = append(requested, mode)
+		}
+	}
+	s.pausedModes = state.pausedModes
+	s.runRequests = state.runRequests
+	s.fleetWeights = state.fleetWeights
+	s.lock.Unlock()
+
+	for _, mode := range requested {
+		s.onRunRequested(mode)
+	}
+	return nil
+}
+
+func (s *State) get(ctx context.Context) (sharedState, error) {
+	var configMap *corev1.ConfigMap
+	err := deadline.Kubernetes.Call(ctx, "GetConfigMap", func(ctx context.Context) error {
+		var err error
+		configMap, err = s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		return err
+	})
+	if k8serrors.IsNotFound(err) {
+		return decodeState(nil)
+	}
+	if err != nil {
+		return sharedState{}, err
+	}
+	return decodeState(configMap.Data)
+}
+
+// update changes the ConfigMap, creating it if it doesn't exist, retrying if another replica changed it first.
+// An error returned by change is returned without updating the ConfigMap.
+func (s *State) update(ctx context.Context, change func(state *sharedState) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return deadline.Kubernetes.Call(ctx, "UpdateConfigMap", func(ctx context.Context) error {
+			configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+			configMap, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+			notFound := k8serrors.IsNotFound(err)
+			if err != nil && !notFound {
+				return err
+			}
+			if notFound {
+				configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace}}
+			}
+
+			state, err := decodeState(configMap.Data)
+			if err != nil {
+				return err
+			}
+			if err := change(&state); err != nil {
+				return err
+			}
+			configMap.Data, err = encodeState(state)
+			if err != nil {
+				return err
+			}
+
+			if notFound {
+				_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+				if k8serrors.IsAlreadyExists(err) {
+					// another replica created it first, retry as a conflict to update theirs
+					return k8serrors.NewConflict(corev1.Resource("configmaps"), s.name, err)
+				}
+				return err
+			}
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+			return err
+		})
+	})
+}
+
+func decodeState(data map[string]string) (sharedState, error) {
+	state := sharedState{
+		pausedModes:  make(map[string]bool),
+		runRequests:  make(map[string]time.Time),
+		fleetWeights: make(map[string]map[string]int),
+	}
+
+	if v, ok := data[pausedModesKey]; ok {
+		var modes []string
+		if err := json.Unmarshal([]byte(v), &modes); err != nil {
+			return sharedState{}, fmt.Errorf("could not parse %s: %w", pausedModesKey, err)
+		}
+		for _, mode := range modes {
+			state.pausedModes[mode] = true
+		}
+	}
+	if v, ok := data[runRequestsKey]; ok {
+		if err := json.Unmarshal([]byte(v), &state.runRequests); err != nil {
+			return sharedState{}, fmt.Errorf("could not parse %s: %w", runRequestsKey, err)
+		}
+	}
+	if v, ok := data[fleetWeightsKey]; ok {
+		if err := json.Unmarshal([]byte(v), &state.fleetWeights); err != nil {
+			return sharedState{}, fmt.Errorf("could not parse %s: %w", fleetWeightsKey, err)
+		}
+	}
+	return state, nil
+}
+
+func encodeState(state sharedState) (map[string]string, error) {
+	modes := make([]string, 0, len(state.pausedModes))
+	for mode := range state.pausedModes {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+
+	pausedModes, err := json.Marshal(modes)
+	if err != nil {
+		return nil, err
+	}
+	runRequests, err := json.Marshal(state.runRequests)
+	if err != nil {
+		return nil, err
+	}
+	fleetWeights, err := json.Marshal(state.fleetWeights)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		pausedModesKey:  string(pausedModes),
+		runRequestsKey:  string(runRequests),
+		fleetWeightsKey: string(fleetWeights),
+	}, nil
+}
@@ -0,0 +1,48 @@
+package allocator
+
+import (
+	allocatorv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils/kubernetes"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+
+	// ErrNoCapacity is returned when no cluster has a GameServer that can be allocated
+	ErrNoCapacity = errors.New("no gameserver available to allocate")
+)
+
+// Allocator creates GameServerAllocations for matches.
+type Allocator interface {
+	// Allocate returns the allocation with its status. A status of GameServerAllocationUnAllocated
+	// is not returned as an error, callers must check the state. Status.Source is the name of the
+	// cluster the GameServer was allocated in, see kubernetes.GetCluster.
+	Allocate(ctx context.Context, profile modeprofile.ModeProfile, gsa *allocatorv1.GameServerAllocation) (*allocatorv1.GameServerAllocation, error)
+}
+
+// KubernetesAllocator creates GameServerAllocations through the Kubernetes API of the cluster the director runs in.
+// The allocation's Status.Source is kubernetes.LocalCluster.
+type KubernetesAllocator struct {
+	namespace string
+}
+
+func NewKubernetesAllocator(namespace string) *KubernetesAllocator {
+	return &KubernetesAllocator{namespace: namespace}
+}
+
+func (a *KubernetesAllocator) Allocate(ctx context.Context, _ modeprofile.ModeProfile, gsa *allocatorv1.GameServerAllocation) (*allocatorv1.GameServerAllocation, error) {
+	result, err := kubernetes.AgonesClient.AllocationV1().
+		GameServerAllocations(a.namespace).
+		Create(ctx, gsa, v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// multi-cluster GameServerAllocations aren't created by the director, so it is always allocated locally
+	result.Status.Source = kubernetes.LocalCluster
+	return result, nil
+}
@@ -0,0 +1,277 @@
+package allocator
+
+import (
+	pb "agones.dev/agones/pkg/allocation/go/v1"
+	"agones.dev/agones/pkg/apis"
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocatorv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"os"
+)
+
+// Cluster is an Agones cluster reachable through its allocator service.
+type Cluster struct {
+	Name      string `json:"name"`
+	Endpoint  string `json:"endpoint"`
+	Namespace string `json:"namespace"`
+
+	// mTLS, if CertFile is empty the connection is insecure (e.g. for a local fake allocator)
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	CAFile   string `json:"caFile"`
+
+	// MultiClusterPolicy selects GameServerAllocationPolicies if set, so the allocator service
+	// can forward the allocation to other clusters itself. The clusters it forwards to must also be in the
+	// clusters file, with the endpoint the policy uses, so the director can manage their GameServers.
+	MultiClusterPolicy map[string]string `json:"multiClusterPolicy"`
+
+	// KubeconfigFile is the kubeconfig of the cluster's Kubernetes API, used to manage its allocated GameServers,
+	// see kubernetes.ClusterConfig. If empty the cluster is the one the director runs in.
+	KubeconfigFile string `json:"kubeconfigFile"`
+}
+
+// ServiceAllocator allocates through the Agones allocator gRPC service of one or more clusters.
+// Clusters are tried in the order of the ModeProfile's cluster preference, falling back to the next
+// cluster when one has no capacity. The allocation's Status.Source is the name of the cluster the
+// GameServer was allocated in.
+type ServiceAllocator struct {
+	clusters []serviceCluster
+}
+
+type serviceCluster struct {
+	Cluster
+	client pb.AllocationServiceClient
+}
+
+// LoadClusters reads the clusters of a ServiceAllocator from a JSON file.
+func LoadClusters(path string) ([]Cluster, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var clusters []Cluster
+	if err := json.Unmarshal(data, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to parse clusters file %s: %w", path, err)
+	}
+	return clusters, nil
+}
+
+func NewServiceAllocator(clusters []Cluster) (*ServiceAllocator, error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("at least one cluster is required")
+	}
+
+	a := &ServiceAllocator{}
+	for _, c := range clusters {
+		creds, err := createCredentials(c)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+
+		conn, err := grpc.Dial(c.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: failed to connect to allocator service: %w", c.Name, err)
+		}
+
+		a.clusters = append(a.clusters, serviceCluster{Cluster: c, client: pb.NewAllocationServiceClient(conn)})
+	}
+	return a, nil
+}
+
+func (a *ServiceAllocator) Allocate(ctx context.Context, profile modeprofile.ModeProfile, gsa *allocatorv1.GameServerAllocation) (*allocatorv1.GameServerAllocation, error) {
+	var lastErr error
+	exhausted := false
+	for _, cluster := range a.orderClusters(profile.Clusters) {
+		resp, err := cluster.client.Allocate(ctx, toAllocationRequest(cluster.Cluster, gsa))
+		if status.Code(err) == codes.ResourceExhausted {
+			logger.Info("Cluster has no capacity, trying next cluster",
+				zap.String("cluster", cluster.Name), zap.String("profileName", profile.Name))
+			exhausted = true
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to allocate from cluster",
+				zap.String("cluster", cluster.Name), zap.String("profileName", profile.Name), zap.Error(err))
+			lastErr = err
+			continue
+		}
+
+		source, err := a.sourceCluster(cluster, resp.GetSource())
+		if err != nil {
+			return nil, err
+		}
+		return toAllocation(gsa, resp, source), nil
+	}
+
+	if !exhausted && lastErr != nil {
+		return nil, lastErr
+	}
+	result := gsa.DeepCopy()
+	result.Status.State = allocatorv1.GameServerAllocationUnAllocated
+	return result, nil
+}
+
+// orderClusters returns the preferred clusters first, followed by all other clusters.
+func (a *ServiceAllocator) orderClusters(preferred []string) []serviceCluster {
+	var ordered []serviceCluster
+	added := make(map[string]bool)
+	for _, name := range preferred {
+		for _, c := range a.clusters {
+			if c.Name == name && !added[name] {
+				ordered = append(ordered, c)
+				added[name] = true
+			}
+		}
+	}
+	for _, c := range a.clusters {
+		if !added[c.Name] {
+			ordered = append(ordered, c)
+		}
+	}
+	return ordered
+}
+
+// sourceCluster returns the name of the cluster an allocation made through the cluster was made in. The allocator
+// service reports "local", or the endpoint of the cluster a multi-cluster policy forwarded the allocation to.
+func (a *ServiceAllocator) sourceCluster(cluster serviceCluster, source string) (string, error) {
+	if source == "" || source == kubernetes.LocalCluster {
+		return cluster.Name, nil
+	}
+	for _, c := range a.clusters {
+		if c.Endpoint == source {
+			return c.Name, nil
+		}
+	}
+	return "", fmt.Errorf("cluster %s forwarded the allocation to %s, which isn't in the clusters file", cluster.Name, source)
+}
+
+func toAllocationRequest(cluster Cluster, gsa *allocatorv1.GameServerAllocation) *pb.AllocationRequest {
+	req := &pb.AllocationRequest{
+		Namespace:  cluster.Namespace,
+		Scheduling: pb.AllocationRequest_Packed,
+		Metadata: &pb.MetaPatch{
+			Labels:      gsa.Spec.MetaPatch.Labels,
+			Annotations: gsa.Spec.MetaPatch.Annotations,
+		},
+	}
+	if gsa.Spec.Scheduling == apis.Distributed {
+		req.Scheduling = pb.AllocationRequest_Distributed
+	}
+	if len(cluster.MultiClusterPolicy) > 0 {
+		req.MultiClusterSetting = &pb.MultiClusterSetting{
+			Enabled:        true,
+			PolicySelector: &pb.LabelSelector{MatchLabels: cluster.MultiClusterPolicy},
+		}
+	}
+
+	for _, s := range gsa.Spec.Selectors {
+		selector := &pb.GameServerSelector{
+			MatchLabels:     s.MatchLabels,
+			GameServerState: pb.GameServerSelector_READY,
+		}
+		if s.GameServerState != nil && *s.GameServerState == agonesv1.GameServerStateAllocated {
+			selector.GameServerState = pb.GameServerSelector_ALLOCATED
+		}
+		if s.Players != nil {
+			selector.Players = &pb.PlayerSelector{
+				MinAvailable: uint64(s.Players.MinAvailable),
+				MaxAvailable: uint64(s.Players.MaxAvailable),
+			}
+		}
+		for k, c := range s.Counters {
+			if selector.Counters == nil {
+				selector.Counters = make(map[string]*pb.CounterSelector)
+			}
+			selector.Counters[k] = &pb.CounterSelector{
+				MinCount:     c.MinCount,
+				MaxCount:     c.MaxCount,
+				MinAvailable: c.MinAvailable,
+				MaxAvailable: c.MaxAvailable,
+			}
+		}
+		for k, l := range s.Lists {
+			if selector.Lists == nil {
+				selector.Lists = make(map[string]*pb.ListSelector)
+			}
+			selector.Lists[k] = &pb.ListSelector{
+				MinAvailable: l.MinAvailable,
+				MaxAvailable: l.MaxAvailable,
+			}
+		}
+		req.GameServerSelectors = append(req.GameServerSelectors, selector)
+	}
+
+	for k, c := range gsa.Spec.Counters {
+		if req.Counters == nil {
+			req.Counters = make(map[string]*pb.CounterAction)
+		}
+		action := &pb.CounterAction{}
+		if c.Action != nil {
+			action.Action = wrapperspb.String(*c.Action)
+		}
+		if c.Amount != nil {
+			action.Amount = wrapperspb.Int64(*c.Amount)
+		}
+		req.Counters[k] = action
+	}
+	for k, l := range gsa.Spec.Lists {
+		if req.Lists == nil {
+			req.Lists = make(map[string]*pb.ListAction)
+		}
+		req.Lists[k] = &pb.ListAction{AddValues: l.AddValues}
+	}
+	return req
+}
+
+func toAllocation(gsa *allocatorv1.GameServerAllocation, resp *pb.AllocationResponse, source string) *allocatorv1.GameServerAllocation {
+	result := gsa.DeepCopy()
+	result.Status = allocatorv1.GameServerAllocationStatus{
+		State:          allocatorv1.GameServerAllocationAllocated,
+		GameServerName: resp.GetGameServerName(),
+		Address:        resp.GetAddress(),
+		NodeName:       resp.GetNodeName(),
+		Source:         source,
+	}
+	for _, p := range resp.GetPorts() {
+		result.Status.Ports = append(result.Status.Ports, agonesv1.GameServerStatusPort{Name: p.GetName(), Port: p.GetPort()})
+	}
+	return result
+}
+
+func createCredentials(c Cluster) (credentials.TransportCredentials, error) {
+	if c.CertFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse CA %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
@@ -0,0 +1,214 @@
+package allocator
+
+import (
+	pb "agones.dev/agones/pkg/allocation/go/v1"
+	allocatorv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"matchmaker/pkg/common/modeprofile"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+const testNamespace = "towerdefence"
+
+// fakeAllocationService is an in-process Agones allocator service answering with allocate.
+type fakeAllocationService struct {
+	pb.UnimplementedAllocationServiceServer
+	allocate func(req *pb.AllocationRequest) (*pb.AllocationResponse, error)
+	calls    atomic.Int32
+}
+
+func (s *fakeAllocationService) Allocate(_ context.Context, req *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	s.calls.Add(1)
+	return s.allocate(req)
+}
+
+// startAllocationService serves the fake on a local port until the test ends and returns its endpoint.
+func startAllocationService(t *testing.T, service *fakeAllocationService) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterAllocationServiceServer(server, service)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	return ln.Addr().String()
+}
+
+func exhausted(*pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	return nil, status.Error(codes.ResourceExhausted, "no available gameservers")
+}
+
+func allocated(gameServerName string, source string) func(*pb.AllocationRequest) (*pb.AllocationResponse, error) {
+	return func(*pb.AllocationRequest) (*pb.AllocationResponse, error) {
+		return &pb.AllocationResponse{
+			GameServerName: gameServerName,
+			Address:        "10.0.0.1",
+			Ports:          []*pb.AllocationResponse_GameServerStatusPort{{Name: "default", Port: 7654}},
+			Source:         source,
+		}, nil
+	}
+}
+
+func newTestAllocator(t *testing.T, services map[string]*fakeAllocationService, names ...string) *ServiceAllocator {
+	t.Helper()
+	var clusters []Cluster
+	for _, name := range names {
+		clusters = append(clusters, Cluster{Name: name, Endpoint: startAllocationService(t, services[name]), Namespace: testNamespace})
+	}
+	a, err := NewServiceAllocator(clusters)
+	if err != nil {
+		t.Fatalf("failed to create allocator: %v", err)
+	}
+	return a
+}
+
+func testAllocation() *allocatorv1.GameServerAllocation {
+	return &allocatorv1.GameServerAllocation{
+		Spec: allocatorv1.GameServerAllocationSpec{
+			Selectors: []allocatorv1.GameServerSelector{
+				{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"agones.dev/fleet": "lobby"}}},
+			},
+			MetaPatch: allocatorv1.MetaPatch{Annotations: map[string]string{"openmatch.dev/match-id": "match"}},
+		},
+	}
+}
+
+func TestAllocateFailsOverToNextClusterWhenExhausted(t *testing.T) {
+	services := map[string]*fakeAllocationService{
+		"eu-1": {allocate: exhausted},
+		"eu-2": {allocate: allocated("lobby-abcde", "local")},
+	}
+	a := newTestAllocator(t, services, "eu-1", "eu-2")
+
+	result, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby", Clusters: []string{"eu-1", "eu-2"}}, testAllocation())
+	if err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if result.Status.State != allocatorv1.GameServerAllocationAllocated {
+		t.Fatalf("expected state %s, got %s", allocatorv1.GameServerAllocationAllocated, result.Status.State)
+	}
+	if result.Status.GameServerName != "lobby-abcde" || result.Status.Source != "eu-2" {
+		t.Errorf("expected lobby-abcde from eu-2, got %s from %s", result.Status.GameServerName, result.Status.Source)
+	}
+	if len(result.Status.Ports) != 1 || result.Status.Ports[0].Port != 7654 {
+		t.Errorf("expected port 7654, got %v", result.Status.Ports)
+	}
+	if calls := services["eu-1"].calls.Load(); calls != 1 {
+		t.Errorf("expected eu-1 to be tried once, got %d", calls)
+	}
+}
+
+func TestAllocateTriesPreferredClustersFirst(t *testing.T) {
+	services := map[string]*fakeAllocationService{
+		"eu-1": {allocate: allocated("lobby-eu-1", "local")},
+		"us-1": {allocate: allocated("lobby-us-1", "local")},
+	}
+	a := newTestAllocator(t, services, "eu-1", "us-1")
+
+	result, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby", Clusters: []string{"us-1"}}, testAllocation())
+	if err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if result.Status.Source != "us-1" {
+		t.Errorf("expected to allocate from us-1, got %s", result.Status.Source)
+	}
+	if calls := services["eu-1"].calls.Load(); calls != 0 {
+		t.Errorf("expected eu-1 not to be tried, got %d calls", calls)
+	}
+}
+
+func TestAllocateReturnsUnAllocatedWhenAllClustersAreExhausted(t *testing.T) {
+	services := map[string]*fakeAllocationService{
+		"eu-1": {allocate: exhausted},
+		"eu-2": {allocate: exhausted},
+	}
+	a := newTestAllocator(t, services, "eu-1", "eu-2")
+
+	result, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby"}, testAllocation())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.Status.State != allocatorv1.GameServerAllocationUnAllocated {
+		t.Errorf("expected state %s, got %s", allocatorv1.GameServerAllocationUnAllocated, result.Status.State)
+	}
+	for name, service := range services {
+		if calls := service.calls.Load(); calls != 1 {
+			t.Errorf("expected %s to be tried once, got %d", name, calls)
+		}
+	}
+}
+
+func TestAllocateReturnsErrorWhenNoClusterIsExhausted(t *testing.T) {
+	services := map[string]*fakeAllocationService{
+		"eu-1": {allocate: func(*pb.AllocationRequest) (*pb.AllocationResponse, error) {
+			return nil, status.Error(codes.Internal, "allocator failed")
+		}},
+	}
+	a := newTestAllocator(t, services, "eu-1")
+
+	_, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby"}, testAllocation())
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected the cluster's Internal error, got %v", err)
+	}
+}
+
+func TestAllocateResolvesForwardedAllocationsToTheirCluster(t *testing.T) {
+	eu2 := Cluster{Name: "eu-2", Endpoint: startAllocationService(t, &fakeAllocationService{allocate: exhausted}), Namespace: testNamespace}
+	// eu-1's multi-cluster policy forwards the allocation to eu-2, which Agones reports as eu-2's endpoint
+	eu1 := Cluster{Name: "eu-1", Endpoint: startAllocationService(t, &fakeAllocationService{allocate: allocated("lobby-abcde", eu2.Endpoint)}), Namespace: testNamespace}
+	a, err := NewServiceAllocator([]Cluster{eu1, eu2})
+	if err != nil {
+		t.Fatalf("failed to create allocator: %v", err)
+	}
+
+	result, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby", Clusters: []string{"eu-1"}}, testAllocation())
+	if err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if result.Status.Source != "eu-2" {
+		t.Errorf("expected the allocation to be resolved to eu-2, got %s", result.Status.Source)
+	}
+}
+
+func TestAllocateFailsWhenForwardedToAnUnknownCluster(t *testing.T) {
+	services := map[string]*fakeAllocationService{
+		"eu-1": {allocate: allocated("lobby-abcde", "agones-allocator.unknown.example.com:443")},
+	}
+	a := newTestAllocator(t, services, "eu-1")
+
+	if _, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby"}, testAllocation()); err == nil {
+		t.Error("expected an allocation forwarded to a cluster that isn't in the clusters file to fail")
+	}
+}
+
+func TestAllocateSendsTheClusterNamespaceAndSelectors(t *testing.T) {
+	var received *pb.AllocationRequest
+	services := map[string]*fakeAllocationService{
+		"eu-1": {allocate: func(req *pb.AllocationRequest) (*pb.AllocationResponse, error) {
+			received = req
+			return allocated("lobby-abcde", "local")(req)
+		}},
+	}
+	a := newTestAllocator(t, services, "eu-1")
+
+	if _, err := a.Allocate(context.Background(), modeprofile.ModeProfile{Name: "lobby"}, testAllocation()); err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if received.GetNamespace() != testNamespace {
+		t.Errorf("expected namespace %s, got %s", testNamespace, received.GetNamespace())
+	}
+	if selectors := received.GetGameServerSelectors(); len(selectors) != 1 || selectors[0].GetMatchLabels()["agones.dev/fleet"] != "lobby" {
+		t.Errorf("expected the lobby fleet selector, got %v", selectors)
+	}
+	if received.GetMetadata().GetAnnotations()["openmatch.dev/match-id"] != "match" {
+		t.Errorf("expected the match annotation, got %v", received.GetMetadata().GetAnnotations())
+	}
+}
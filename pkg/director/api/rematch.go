@@ -0,0 +1,141 @@
+package api
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/modeprofile/config"
+	"matchmaker/pkg/common/utils"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"net/http"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+type RematchRequest struct {
+	// GameServerName is the GameServer that ran the match, the mode profile of its fleet is used for the rematch.
+	// MatchId isn't checked against its annotations, a high density GameServer runs several matches.
+	GameServerName string   `json:"gameServerName"`
+	MatchId        string   `json:"matchId"`
+	PlayerIds      []string `json:"playerIds"`
+	// ClientVersions maps a player ID to the protocol version of their client.
+	// Players without a version are given one supported by the fleet of the finished match.
+	ClientVersions map[string]int `json:"clientVersions"`
+}
+
+type RematchResponse struct {
+	RematchId string   `json:"rematchId"`
+	TicketIds []string `json:"ticketIds"`
+}
+
+// handleRematch creates a ticket for each player that opted in to a rematch.
+// The tickets share a rematch ID so the MMF puts them into a new match together. If any ticket can't be created,
+// those that were are deleted so the rest of the players aren't matched without them, and the failed players are returned.
+func (s *Server) handleRematch(w http.ResponseWriter, r *http.Request) {
+	var req RematchRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.GameServerName == "" || req.MatchId == "" || len(req.PlayerIds) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("gameServerName, matchId and playerIds are required"))
+		return
+	}
+
+	profile, route, err := s.getModeProfileForGameServer(r.Context(), req.GameServerName)
+	if k8serrors.IsNotFound(err) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rematchId := uuid.New().String()
+	var ticketIds []string
+	var failedPlayerIds []string
+	for _, playerId := range req.PlayerIds {
+		ticket, err := utils.NewPlayerTicket(profile.PoolName, playerId, map[string]protov2.Message{
+			"rematchId": wrapperspb.String(rematchId),
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		version, ok := req.ClientVersions[playerId]
+		if !ok {
+			version = route.MinVersion
+		}
+		utils.SetTicketClientVersion(ticket, version)
+
+		var resp *pb.Ticket
+		err = deadline.OpenMatch.Call(r.Context(), "CreateTicket", func(ctx context.Context) error {
+			var err error
+			resp, err = s.fe.CreateTicket(ctx, &pb.CreateTicketRequest{Ticket: ticket})
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to create rematch ticket", zap.String("playerId", playerId), zap.Error(err))
+			failedPlayerIds = append(failedPlayerIds, playerId)
+			continue
+		}
+		ticketIds = append(ticketIds, resp.Id)
+	}
+
+	if len(failedPlayerIds) > 0 {
+		s.deleteTickets(r.Context(), ticketIds)
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to create rematch tickets of players %v, the rematch was cancelled", failedPlayerIds))
+		return
+	}
+
+	logger.Info("Created rematch",
+		zap.String("matchId", req.MatchId),
+		zap.String("rematchId", rematchId),
+		zap.String("profileName", profile.Name),
+		zap.Strings("ticketIds", ticketIds),
+	)
+
+	writeJSON(w, http.StatusOK, RematchResponse{RematchId: rematchId, TicketIds: ticketIds})
+}
+
+// deleteTickets rolls back the tickets of a rematch that couldn't be created for every player.
+// Tickets that can't be deleted are left to expire.
+func (s *Server) deleteTickets(ctx context.Context, ticketIds []string) {
+	for _, ticketId := range ticketIds {
+		err := deadline.OpenMatch.Call(ctx, "DeleteTicket", func(ctx context.Context) error {
+			_, err := s.fe.DeleteTicket(ctx, &pb.DeleteTicketRequest{TicketId: ticketId})
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to delete rematch ticket", zap.String("ticketId", ticketId), zap.Error(err))
+		}
+	}
+}
+
+// getModeProfileForGameServer gets the GameServer and returns the ModeProfile and VersionRoute of its fleet.
+func (s *Server) getModeProfileForGameServer(ctx context.Context, gameServerName string) (modeprofile.ModeProfile, modeprofile.VersionRoute, error) {
+	var gs *agonesv1.GameServer
+	err := deadline.Kubernetes.Call(ctx, "GetGameServer", func(ctx context.Context) error {
+		var err error
+		gs, _, err = kubernetes.FindGameServer(ctx, s.namespace, gameServerName)
+		return err
+	})
+	if err != nil {
+		return modeprofile.ModeProfile{}, modeprofile.VersionRoute{}, err
+	}
+	profile, err := config.GetModeProfileByFleetName(gs.ObjectMeta.Labels["agones.dev/fleet"])
+	if err != nil {
+		return modeprofile.ModeProfile{}, modeprofile.VersionRoute{}, err
+	}
+	route, ok := profile.RouteForGameServer(gs.ObjectMeta.Labels)
+	if !ok {
+		return modeprofile.ModeProfile{}, modeprofile.VersionRoute{}, fmt.Errorf("no version route of %s selects gameserver %s", profile.Name, gs.ObjectMeta.Name)
+	}
+	return profile, route, nil
+}
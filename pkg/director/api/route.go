@@ -0,0 +1,327 @@
+package api
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/friends"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/modeprofile/config"
+	"matchmaker/pkg/common/utils"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"net/http"
+	"open-match.dev/open-match/pkg/pb"
+	"sort"
+	"time"
+)
+
+const (
+	// RoutePriority is the priority of routed tickets so they are matched before players queueing normally.
+	RoutePriority = 10
+)
+
+type RouteRequest struct {
+	// GameServerName is the GameServer sending the players
+	GameServerName string   `json:"gameServerName"`
+	Mode           string   `json:"mode"`
+	PlayerIds      []string `json:"playerIds"`
+	// ClientVersions maps a player ID to the protocol version of their client, see modeprofile.VersionRoute
+	ClientVersions map[string]int `json:"clientVersions"`
+}
+
+type RouteResponse struct {
+	// TicketIds maps a player ID to their ticket ID
+	TicketIds map[string]string `json:"ticketIds"`
+	// FailedPlayerIds are the players whose tickets couldn't be created, they can be sent again
+	FailedPlayerIds []string `json:"failedPlayerIds,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// routesKey is the key of the routes in the routes ConfigMap, a JSON map[playerId]route
+const routesKey = "routes"
+
+// route is a routing of a player to a mode, stored by player in the routes ConfigMap so every director replica sees it
+// whichever GameServer sent the player. It is ignored once expired, and removed when the routes are next saved.
+type route struct {
+	TicketId  string    `json:"ticketId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// handleRoute creates tickets at an elevated priority to send players to a mode, e.g. back to the lobby
+// after a minigame has finished. A player that is already being routed, by any GameServer, whose ticket is still
+// waiting for a match, isn't routed again until the route expires.
+// If no player could be routed the response is a 502, listing the players that failed.
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	var req RouteRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	profile, ok := config.ModeProfiles[req.Mode]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("mode %s not found", req.Mode))
+		return
+	}
+	if req.GameServerName == "" || len(req.PlayerIds) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("gameServerName and playerIds are required"))
+		return
+	}
+
+	// tickets without a version would never be matched into a pool of a versioned mode
+	if len(profile.VersionRoutes) > 0 {
+		for _, playerId := range req.PlayerIds {
+			if _, ok := req.ClientVersions[playerId]; !ok {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("mode %s requires the client version of player %s", req.Mode, playerId))
+				return
+			}
+		}
+	}
+
+	routes, err := s.getRoutes(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ticketIds := make(map[string]string)
+	requested := make(map[string]bool)
+	var playerIds []string
+	for _, playerId := range req.PlayerIds {
+		if requested[playerId] {
+			continue
+		}
+		requested[playerId] = true
+		if existing, ok := routes[playerId]; ok && s.isRouteActive(r.Context(), playerId, existing) {
+			ticketIds[playerId] = existing.TicketId
+			continue
+		}
+		playerIds = append(playerIds, playerId)
+	}
+
+	fields := map[string]protov2.Message{
+		"priority": wrapperspb.Int32(RoutePriority),
+	}
+	if preferred, ok := s.getPreferredGameServer(r.Context(), profile, playerIds); ok {
+		fields["preferredGameServer"] = wrapperspb.String(preferred)
+	}
+
+	created := make(map[string]route)
+	var failedPlayerIds []string
+	for _, playerId := range playerIds {
+		ticket, err := utils.NewPlayerTicket(profile.PoolName, playerId, fields)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if version, ok := req.ClientVersions[playerId]; ok {
+			utils.SetTicketClientVersion(ticket, version)
+		}
+
+		var resp *pb.Ticket
+		err = deadline.OpenMatch.Call(r.Context(), "CreateTicket", func(ctx context.Context) error {
+			var err error
+			resp, err = s.fe.CreateTicket(ctx, &pb.CreateTicketRequest{Ticket: ticket})
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to create route ticket", zap.String("playerId", playerId), zap.Error(err))
+			failedPlayerIds = append(failedPlayerIds, playerId)
+			continue
+		}
+
+		created[playerId] = route{TicketId: resp.Id, ExpiresAt: time.Now().Add(s.routeTTL)}
+		ticketIds[playerId] = resp.Id
+	}
+
+	if len(created) > 0 {
+		// the players are routed either way, they could only be routed again if they are sent again
+		duplicates, err := s.saveRoutes(r.Context(), routes, created)
+		if err != nil {
+			logger.Error("Failed to save routes", zap.String("gameServer", req.GameServerName), zap.Error(err))
+		}
+		for playerId, existing := range duplicates {
+			s.deleteTickets(r.Context(), []string{ticketIds[playerId]})
+			ticketIds[playerId] = existing.TicketId
+		}
+	}
+
+	resp := RouteResponse{TicketIds: ticketIds, FailedPlayerIds: failedPlayerIds}
+	if len(ticketIds) == 0 {
+		resp.Error = fmt.Sprintf("failed to create route tickets of players %v", failedPlayerIds)
+		writeJSON(w, http.StatusBadGateway, resp)
+		return
+	}
+
+	logger.Info("Routed players",
+		zap.String("profileName", profile.Name),
+		zap.String("gameServer", req.GameServerName),
+		zap.Any("ticketIds", ticketIds),
+		zap.Strings("failedPlayerIds", failedPlayerIds),
+	)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// isRouteActive reports whether the route hasn't expired and its ticket is still waiting for a match.
+// A route whose ticket can't be read is treated as active, so the player isn't routed twice.
+func (s *Server) isRouteActive(ctx context.Context, playerId string, existing route) bool {
+	if !time.Now().Before(existing.ExpiresAt) {
+		return false
+	}
+
+	var ticket *pb.Ticket
+	err := deadline.OpenMatch.Call(ctx, "GetTicket", func(ctx context.Context) error {
+		var err error
+		ticket, err = s.fe.GetTicket(ctx, &pb.GetTicketRequest{TicketId: existing.TicketId})
+		return err
+	})
+	if err != nil && status.Code(err) != codes.NotFound {
+		logger.Error("Failed to get route ticket", zap.String("playerId", playerId), zap.Error(err))
+		return true
+	}
+	// the ticket has been assigned or deleted, so the routing is finished
+	return err == nil && ticket.GetAssignment() == nil
+}
+
+// getRoutes returns the routes of every player, map[playerId]route.
+func (s *Server) getRoutes(ctx context.Context) (map[string]route, error) {
+	var configMap *corev1.ConfigMap
+	err := deadline.Kubernetes.Call(ctx, "GetConfigMap", func(ctx context.Context) error {
+		var err error
+		configMap, err = kubernetes.KubeClient.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.routesConfigMap, v1.GetOptions{})
+		return err
+	})
+	if k8serrors.IsNotFound(err) {
+		return make(map[string]route), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoutes(configMap.Data)
+}
+
+// saveRoutes adds the created routes to the routes ConfigMap, creating it if it doesn't exist and dropping the routes
+// that have expired. It is updated rather than patched so concurrent requests, which may be served by other replicas,
+// don't lose each other's routes. A player routed by another request since seen was read keeps that route, which is
+// returned as a duplicate so its ticket is used instead of the one created.
+func (s *Server) saveRoutes(ctx context.Context, seen map[string]route, created map[string]route) (map[string]route, error) {
+	var duplicates map[string]route
+	configMaps := kubernetes.KubeClient.CoreV1().ConfigMaps(s.namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return deadline.Kubernetes.Call(ctx, "UpdateConfigMap", func(ctx context.Context) error {
+			duplicates = make(map[string]route)
+			configMap, err := configMaps.Get(ctx, s.routesConfigMap, v1.GetOptions{})
+			notFound := k8serrors.IsNotFound(err)
+			if err != nil && !notFound {
+				return err
+			}
+			if notFound {
+				configMap = &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: s.routesConfigMap, Namespace: s.namespace}}
+			}
+
+			routes, err := decodeRoutes(configMap.Data)
+			if err != nil {
+				// routes that can't be read would never expire, so they are replaced
+				logger.Error("Replacing unreadable routes", zap.String("configMap", s.routesConfigMap), zap.Error(err))
+				routes = make(map[string]route)
+			}
+
+			now := time.Now()
+			for playerId, existing := range routes {
+				if !now.Before(existing.ExpiresAt) {
+					delete(routes, playerId)
+				}
+			}
+			for playerId, r := range created {
+				if existing, ok := routes[playerId]; ok && existing.TicketId != seen[playerId].TicketId {
+					duplicates[playerId] = existing
+					continue
+				}
+				routes[playerId] = r
+			}
+
+			value, err := json.Marshal(routes)
+			if err != nil {
+				return err
+			}
+			configMap.Data = map[string]string{routesKey: string(value)}
+
+			if notFound {
+				_, err = configMaps.Create(ctx, configMap, v1.CreateOptions{})
+				if k8serrors.IsAlreadyExists(err) {
+					// another replica created it first, retry as a conflict to update theirs
+					return k8serrors.NewConflict(corev1.Resource("configmaps"), s.routesConfigMap, err)
+				}
+				return err
+			}
+			_, err = configMaps.Update(ctx, configMap, v1.UpdateOptions{})
+			return err
+		})
+	})
+	return duplicates, err
+}
+
+func decodeRoutes(data map[string]string) (map[string]route, error) {
+	routes := make(map[string]route)
+	v, ok := data[routesKey]
+	if !ok {
+		return routes, nil
+	}
+	if err := json.Unmarshal([]byte(v), &routes); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", routesKey, err)
+	}
+	return routes, nil
+}
+
+// getPreferredGameServer finds the GameServer of one of the mode's fleets that hosts the most of the players' friends.
+// Only modes with a CommonPlayerBasedDefinition-style allocation can put players into an existing GameServer.
+func (s *Server) getPreferredGameServer(ctx context.Context, profile modeprofile.ModeProfile, playerIds []string) (string, bool) {
+	if !profile.PlayerBased || len(playerIds) == 0 {
+		return "", false
+	}
+
+	var counts map[string]int
+	err := deadline.PlayerTracker.Call(ctx, "GetFriendServerCounts", func(ctx context.Context) error {
+		var err error
+		counts, err = friends.GetFriendServerCounts(ctx, playerIds)
+		return err
+	})
+	if err != nil {
+		logger.Info("Could not get friend servers", zap.Error(err))
+		return "", false
+	}
+
+	serverIds := make([]string, 0, len(counts))
+	for serverId := range counts {
+		serverIds = append(serverIds, serverId)
+	}
+	sort.Slice(serverIds, func(i, j int) bool {
+		return counts[serverIds[i]] > counts[serverIds[j]]
+	})
+
+	for _, serverId := range serverIds {
+		var gs *agonesv1.GameServer
+		err := deadline.Kubernetes.Call(ctx, "GetGameServer", func(ctx context.Context) error {
+			var err error
+			gs, _, err = kubernetes.FindGameServer(ctx, s.namespace, serverId)
+			return err
+		})
+		if err != nil {
+			continue
+		}
+		if _, ok := profile.RouteForGameServer(gs.ObjectMeta.Labels); ok {
+			return gs.ObjectMeta.Name, true
+		}
+	}
+	return "", false
+}
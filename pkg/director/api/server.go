@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"matchmaker/pkg/common/health"
+	"matchmaker/pkg/common/metrics"
+	"net/http"
+	"open-match.dev/open-match/pkg/pb"
+	"strings"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Server is the HTTP API used by GameServers to request work from the director. Requests must have an
+// `Authorization: Bearer <token>` header with the token shared with the GameServers, the GameServer endpoints are
+// disabled without one. It also serves the director's metrics and health probes.
+type Server struct {
+	namespace string
+	fe        pb.FrontendServiceClient
+	mux       *http.ServeMux
+	// routeTTL is how long a routed player isn't routed again, see handleRoute
+	routeTTL time.Duration
+	// routesConfigMap is the ConfigMap the routes of every player are shared in by the replicas
+	routesConfigMap string
+}
+
+func NewServer(namespace string, fe pb.FrontendServiceClient, checker *health.Checker, routeTTL time.Duration, routesConfigMap string, token string) *Server {
+	s := &Server{
+		namespace:       namespace,
+		fe:              fe,
+		mux:             http.NewServeMux(),
+		routeTTL:        routeTTL,
+		routesConfigMap: routesConfigMap,
+	}
+
+	if token == "" {
+		logger.Warn("No GameServer token, the rematch and route endpoints are disabled")
+	} else {
+		s.mux.Handle("/v1/rematch", authorize(token, "Rematch", http.HandlerFunc(s.handleRematch)))
+		s.mux.Handle("/v1/route", authorize(token, "Route", http.HandlerFunc(s.handleRoute)))
+	}
+	s.mux.Handle("/metrics", metrics.Handler())
+	s.mux.Handle("/healthz", checker.LivenessHandler())
+	s.mux.Handle("/readyz", checker.ReadinessHandler())
+
+	return s
+}
+
+// Start hosts the API on the given port. This blocks until the server fails.
+func (s *Server) Start(port int) {
+	logger.Info("Starting director API", zap.Int("port", port))
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), s.mux); err != nil {
+		logger.Error("Director API failed", zap.Error(err))
+	}
+}
+
+// authorize only passes on requests bearing the GameServer token.
+func authorize(token string, action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) != 1 {
+
+			logger.Warn("Denied director API request", zap.String("action", action), zap.String("remoteAddr", r.RemoteAddr))
+			writeError(w, http.StatusUnauthorized, errors.New("a valid bearer token is required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Failed to write response", zap.Error(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
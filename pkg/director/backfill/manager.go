@@ -0,0 +1,339 @@
+package backfill
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/mmf"
+	"matchmaker/pkg/common/modeprofile/config"
+	"matchmaker/pkg/common/notifier"
+	"matchmaker/pkg/common/utils"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"open-match.dev/open-match/pkg/pb"
+	"time"
+)
+
+const (
+	// Backfills must be acknowledged regularly or Open Match will expire them.
+	acknowledgeInterval = 5 * time.Second
+)
+
+var (
+	logger, _ = zap.NewProduction()
+
+	// errNoPool is returned when the GameServer isn't selected by a pool of its mode, so it can't be backfilled
+	errNoPool = errors.New("gameserver has no pool to backfill from")
+)
+
+// Manager creates the backfills requested by GameServers that need more players for a running match.
+// It acknowledges them on behalf of the GameServer, so there's no new allocation, and tells the
+// GameServer which players to expect when tickets are assigned to a backfill.
+//
+// A GameServer's open backfills are stored in its annotations.BackfillsKey annotation, map[backfillId]matchId,
+// so any replica can serve a request and the replica holding the director's Lease acknowledges them. GameServers
+// are found in any of the clusters the director allocates in, see kubernetes.Clusters.
+type Manager struct {
+	namespace string
+	fe        pb.FrontendServiceClient
+}
+
+func NewManager(namespace string, fe pb.FrontendServiceClient) *Manager {
+	return &Manager{
+		namespace: namespace,
+		fe:        fe,
+	}
+}
+
+// Request creates a backfill for the match running on the GameServer that the MMF fills from the pool.
+func (m *Manager) Request(ctx context.Context, gameServerName string, matchId string, players int32) (string, error) {
+	gs, cluster, err := m.findGameServer(ctx, gameServerName)
+	if err != nil {
+		return "", err
+	}
+	if len(gs.Status.Ports) == 0 {
+		return "", fmt.Errorf("%w: gameserver %s has no ports", errNoPool, gameServerName)
+	}
+
+	profile, err := config.GetModeProfileByFleetName(gs.ObjectMeta.Labels["agones.dev/fleet"])
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errNoPool, err)
+	}
+
+	// the backfill is filled from the pool of the GameServer's version route so only compatible clients are added
+	route, ok := profile.RouteForGameServer(gs.ObjectMeta.Labels)
+	if !ok {
+		return "", fmt.Errorf("%w: no version route of %s selects gameserver %s", errNoPool, profile.Name, gameServerName)
+	}
+	pool, ok := profile.Pool(route)
+	if !ok {
+		return "", fmt.Errorf("%w: profile %s has no pool %s", errNoPool, profile.Name, route.PoolName)
+	}
+
+	backfill, err := mmf.NewBackfill(pool, matchId, gameServerName, players)
+	if err != nil {
+		return "", err
+	}
+
+	var created *pb.Backfill
+	err = deadline.OpenMatch.Call(ctx, "CreateBackfill", func(ctx context.Context) error {
+		var err error
+		created, err = m.fe.CreateBackfill(ctx, &pb.CreateBackfillRequest{Backfill: backfill})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// a backfill that isn't tracked would never be acknowledged, so it is deleted rather than left to expire
+	err = m.updateBackfills(ctx, cluster, gameServerName, func(backfills map[string]string) {
+		backfills[created.Id] = matchId
+	})
+	if err != nil {
+		if err := m.deleteBackfill(context.Background(), created.Id); err != nil {
+			logger.Error("Failed to delete untracked backfill", zap.String("backfillId", created.Id), zap.Error(err))
+		}
+		return "", err
+	}
+
+	logger.Info("Created backfill",
+		zap.String("backfillId", created.Id),
+		zap.String("gameServer", gameServerName),
+		zap.String("cluster", cluster.Name),
+		zap.String("matchId", matchId),
+		zap.String("profileName", profile.Name),
+		zap.Int32("players", players),
+	)
+
+	m.acknowledge(ctx, cluster, gs, created.Id, matchId)
+	return created.Id, nil
+}
+
+// Cancel deletes one of the GameServer's backfills, e.g. when the game has started and no more players can join.
+func (m *Manager) Cancel(ctx context.Context, gameServerName string, backfillId string) error {
+	if err := m.deleteBackfill(ctx, backfillId); err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	_, cluster, err := m.findGameServer(ctx, gameServerName)
+	if err != nil {
+		return err
+	}
+	return m.untrack(ctx, cluster, gameServerName, backfillId)
+}
+
+// cancel is Cancel for a GameServer whose cluster is known.
+func (m *Manager) cancel(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, backfillId string) error {
+	if err := m.deleteBackfill(ctx, backfillId); err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return m.untrack(ctx, cluster, gameServerName, backfillId)
+}
+
+// Start acknowledges the backfills of every GameServer on an interval until the context is done, e.g. when the
+// director loses its Lease as only one replica should acknowledge them. This blocks until then.
+func (m *Manager) Start(ctx context.Context) {
+	ticker := time.NewTicker(acknowledgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, cluster := range kubernetes.Clusters(m.namespace) {
+			if err := m.acknowledgeAll(ctx, cluster); err != nil && ctx.Err() == nil {
+				logger.Error("Failed to acknowledge backfills", zap.String("cluster", cluster.Name), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (m *Manager) acknowledgeAll(ctx context.Context, cluster kubernetes.Cluster) error {
+	var gameServers *agonesv1.GameServerList
+	err := deadline.Kubernetes.Call(ctx, "ListGameServers", func(ctx context.Context) error {
+		var err error
+		gameServers, err = cluster.Agones.AgonesV1().GameServers(cluster.Namespace).List(ctx, v1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range gameServers.Items {
+		gs := &gameServers.Items[i]
+		if _, ok := gs.ObjectMeta.Annotations[annotations.BackfillsKey]; !ok {
+			continue
+		}
+		backfills, err := decodeBackfills(gs.ObjectMeta.Annotations)
+		if err != nil {
+			logger.Error("Failed to read backfills", zap.String("gameServer", gs.ObjectMeta.Name), zap.Error(err))
+			continue
+		}
+		for backfillId, matchId := range backfills {
+			m.acknowledge(ctx, cluster, gs, backfillId, matchId)
+		}
+	}
+	return nil
+}
+
+// acknowledge acknowledges the backfill with the GameServer's connection, annotating the GameServer with the
+// players assigned to it. The backfill is deleted once filled, and untracked once Open Match no longer has it.
+func (m *Manager) acknowledge(ctx context.Context, cluster kubernetes.Cluster, gs *agonesv1.GameServer, backfillId string, matchId string) {
+	gameServerName := gs.ObjectMeta.Name
+	if len(gs.Status.Ports) == 0 {
+		logger.Error("Backfilled gameserver has no ports", zap.String("gameServer", gameServerName), zap.String("backfillId", backfillId))
+		return
+	}
+
+	var resp *pb.AcknowledgeBackfillResponse
+	err := deadline.OpenMatch.Call(ctx, "AcknowledgeBackfill", func(ctx context.Context) error {
+		var err error
+		resp, err = m.fe.AcknowledgeBackfill(ctx, &pb.AcknowledgeBackfillRequest{
+			BackfillId: backfillId,
+			Assignment: &pb.Assignment{Connection: fmt.Sprintf("%s:%d", gs.Status.Address, gs.Status.Ports[0].Port)},
+		})
+		return err
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			if err := m.untrack(ctx, cluster, gameServerName, backfillId); err != nil {
+				logger.Error("Failed to untrack expired backfill", zap.String("backfillId", backfillId), zap.Error(err))
+			}
+		}
+		logger.Error("Failed to acknowledge backfill", zap.String("backfillId", backfillId), zap.Error(err))
+		return
+	}
+
+	if len(resp.GetTickets()) > 0 {
+		playerIds := utils.ExtractPlayerIdsFromTickets(resp.GetTickets())
+		if err := m.annotatePlayers(ctx, cluster, gameServerName, backfillId, matchId, playerIds); err != nil {
+			logger.Error("Failed to annotate backfilled players", zap.String("backfillId", backfillId), zap.Error(err))
+		}
+		notifier.NotifyPlayersOfPendingMatch(context.Background(), matchId, playerIds, time.Now())
+	}
+
+	slots, err := mmf.GetBackfillSlots(resp.GetBackfill())
+	if err != nil {
+		logger.Error("Failed to get backfill slots", zap.String("backfillId", backfillId), zap.Error(err))
+		return
+	}
+	if slots <= 0 {
+		logger.Info("Backfill filled", zap.String("backfillId", backfillId), zap.String("matchId", matchId))
+		if err := m.cancel(ctx, cluster, gameServerName, backfillId); err != nil {
+			logger.Error("Failed to delete filled backfill", zap.String("backfillId", backfillId), zap.Error(err))
+		}
+	}
+}
+
+// annotatePlayers tells the GameServer which players to expect in the match.
+// annotations.BackfilledAtKey is updated so the GameServer sees it as a change without an allocation.
+func (m *Manager) annotatePlayers(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, backfillId string, matchId string, playerIds []string) error {
+	patchedAnnotations, err := annotations.Encode(annotations.Match{
+		MatchId:         matchId,
+		ExpectedPlayers: playerIds,
+		BackfillId:      backfillId,
+	})
+	if err != nil {
+		return err
+	}
+	patchedAnnotations[annotations.BackfilledAtKey] = time.Now().Format(time.RFC3339Nano)
+	patchedAnnotations[annotations.AssignedMatchIdKey] = matchId
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": patchedAnnotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return deadline.Kubernetes.Call(ctx, "PatchGameServer", func(ctx context.Context) error {
+		_, err := cluster.Agones.AgonesV1().GameServers(cluster.Namespace).
+			Patch(ctx, gameServerName, types.MergePatchType, patch, v1.PatchOptions{})
+		return err
+	})
+}
+
+func (m *Manager) findGameServer(ctx context.Context, gameServerName string) (*agonesv1.GameServer, kubernetes.Cluster, error) {
+	var gs *agonesv1.GameServer
+	var cluster kubernetes.Cluster
+	err := deadline.Kubernetes.Call(ctx, "GetGameServer", func(ctx context.Context) error {
+		var err error
+		gs, cluster, err = kubernetes.FindGameServer(ctx, m.namespace, gameServerName)
+		return err
+	})
+	return gs, cluster, err
+}
+
+func (m *Manager) deleteBackfill(ctx context.Context, backfillId string) error {
+	return deadline.OpenMatch.Call(ctx, "DeleteBackfill", func(ctx context.Context) error {
+		_, err := m.fe.DeleteBackfill(ctx, &pb.DeleteBackfillRequest{BackfillId: backfillId})
+		return err
+	})
+}
+
+func (m *Manager) untrack(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, backfillId string) error {
+	return m.updateBackfills(ctx, cluster, gameServerName, func(backfills map[string]string) {
+		delete(backfills, backfillId)
+	})
+}
+
+// updateBackfills changes the GameServer's backfills, removing the annotation once it has none. The GameServer is
+// updated rather than patched so concurrent requests, which may be served by other replicas, don't lose each other's backfills.
+func (m *Manager) updateBackfills(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, change func(backfills map[string]string)) error {
+	gameServers := cluster.Agones.AgonesV1().GameServers(cluster.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return deadline.Kubernetes.Call(ctx, "UpdateGameServer", func(ctx context.Context) error {
+			gs, err := gameServers.Get(ctx, gameServerName, v1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			backfills, err := decodeBackfills(gs.ObjectMeta.Annotations)
+			if err != nil {
+				return err
+			}
+			change(backfills)
+
+			if len(backfills) == 0 {
+				if _, ok := gs.ObjectMeta.Annotations[annotations.BackfillsKey]; !ok {
+					return nil
+				}
+				delete(gs.ObjectMeta.Annotations, annotations.BackfillsKey)
+			} else {
+				value, err := json.Marshal(backfills)
+				if err != nil {
+					return err
+				}
+				if gs.ObjectMeta.Annotations == nil {
+					gs.ObjectMeta.Annotations = make(map[string]string)
+				}
+				gs.ObjectMeta.Annotations[annotations.BackfillsKey] = string(value)
+			}
+			_, err = gameServers.Update(ctx, gs, v1.UpdateOptions{})
+			return err
+		})
+	})
+}
+
+// decodeBackfills returns the GameServer's open backfills, map[backfillId]matchId.
+func decodeBackfills(gsAnnotations map[string]string) (map[string]string, error) {
+	backfills := make(map[string]string)
+	v, ok := gsAnnotations[annotations.BackfillsKey]
+	if !ok {
+		return backfills, nil
+	}
+	if err := json.Unmarshal([]byte(v), &backfills); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", annotations.BackfillsKey, err)
+	}
+	return backfills, nil
+}
@@ -0,0 +1,115 @@
+package backfill
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"matchmaker/pkg/director/backfill/backfillpb"
+	"net"
+	"strings"
+)
+
+// Service is the gRPC API GameServers call when a running match needs more players, e.g. "match X on me needs
+// N players". See backfillpb/backfill.proto. Calls must have an `authorization: Bearer <token>` metadata entry
+// with the token shared with the GameServers, the service is disabled without one.
+type Service struct {
+	backfillpb.UnimplementedBackfillServer
+
+	manager *Manager
+	token   string
+}
+
+func NewService(manager *Manager, token string) *Service {
+	return &Service{manager: manager, token: token}
+}
+
+// Start hosts the service on the given port. This blocks until the server fails.
+func (s *Service) Start(port int) {
+	if s.token == "" {
+		logger.Warn("No GameServer token, the backfill service is disabled")
+		return
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		logger.Fatal("Failed to listen for the backfill service", zap.Int("port", port), zap.Error(err))
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(s.authorize))
+	backfillpb.RegisterBackfillServer(server, s)
+	logger.Info("Starting backfill service", zap.Int("port", port))
+	if err := server.Serve(ln); err != nil {
+		logger.Error("Backfill service failed", zap.Error(err))
+	}
+}
+
+// authorize only passes on calls bearing the GameServer token.
+func (s *Service) authorize(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range md.Get("authorization") {
+		if strings.HasPrefix(header, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(s.token)) == 1 {
+
+			return handler(ctx, req)
+		}
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	logger.Warn("Denied backfill call", zap.String("method", info.FullMethod), zap.String("remoteAddr", addr))
+	return nil, status.Error(codes.Unauthenticated, "a valid bearer token is required")
+}
+
+// RequestBackfill creates a backfill for a running match that needs more players.
+// The mode is taken from the GameServer's fleet and no new GameServer is allocated.
+func (s *Service) RequestBackfill(ctx context.Context, req *backfillpb.RequestBackfillRequest) (*backfillpb.RequestBackfillResponse, error) {
+	if req.GameServerName == "" || req.MatchId == "" || req.Players <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "game_server_name, match_id and players are required")
+	}
+
+	backfillId, err := s.manager.Request(ctx, req.GameServerName, req.MatchId, req.Players)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &backfillpb.RequestBackfillResponse{BackfillId: backfillId}, nil
+}
+
+// CancelBackfill stops a backfill, e.g. when the game has started and no more players can join.
+func (s *Service) CancelBackfill(ctx context.Context, req *backfillpb.CancelBackfillRequest) (*emptypb.Empty, error) {
+	if req.GameServerName == "" || req.BackfillId == "" {
+		return nil, status.Error(codes.InvalidArgument, "game_server_name and backfill_id are required")
+	}
+
+	if err := s.manager.Cancel(ctx, req.GameServerName, req.BackfillId); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// toStatus keeps the status of Open Match errors and maps the others to a code the GameServer can act on.
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case k8serrors.IsNotFound(err):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errNoPool):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
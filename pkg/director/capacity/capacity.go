@@ -0,0 +1,110 @@
+// Package capacity estimates how many matches each fleet can take from an informer cache of GameServers,
+// so the director can hold matches it can't place rather than churning allocations that will fail.
+package capacity
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"agones.dev/agones/pkg/client/informers/externalversions"
+	listerv1 "agones.dev/agones/pkg/client/listers/agones/v1"
+	"fmt"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Tracker keeps a cache of the namespace's GameServers, updated by an informer.
+type Tracker struct {
+	namespace string
+	factory   externalversions.SharedInformerFactory
+	lister    listerv1.GameServerLister
+	synced    cache.InformerSynced
+}
+
+func NewTracker(namespace string, resync time.Duration) *Tracker {
+	factory := externalversions.NewSharedInformerFactoryWithOptions(kubernetes.AgonesClient, resync, externalversions.WithNamespace(namespace))
+	gameServers := factory.Agones().V1().GameServers()
+
+	return &Tracker{
+		namespace: namespace,
+		factory:   factory,
+		lister:    gameServers.Lister(),
+		synced:    gameServers.Informer().HasSynced,
+	}
+}
+
+// Start runs the informer and waits for the cache to be filled.
+func (t *Tracker) Start(stop <-chan struct{}) error {
+	t.factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, t.synced) {
+		return fmt.Errorf("failed to sync gameserver cache")
+	}
+	logger.Info("GameServer cache synced", zap.String("namespace", t.namespace))
+	return nil
+}
+
+// Capacity returns the current capacity of the fleet.
+func (t *Tracker) Capacity(fleetName string) (*Capacity, error) {
+	gameServers, err := t.lister.GameServers(t.namespace).List(labels.SelectorFromSet(labels.Set{"agones.dev/fleet": fleetName}))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Capacity{}
+	for _, gs := range gameServers {
+		switch gs.Status.State {
+		case agonesv1.GameServerStateReady:
+			c.Ready++
+		case agonesv1.GameServerStateAllocated:
+			// high density GameServers report their free game slots with a Counter, whether or not they are labelled
+			// for reallocation yet. Without one, a GameServer labelled for reallocation can run at least one more game
+			if games, ok := gs.Status.Counters["games"]; ok {
+				if games.Capacity > games.Count {
+					c.GameSlots += int(games.Capacity - games.Count)
+				}
+			} else if gs.ObjectMeta.Labels["agones.dev/sdk-should-allocate"] == "true" {
+				c.GameSlots++
+			}
+			if players := gs.Status.Players; players != nil && players.Capacity > players.Count {
+				c.PlayerSlots = append(c.PlayerSlots, players.Capacity-players.Count)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Capacity is an estimate of the matches a fleet can take. Matches placed in a run are taken from it
+// so later matches of the same run see the remaining capacity.
+type Capacity struct {
+	Ready int
+	// GameSlots are the games allocated high density GameServers can still run
+	GameSlots int
+	// PlayerSlots are the free player slots of each allocated GameServer, used by player based modes
+	PlayerSlots []int64
+}
+
+// Take reserves capacity for a match of the given size, returning false if the fleet has none left.
+func (c *Capacity) Take(players int, playerBased bool) bool {
+	if playerBased {
+		for i, free := range c.PlayerSlots {
+			if free >= int64(players) {
+				c.PlayerSlots[i] -= int64(players)
+				return true
+			}
+		}
+	}
+	if c.GameSlots > 0 {
+		c.GameSlots--
+		return true
+	}
+	if c.Ready > 0 {
+		c.Ready--
+		return true
+	}
+	return false
+}
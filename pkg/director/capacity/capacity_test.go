@@ -0,0 +1,165 @@
+package capacity
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"agones.dev/agones/pkg/client/clientset/versioned/fake"
+	"context"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testNamespace = "towerdefence"
+
+func TestTake(t *testing.T) {
+	type take struct {
+		players     int
+		playerBased bool
+		expected    bool
+	}
+
+	tests := []struct {
+		name     string
+		capacity Capacity
+		takes    []take
+		// remaining is the capacity left after the takes
+		remaining Capacity
+	}{
+		{
+			name:      "no capacity",
+			takes:     []take{{players: 2, expected: false}},
+			remaining: Capacity{},
+		},
+		{
+			name:      "game slots are taken before ready GameServers",
+			capacity:  Capacity{Ready: 1, GameSlots: 1},
+			takes:     []take{{players: 2, expected: true}, {players: 2, expected: true}, {players: 2, expected: false}},
+			remaining: Capacity{},
+		},
+		{
+			name:      "player slots are taken first by player based modes",
+			capacity:  Capacity{Ready: 1, GameSlots: 1, PlayerSlots: []int64{3}},
+			takes:     []take{{players: 2, playerBased: true, expected: true}},
+			remaining: Capacity{Ready: 1, GameSlots: 1, PlayerSlots: []int64{1}},
+		},
+		{
+			name:      "player slots are ignored by other modes",
+			capacity:  Capacity{Ready: 1, PlayerSlots: []int64{3}},
+			takes:     []take{{players: 2, expected: true}},
+			remaining: Capacity{PlayerSlots: []int64{3}},
+		},
+		{
+			name:     "match too big for the player slots falls back to a ready GameServer",
+			capacity: Capacity{Ready: 1, PlayerSlots: []int64{1, 2}},
+			takes: []take{
+				{players: 3, playerBased: true, expected: true},
+				{players: 2, playerBased: true, expected: true},
+				{players: 3, playerBased: true, expected: false},
+			},
+			remaining: Capacity{PlayerSlots: []int64{1, 0}},
+		},
+		{
+			name:     "later matches see the remaining player slots",
+			capacity: Capacity{PlayerSlots: []int64{4}},
+			takes: []take{
+				{players: 3, playerBased: true, expected: true},
+				{players: 2, playerBased: true, expected: false},
+				{players: 1, playerBased: true, expected: true},
+			},
+			remaining: Capacity{PlayerSlots: []int64{0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := test.capacity
+			c.PlayerSlots = append([]int64(nil), test.capacity.PlayerSlots...)
+			for i, take := range test.takes {
+				if actual := c.Take(take.players, take.playerBased); actual != take.expected {
+					t.Errorf("take %d: expected %t, got %t", i, take.expected, actual)
+				}
+			}
+			if !reflect.DeepEqual(c, test.remaining) {
+				t.Errorf("expected %+v remaining, got %+v", test.remaining, c)
+			}
+		})
+	}
+}
+
+func gameServer(name string, fleet string, state agonesv1.GameServerState, labels map[string]string) *agonesv1.GameServer {
+	gs := &agonesv1.GameServer{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    map[string]string{"agones.dev/fleet": fleet},
+		},
+		Status: agonesv1.GameServerStatus{State: state},
+	}
+	for k, v := range labels {
+		gs.ObjectMeta.Labels[k] = v
+	}
+	return gs
+}
+
+func TestTrackerCapacity(t *testing.T) {
+	shouldAllocate := map[string]string{"agones.dev/sdk-should-allocate": "true"}
+
+	withGames := gameServer("lobby-games", "lobby", agonesv1.GameServerStateAllocated, nil)
+	withGames.Status.Counters = map[string]agonesv1.CounterStatus{"games": {Count: 1, Capacity: 4}}
+	fullGames := gameServer("lobby-full", "lobby", agonesv1.GameServerStateAllocated, shouldAllocate)
+	fullGames.Status.Counters = map[string]agonesv1.CounterStatus{"games": {Count: 4, Capacity: 4}}
+	withPlayers := gameServer("lobby-players", "lobby", agonesv1.GameServerStateAllocated, nil)
+	withPlayers.Status.Players = &agonesv1.PlayerStatus{Count: 6, Capacity: 10}
+
+	gameServers := []*agonesv1.GameServer{
+		gameServer("lobby-ready-1", "lobby", agonesv1.GameServerStateReady, nil),
+		gameServer("lobby-ready-2", "lobby", agonesv1.GameServerStateReady, nil),
+		gameServer("lobby-scheduled", "lobby", agonesv1.GameServerStateScheduled, nil),
+		gameServer("lobby-reallocatable", "lobby", agonesv1.GameServerStateAllocated, shouldAllocate),
+		gameServer("lobby-allocated", "lobby", agonesv1.GameServerStateAllocated, nil),
+		withGames,
+		fullGames,
+		withPlayers,
+		gameServer("block-sumo-ready", "block-sumo", agonesv1.GameServerStateReady, nil),
+	}
+
+	agonesClient := kubernetes.AgonesClient
+	t.Cleanup(func() { kubernetes.AgonesClient = agonesClient })
+	agones := fake.NewSimpleClientset()
+	for _, gs := range gameServers {
+		if _, err := agones.AgonesV1().GameServers(testNamespace).Create(context.Background(), gs, v1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create gameserver: %v", err)
+		}
+	}
+	kubernetes.AgonesClient = agones
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	tracker := NewTracker(testNamespace, time.Minute)
+	if err := tracker.Start(stop); err != nil {
+		t.Fatalf("failed to start the tracker: %v", err)
+	}
+
+	tests := []struct {
+		fleet    string
+		expected Capacity
+	}{
+		{fleet: "lobby", expected: Capacity{Ready: 2, GameSlots: 4, PlayerSlots: []int64{4}}},
+		{fleet: "block-sumo", expected: Capacity{Ready: 1}},
+		{fleet: "minesweeper", expected: Capacity{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fleet, func(t *testing.T) {
+			c, err := tracker.Capacity(test.fleet)
+			if err != nil {
+				t.Fatalf("failed to get capacity: %v", err)
+			}
+			if !reflect.DeepEqual(*c, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, *c)
+			}
+		})
+	}
+}
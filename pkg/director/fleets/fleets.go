@@ -0,0 +1,74 @@
+// Package fleets picks the fleet of each new match from its mode's weighted fleets and tracks how each fleet performs,
+// so a bad canary fleet can be spotted and rolled back by changing its weight at runtime through the admin API.
+package fleets
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"matchmaker/pkg/common/metrics"
+	"matchmaker/pkg/common/modeprofile"
+	"matchmaker/pkg/common/utils"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// Outcome is what happened to a match sent to a fleet
+type Outcome string
+
+const (
+	Allocated        Outcome = "allocated"
+	AllocationFailed Outcome = "allocation_failed"
+	Assigned         Outcome = "assigned"
+	AssignmentFailed Outcome = "assignment_failed"
+	// Orphaned matches were allocated but their tickets were never assigned, see orphan.Reconciler
+	Orphaned Outcome = "orphaned"
+)
+
+var (
+	outcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "fleet_outcomes_total",
+		Help:      "What happened to the matches sent to each fleet",
+	}, []string{metrics.ProfileLabel, metrics.FleetLabel, "outcome"})
+)
+
+// Assign picks the fleet of the match, with the runtime weight overrides of the profile's fleets, and stores it in the
+// match's extensions so the allocation and any retries of it use the same fleet. A match that already has a fleet keeps it.
+func Assign(profile modeprofile.ModeProfile, match *pb.Match, overrides map[string]int) (string, error) {
+	if fleet, ok := utils.GetMatchStringExtension(match, modeprofile.FleetExtension); ok {
+		return fleet, nil
+	}
+
+	fleet, err := modeprofile.PickFleet(profile.RouteForMatch(match).Fleets, match.GetMatchId(), overrides)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.SetMatchStringExtension(match, modeprofile.FleetExtension, fleet); err != nil {
+		return "", err
+	}
+	return fleet, nil
+}
+
+// ValidateWeights checks the weight overrides of the profile's fleets, which replace the configured weight of each
+// fleet present, still let every route send matches somewhere.
+func ValidateWeights(profile modeprofile.ModeProfile, overrides map[string]int) error {
+	for fleetName, weight := range overrides {
+		if !profile.HasFleet(fleetName) {
+			return fmt.Errorf("profile %s has no fleet %s", profile.Name, fleetName)
+		}
+		if weight < 0 {
+			return fmt.Errorf("fleet %s: weight must not be negative", fleetName)
+		}
+	}
+	for _, route := range profile.Routes() {
+		if _, err := modeprofile.PickFleet(route.Fleets, "", overrides); err != nil {
+			return fmt.Errorf("route %s: %w", route.PoolName, err)
+		}
+	}
+	return nil
+}
+
+// RecordOutcome counts the outcome of a match sent to the fleet.
+func RecordOutcome(profileName string, fleetName string, outcome Outcome) {
+	outcomes.WithLabelValues(profileName, fleetName, string(outcome)).Inc()
+}
@@ -0,0 +1,110 @@
+// Package leader elects the director replica that runs each mode profile using Kubernetes Leases,
+// so standby replicas can take over without two replicas fetching and allocating the same profile.
+package leader
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"matchmaker/pkg/common/metrics"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+
+	leading = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "leader_lease_held",
+		Help:      "1 for the Leases this replica holds and 0 for those it is waiting on",
+	}, []string{"lease"})
+)
+
+// Elector campaigns for Leases in a namespace on behalf of this replica.
+type Elector struct {
+	client    kubernetes.Interface
+	namespace string
+	identity  string
+
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// NewElector creates an Elector. A standby takes over a lease within leaseDuration of the leader dying,
+// or straight away if the leader shuts down cleanly as leases are released when the context is done.
+func NewElector(client kubernetes.Interface, namespace string, identity string, leaseDuration time.Duration,
+	renewDeadline time.Duration, retryPeriod time.Duration) *Elector {
+
+	return &Elector{
+		client:        client,
+		namespace:     namespace,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+	}
+}
+
+// Run campaigns for the lease until the context is done. While the lease is held lead is called with a context
+// that is cancelled as soon as the lease is lost. Run waits for lead to return before campaigning again,
+// so work started under the lease is never running twice on this replica.
+func (e *Elector) Run(ctx context.Context, leaseName string, lead func(ctx context.Context)) error {
+	leading.WithLabelValues(leaseName).Set(0)
+	for ctx.Err() == nil {
+		done := make(chan struct{})
+		var started atomic.Bool
+
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta: metav1.ObjectMeta{
+					Name:      leaseName,
+					Namespace: e.namespace,
+				},
+				Client: e.client.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{
+					Identity: e.identity,
+				},
+			},
+			LeaseDuration:   e.leaseDuration,
+			RenewDeadline:   e.renewDeadline,
+			RetryPeriod:     e.retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					started.Store(true)
+					defer close(done)
+					logger.Info("Acquired lease", zap.String("lease", leaseName), zap.String("identity", e.identity))
+					leading.WithLabelValues(leaseName).Set(1)
+					defer leading.WithLabelValues(leaseName).Set(0)
+					lead(ctx)
+				},
+				OnStoppedLeading: func() {
+					logger.Info("Stopped leading", zap.String("lease", leaseName), zap.String("identity", e.identity))
+				},
+				OnNewLeader: func(identity string) {
+					if identity != e.identity {
+						logger.Info("Lease is held by another replica", zap.String("lease", leaseName), zap.String("leader", identity))
+					}
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		// the elector only returns while ctx is live once a held lease is lost, and lead runs on its own goroutine
+		elector.Run(ctx)
+		if ctx.Err() == nil || started.Load() {
+			<-done
+		}
+	}
+	return ctx.Err()
+}
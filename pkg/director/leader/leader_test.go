@@ -0,0 +1,229 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testNamespace = "towerdefence"
+	testLease     = "director"
+
+	leaseDuration = 1500 * time.Millisecond
+	renewDeadline = 1000 * time.Millisecond
+	retryPeriod   = 200 * time.Millisecond
+)
+
+func newTestElector(client *fake.Clientset, identity string) *Elector {
+	return NewElector(client, testNamespace, identity, leaseDuration, renewDeadline, retryPeriod)
+}
+
+// waitFor fails the test if the channel isn't closed within the timeout.
+func waitFor(t *testing.T, ch <-chan struct{}, timeout time.Duration, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func holder(t *testing.T, client *fake.Clientset) string {
+	t.Helper()
+	lease, err := client.CoordinationV1().Leases(testNamespace).Get(context.Background(), testLease, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestRunLeadsUntilContextIsDone(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	returned := make(chan error, 1)
+	go func() {
+		returned <- newTestElector(client, "director-a").Run(ctx, testLease, func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			close(stopped)
+		})
+	}()
+
+	waitFor(t, started, 5*time.Second, "the lease to be acquired")
+	if got := holder(t, client); got != "director-a" {
+		t.Fatalf("lease holder = %q, want director-a", got)
+	}
+
+	cancel()
+	waitFor(t, stopped, 5*time.Second, "lead to be cancelled")
+	select {
+	case err := <-returned:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+}
+
+func TestStandbyTakesOverReleasedLease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	var leaders atomic.Int32
+	var overlapped atomic.Bool
+	lead := func(started chan struct{}) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			if leaders.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			defer leaders.Add(-1)
+			close(started)
+			<-ctx.Done()
+		}
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	startedA := make(chan struct{})
+	doneA := make(chan struct{})
+	go func() {
+		defer close(doneA)
+		_ = newTestElector(client, "director-a").Run(ctxA, testLease, lead(startedA))
+	}()
+	waitFor(t, startedA, 5*time.Second, "director-a to lead")
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	startedB := make(chan struct{})
+	go func() {
+		_ = newTestElector(client, "director-b").Run(ctxB, testLease, lead(startedB))
+	}()
+
+	// the standby must not lead while the leader renews its lease
+	select {
+	case <-startedB:
+		t.Fatal("director-b led while director-a held the lease")
+	case <-time.After(2 * leaseDuration):
+	}
+
+	cancelA()
+	waitFor(t, doneA, 5*time.Second, "director-a to stop")
+	// the lease is released on shutdown, so the standby doesn't wait for it to expire
+	waitFor(t, startedB, leaseDuration, "director-b to take over the released lease")
+	if got := holder(t, client); got != "director-b" {
+		t.Fatalf("lease holder = %q, want director-b", got)
+	}
+	if overlapped.Load() {
+		t.Fatal("both replicas led at the same time")
+	}
+}
+
+// TestLeaseLostMidwayThroughAssign loses the lease while lead is assigning: renewals start failing, lead's context
+// must be cancelled within the renew deadline, and lead must not be started again until the assignment in progress
+// has drained, even though the elector campaigns again straight away.
+func TestLeaseLostMidwayThroughAssign(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	const drainPeriod = 300 * time.Millisecond
+
+	var failRenewals atomic.Bool
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failRenewals.Load() {
+			return true, nil, errors.New("apiserver unavailable")
+		}
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		lock     sync.Mutex
+		leads    int
+		assigns  int
+		running  bool
+		overlaps int
+	)
+	assigning := make(chan struct{})
+	lostAt := make(chan time.Time, 1)
+	drained := make(chan struct{})
+	go func() {
+		_ = newTestElector(client, "director-a").Run(ctx, testLease, func(ctx context.Context) {
+			lock.Lock()
+			leads++
+			first := leads == 1
+			if running {
+				overlaps++
+			}
+			running = true
+			lock.Unlock()
+			defer func() {
+				lock.Lock()
+				running = false
+				lock.Unlock()
+			}()
+			if !first {
+				<-ctx.Done()
+				return
+			}
+
+			// an assignment is in progress when the lease is lost
+			close(assigning)
+			<-ctx.Done()
+			lostAt <- time.Now()
+			// the matches already allocated are still assigned within the drain period
+			time.Sleep(drainPeriod)
+			lock.Lock()
+			assigns++
+			lock.Unlock()
+			close(drained)
+		})
+	}()
+
+	waitFor(t, assigning, 5*time.Second, "the assignment to start")
+	failedAt := time.Now()
+	failRenewals.Store(true)
+
+	waitFor(t, drained, renewDeadline+retryPeriod+drainPeriod+time.Second, "the assignment to drain")
+	if lost := (<-lostAt).Sub(failedAt); lost > renewDeadline+2*retryPeriod {
+		t.Fatalf("lead was cancelled %s after renewals failed, want within the renew deadline %s", lost, renewDeadline)
+	}
+
+	// once renewals work again the elector leads again, but only after the previous lead has returned
+	failRenewals.Store(false)
+	deadline := time.After(5 * leaseDuration)
+	for {
+		lock.Lock()
+		l := leads
+		lock.Unlock()
+		if l >= 2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting to lead again")
+		case <-time.After(retryPeriod):
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if assigns != 1 {
+		t.Fatalf("assignment finished %d times, want 1", assigns)
+	}
+	if overlaps != 0 {
+		t.Fatalf("lead ran %d times while the previous lead was still draining", overlaps)
+	}
+}
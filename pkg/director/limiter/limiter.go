@@ -0,0 +1,66 @@
+// Package limiter bounds how much work the director runs at once, both in total and per key,
+// so a burst of matches can't flood the Kubernetes API or a single fleet.
+package limiter
+
+import (
+	"context"
+	"sync"
+)
+
+// Limiter is a counting semaphore with a global limit and a limit for each key, e.g. a fleet name.
+type Limiter struct {
+	global   chan struct{}
+	perKey   int
+	keysLock sync.Mutex
+	keys     map[string]chan struct{}
+}
+
+func New(global int, perKey int) *Limiter {
+	return &Limiter{
+		global: make(chan struct{}, global),
+		perKey: perKey,
+		keys:   make(map[string]chan struct{}),
+	}
+}
+
+// Acquire blocks until there is room for the key and globally, or the context is done.
+// Every successful Acquire must be followed by a Release of the same key.
+func (l *Limiter) Acquire(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the key's slot is taken first so work waiting on a busy key doesn't hold a global slot
+	keySlots := l.keySlots(key)
+	select {
+	case keySlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case l.global <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		<-keySlots
+		return ctx.Err()
+	}
+}
+
+// Release frees the slots taken by Acquire.
+func (l *Limiter) Release(key string) {
+	<-l.global
+	<-l.keySlots(key)
+}
+
+func (l *Limiter) keySlots(key string) chan struct{} {
+	l.keysLock.Lock()
+	defer l.keysLock.Unlock()
+
+	slots, ok := l.keys[key]
+	if !ok {
+		slots = make(chan struct{}, l.perKey)
+		l.keys[key] = slots
+	}
+	return slots
+}
@@ -0,0 +1,130 @@
+// Package loop runs the director's work for each mode profile on its own supervised loop,
+// so a slow or failing profile doesn't delay the others.
+package loop
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// Loop runs a function every interval. Each run is bounded by a timeout, runs that fail are retried
+// with an exponential backoff up to maxBackoff and panics are recovered so the loop keeps running.
+// When the loop is stopped a run in progress is given the drain period to finish before it is cancelled.
+// Trigger runs the loop straight away instead of waiting for the next interval.
+type Loop struct {
+	name       string
+	interval   time.Duration
+	timeout    time.Duration
+	maxBackoff time.Duration
+	drain      time.Duration
+	run        func(ctx context.Context) error
+	trigger    chan struct{}
+}
+
+func New(name string, interval time.Duration, timeout time.Duration, maxBackoff time.Duration, drain time.Duration,
+	run func(ctx context.Context) error) *Loop {
+
+	return &Loop{
+		name:       name,
+		interval:   interval,
+		timeout:    timeout,
+		maxBackoff: maxBackoff,
+		drain:      drain,
+		run:        run,
+		trigger:    make(chan struct{}, 1),
+	}
+}
+
+// Trigger makes the loop run as soon as its current run has finished, skipping any interval or backoff left.
+// Triggers while a run is already pending are merged.
+func (l *Loop) Trigger() {
+	select {
+	case l.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the loop until the context is done and the last run has drained.
+func (l *Loop) Start(ctx context.Context) {
+	logger.Info("Starting loop", zap.String("name", l.name), zap.Duration("interval", l.interval), zap.Duration("timeout", l.timeout))
+
+	var backoff time.Duration
+	for {
+		start := time.Now()
+		err := l.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Only run every interval, but if that has already passed, run immediately.
+		wait := l.interval - time.Since(start)
+		if err != nil {
+			backoff = l.nextBackoff(backoff)
+			if backoff > wait {
+				wait = backoff
+			}
+			logger.Error("Loop run failed", zap.String("name", l.name), zap.Duration("backoff", wait), zap.Error(err))
+		} else {
+			backoff = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-l.trigger:
+			logger.Info("Loop triggered", zap.String("name", l.name))
+		}
+	}
+}
+
+func (l *Loop) runOnce(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			logger.Error("Loop run panicked", zap.String("name", l.name), zap.Any("panic", r), zap.ByteString("stack", debug.Stack()))
+		}
+	}()
+
+	// the run isn't cancelled with the loop's context straight away so it can finish its matches
+	runCtx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+		}
+
+		logger.Info("Draining loop", zap.String("name", l.name), zap.Duration("drain", l.drain))
+		select {
+		case <-finished:
+		case <-time.After(l.drain):
+			cancel()
+		}
+	}()
+
+	return l.run(runCtx)
+}
+
+func (l *Loop) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		backoff = l.interval
+	} else {
+		backoff *= 2
+	}
+	if backoff > l.maxBackoff {
+		return l.maxBackoff
+	}
+	return backoff
+}
@@ -3,16 +3,43 @@ package main
 import (
 	v1 "agones.dev/agones/pkg/apis/allocation/v1"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/ztrue/shutdown"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/credentials/insecure"
 	"io"
-	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/appconfig"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/health"
+	"matchmaker/pkg/common/join"
+	"matchmaker/pkg/common/metrics"
+	"matchmaker/pkg/common/mmfcontrol"
 	"matchmaker/pkg/common/modeprofile"
 	"matchmaker/pkg/common/modeprofile/config"
 	"matchmaker/pkg/common/notifier"
+	"matchmaker/pkg/common/selector"
+	"matchmaker/pkg/common/tracing"
 	"matchmaker/pkg/common/utils/kubernetes"
+	"matchmaker/pkg/director/admin"
+	"matchmaker/pkg/director/allocator"
+	"matchmaker/pkg/director/api"
+	"matchmaker/pkg/director/backfill"
+	"matchmaker/pkg/director/capacity"
+	"matchmaker/pkg/director/fleets"
+	"matchmaker/pkg/director/leader"
+	"matchmaker/pkg/director/limiter"
+	"matchmaker/pkg/director/loop"
+	"matchmaker/pkg/director/orphan"
+	"os"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
@@ -20,35 +47,343 @@ import (
 )
 
 const (
-	Namespace = "towerdefence"
+	// Allocations are retried with an exponential backoff before the match's tickets are released.
+	maxAllocationAttempts    = 3
+	initialAllocationBackoff = 100 * time.Millisecond
+)
+
+// errJoinTargetUnavailable is returned when a join match's target GameServer can't take its players, e.g. the game
+// filled up since the match was made. It isn't retried, the tickets are released to go through normal matchmaking.
+var errJoinTargetUnavailable = errors.New("join target can no longer be allocated")
+
+// directorConfig is loaded by appconfig from flags, environment variables and an optional file.
+type directorConfig struct {
+	Namespace string `json:"namespace" env:"NAMESPACE" flag:"namespace" usage:"The namespace GameServers are allocated in"`
+	// ModesFile is the JSON file of the modes, see modeprofile/config.File. The default modes are used if it doesn't exist.
+	ModesFile string `json:"modesFile" env:"MODES_FILE" flag:"modes_file" usage:"JSON file of the modes and allocation definitions"`
 
 	// The endpoint for the Open Match Backend service.
-	omBackendEndpoint = "open-match-backend.open-match.svc:50505"
+	BackendEndpoint string `json:"backendEndpoint" env:"OM_BACKEND_ENDPOINT" flag:"om_backend_endpoint" usage:"Open Match Backend endpoint"`
+	// The endpoint for the Open Match Frontend service, used by the API to create tickets.
+	FrontendEndpoint string `json:"frontendEndpoint" env:"OM_FRONTEND_ENDPOINT" flag:"om_frontend_endpoint" usage:"Open Match Frontend endpoint"`
+	// The endpoint for the Open Match Query service, used by the admin API to read the queues.
+	QueryEndpoint string `json:"queryEndpoint" env:"OM_QUERY_ENDPOINT" flag:"om_query_endpoint" usage:"Open Match QueryService endpoint"`
 	// The Host and Port for the Match Function service endpoint.
-	functionHostName       = "matchfunction.towerdefence.svc"
-	functionPort     int32 = 50502
+	FunctionHost string `json:"functionHost" env:"FUNCTION_HOST" flag:"function_host" usage:"Match function host, as called by Open Match"`
+	FunctionPort int32  `json:"functionPort" env:"FUNCTION_PORT" flag:"function_port" usage:"Match function port"`
 
-	minTimeBetweenRuns = 1000 * time.Millisecond
-)
+	// MinTimeBetweenRuns and RunTimeout are the defaults of profiles without a RunInterval and RunTimeout.
+	MinTimeBetweenRuns time.Duration `json:"minTimeBetweenRuns" env:"MIN_TIME_BETWEEN_RUNS" flag:"min_time_between_runs" usage:"Minimum time between fetching matches"`
+	RunTimeout         time.Duration `json:"runTimeout" env:"RUN_TIMEOUT" flag:"run_timeout" usage:"Maximum time fetching and assigning matches of a profile can take"`
+	// DrainPeriod is how long a run in progress has to finish its matches when the director shuts down or loses
+	// a Lease. Matches that haven't been allocated by then have their tickets released. With leader election it must
+	// be less than LeaseDuration - RenewDeadline, the least time between losing a Lease and a standby taking it over.
+	DrainPeriod time.Duration `json:"drainPeriod" env:"DRAIN_PERIOD" flag:"drain_period" usage:"Time in-flight matches have to finish on shutdown"`
+	// MaxRunBackoff is the longest a profile waits before running again after failing.
+	MaxRunBackoff time.Duration `json:"maxRunBackoff" env:"MAX_RUN_BACKOFF" flag:"max_run_backoff" usage:"Maximum backoff after a profile's run fails"`
+
+	// Timeouts of each outbound call by dependency, see the deadline package. Calls made during a run are also
+	// bounded by the run's context.
+	FetchTimeout         time.Duration `json:"fetchTimeout" env:"FETCH_TIMEOUT" flag:"fetch_timeout" usage:"Timeout of FetchMatches, including the match function's run"`
+	OpenMatchTimeout     time.Duration `json:"openMatchTimeout" env:"OPEN_MATCH_TIMEOUT" flag:"open_match_timeout" usage:"Timeout of other calls to Open Match"`
+	AllocationTimeout    time.Duration `json:"allocationTimeout" env:"ALLOCATION_TIMEOUT" flag:"allocation_timeout" usage:"Timeout of each allocation attempt"`
+	KubernetesTimeout    time.Duration `json:"kubernetesTimeout" env:"KUBERNETES_TIMEOUT" flag:"kubernetes_timeout" usage:"Timeout of calls to the Kubernetes API"`
+	PlayerTrackerTimeout time.Duration `json:"playerTrackerTimeout" env:"PLAYER_TRACKER_TIMEOUT" flag:"player_tracker_timeout" usage:"Timeout of calls to the player tracker"`
+	GameServerTimeout    time.Duration `json:"gameServerTimeout" env:"GAMESERVER_TIMEOUT" flag:"gameserver_timeout" usage:"Timeout of notifying game servers of matches"`
+	MatchFunctionTimeout time.Duration `json:"matchFunctionTimeout" env:"MATCH_FUNCTION_TIMEOUT" flag:"match_function_timeout" usage:"Timeout of calls to the match function's control API"`
+
+	// Allocated GameServers whose tickets haven't been assigned after the grace period have their match cancelled.
+	OrphanGracePeriod       time.Duration `json:"orphanGracePeriod" env:"ORPHAN_GRACE_PERIOD" flag:"orphan_grace_period" usage:"Time an allocation's tickets must be assigned in"`
+	OrphanReconcileInterval time.Duration `json:"orphanReconcileInterval" env:"ORPHAN_RECONCILE_INTERVAL" flag:"orphan_reconcile_interval" usage:"Time between checks for orphaned allocations"`
+
+	// Components are stale, failing the /readyz probe, once they haven't succeeded for their threshold. A profile's runs
+	// must succeed every RunStaleness, or its interval + timeout + MaxRunBackoff if longer, and a loop that hasn't
+	// finished a run in that time fails the /healthz probe as it's stuck.
+	HealthCheckInterval time.Duration `json:"healthCheckInterval" env:"HEALTH_CHECK_INTERVAL" flag:"health_check_interval" usage:"Time between checks of Open Match and the Kubernetes API"`
+	RunStaleness        time.Duration `json:"runStaleness" env:"RUN_STALENESS" flag:"run_staleness" usage:"Time a profile can go without a successful run"`
+	BackendStaleness    time.Duration `json:"backendStaleness" env:"BACKEND_STALENESS" flag:"backend_staleness" usage:"Time the Open Match Backend can be unreachable"`
+	KubernetesStaleness time.Duration `json:"kubernetesStaleness" env:"KUBERNETES_STALENESS" flag:"kubernetes_staleness" usage:"Time the Kubernetes API can be unreachable"`
+
+	// The port the director API is hosted on. A player routed through the API isn't routed again for RouteTTL while
+	// their ticket waits to be matched, the routes are shared by the replicas in the RoutesConfigMap.
+	ApiPort         int           `json:"apiPort" env:"API_PORT" flag:"api_port" usage:"Port of the director API"`
+	RouteTTL        time.Duration `json:"routeTTL" env:"ROUTE_TTL" flag:"route_ttl" usage:"Time a routed player isn't routed again while their ticket waits"`
+	RoutesConfigMap string        `json:"routesConfigMap" env:"ROUTES_CONFIG_MAP" flag:"routes_config_map" usage:"ConfigMap the routes of players are shared in"`
+	// The port the gRPC backfill service GameServers request players for running matches from is hosted on.
+	// GameServers authenticate to it and the director API with the GameServerToken, both are disabled without one.
+	BackfillPort    int    `json:"backfillPort" env:"BACKFILL_PORT" flag:"backfill_port" usage:"Port of the backfill gRPC service"`
+	GameServerToken string `json:"gameServerToken" env:"GAME_SERVER_TOKEN" flag:"game_server_token" usage:"Token GameServers authenticate to the director with" secret:"true"`
+
+	// The admin API is only enabled if AdminTokensFile is set, and served once the file exists, see admin.LoadTokens.
+	// Paused modes and requested runs are shared by the replicas in the AdminStateConfigMap, synced every AdminSyncInterval.
+	// Countdowns are controlled through the MatchFunctionControl API, authenticated with the MatchFunctionControlToken.
+	AdminPort                 int           `json:"adminPort" env:"ADMIN_PORT" flag:"admin_port" usage:"Port of the admin API"`
+	AdminTokensFile           string        `json:"adminTokensFile" env:"ADMIN_TOKENS_FILE" flag:"admin_tokens_file" usage:"JSON file of admin API actors and their tokens"`
+	AdminStateConfigMap       string        `json:"adminStateConfigMap" env:"ADMIN_STATE_CONFIG_MAP" flag:"admin_state_config_map" usage:"ConfigMap the admin state is shared in"`
+	AdminSyncInterval         time.Duration `json:"adminSyncInterval" env:"ADMIN_SYNC_INTERVAL" flag:"admin_sync_interval" usage:"Time between syncs of the admin state"`
+	MatchFunctionControl      string        `json:"matchFunctionControl" env:"MATCH_FUNCTION_CONTROL" flag:"match_function_control" usage:"URL of the match function's control API"`
+	MatchFunctionControlToken string        `json:"matchFunctionControlToken" env:"MATCH_FUNCTION_CONTROL_TOKEN" flag:"match_function_control_token" usage:"Token of the match function's control API" secret:"true"`
+
+	// Matches that no fleet has capacity for are held for up to MaxHoldTime before their tickets are released.
+	// This must be less than Open Match's pending release timeout or the tickets could be matched again while held.
+	MaxHoldTime time.Duration `json:"maxHoldTime" env:"MAX_HOLD_TIME" flag:"max_hold_time" usage:"Time a match waits for capacity before its tickets are released"`
+	// CapacityResync is how often the GameServer cache used for capacity checks is fully resynced.
+	CapacityResync time.Duration `json:"capacityResync" env:"CAPACITY_RESYNC" flag:"capacity_resync" usage:"Resync period of the GameServer cache"`
+
+	// Matches are allocated in parallel, bounded in total and per fleet so one busy fleet can't take every worker.
+	MaxParallelAllocations         int `json:"maxParallelAllocations" env:"MAX_PARALLEL_ALLOCATIONS" flag:"max_parallel_allocations" usage:"Maximum allocations in flight across all profiles"`
+	MaxParallelAllocationsPerFleet int `json:"maxParallelAllocationsPerFleet" env:"MAX_PARALLEL_ALLOCATIONS_PER_FLEET" flag:"max_parallel_allocations_per_fleet" usage:"Maximum allocations in flight for a single fleet"`
+
+	// With leader election only the replica holding a profile's Lease runs it, either one Lease for the whole
+	// director or, with LeasePerProfile, a Lease named {LeaseName}-{profileName} for each profile.
+	LeaderElection  bool          `json:"leaderElection" env:"LEADER_ELECTION" flag:"leader_election" usage:"Only run profiles while holding their Lease"`
+	LeaseName       string        `json:"leaseName" env:"LEASE_NAME" flag:"lease_name" usage:"Name of the director's Lease"`
+	LeasePerProfile bool          `json:"leasePerProfile" env:"LEASE_PER_PROFILE" flag:"lease_per_profile" usage:"Elect a leader for each profile so replicas share the profiles"`
+	LeaseDuration   time.Duration `json:"leaseDuration" env:"LEASE_DURATION" flag:"lease_duration" usage:"Time a standby waits before taking over a Lease that wasn't renewed"`
+	RenewDeadline   time.Duration `json:"renewDeadline" env:"RENEW_DEADLINE" flag:"renew_deadline" usage:"Time the leader has to renew its Lease before giving it up"`
+	RetryPeriod     time.Duration `json:"retryPeriod" env:"RETRY_PERIOD" flag:"retry_period" usage:"Time between attempts to acquire or renew a Lease"`
+	// Identity is this replica's name in the Lease, the hostname by default
+	Identity string `json:"identity" env:"POD_NAME" flag:"identity" usage:"Identity of this replica in Leases"`
+
+	// TracingExporter is where spans are exported, see tracing.Init. OtlpEndpoint is the collector of the otlp exporter.
+	TracingExporter string `json:"tracingExporter" env:"TRACING_EXPORTER" flag:"tracing_exporter" usage:"Span exporter: none, stdout or otlp"`
+	OtlpEndpoint    string `json:"otlpEndpoint" env:"OTLP_ENDPOINT" flag:"otlp_endpoint" usage:"OTLP collector endpoint of the otlp exporter"`
+
+	// AllocatorClustersFile lists the clusters to allocate from through the Agones allocator service, see allocator.LoadClusters
+	AllocatorClustersFile string `json:"allocatorClustersFile" env:"ALLOCATOR_CLUSTERS_FILE" flag:"allocator_clusters_file" usage:"Allocate through the allocator service of the clusters in this file"`
+}
+
+func (c *directorConfig) Validate() error {
+	if c.Namespace == "" || c.BackendEndpoint == "" || c.FrontendEndpoint == "" || c.FunctionHost == "" {
+		return fmt.Errorf("namespace, backend, frontend and function endpoints are required")
+	}
+	if err := appconfig.ValidateNamespace(c.Namespace); err != nil {
+		return err
+	}
+	if c.FunctionPort <= 0 || c.ApiPort <= 0 || c.BackfillPort <= 0 {
+		return fmt.Errorf("ports must be greater than 0")
+	}
+	if c.RoutesConfigMap == "" {
+		return fmt.Errorf("routes ConfigMap is required")
+	}
+	if c.MinTimeBetweenRuns <= 0 || c.RunTimeout <= 0 || c.MaxRunBackoff <= 0 || c.DrainPeriod <= 0 || c.OrphanReconcileInterval <= 0 ||
+		c.CapacityResync <= 0 || c.RouteTTL <= 0 {
+		return fmt.Errorf("intervals must be greater than 0")
+	}
+	if c.adminEnabled() {
+		if c.QueryEndpoint == "" || c.AdminStateConfigMap == "" || c.MatchFunctionControl == "" {
+			return fmt.Errorf("the admin API requires the query endpoint, admin state ConfigMap and match function control URL")
+		}
+		if c.AdminPort <= 0 || c.AdminSyncInterval <= 0 {
+			return fmt.Errorf("the admin API requires an admin port and sync interval greater than 0")
+		}
+	}
+	if c.FetchTimeout <= 0 || c.OpenMatchTimeout <= 0 || c.AllocationTimeout <= 0 || c.KubernetesTimeout <= 0 ||
+		c.PlayerTrackerTimeout <= 0 || c.GameServerTimeout <= 0 || c.MatchFunctionTimeout <= 0 {
+		return fmt.Errorf("timeouts must be greater than 0")
+	}
+	if c.LeaderElection && (c.LeaseName == "" || c.RetryPeriod <= 0 || c.RenewDeadline <= c.RetryPeriod || c.LeaseDuration <= c.RenewDeadline) {
+		return fmt.Errorf("leader election requires a lease name and lease duration > renew deadline > retry period > 0")
+	}
+	if c.LeaderElection && c.DrainPeriod >= c.LeaseDuration-c.RenewDeadline {
+		return fmt.Errorf("drain period must be less than lease duration - renew deadline, so a run has drained before a standby can take over its Lease")
+	}
+	if c.HealthCheckInterval <= 0 || c.RunStaleness <= 0 || c.BackendStaleness <= c.HealthCheckInterval || c.KubernetesStaleness <= c.HealthCheckInterval {
+		return fmt.Errorf("staleness thresholds must be greater than the health check interval")
+	}
+	if c.MaxParallelAllocations <= 0 || c.MaxParallelAllocationsPerFleet <= 0 {
+		return fmt.Errorf("parallel allocation limits must be greater than 0")
+	}
+	return nil
+}
+
+// adminEnabled is whether the admin API and the admin State it shares with the other replicas are enabled.
+func (c *directorConfig) adminEnabled() bool {
+	return c.AdminTokensFile != ""
+}
 
 var (
 	logger, _ = zap.NewProduction()
+
+	cfg = directorConfig{
+		Namespace:               appconfig.DefaultNamespace,
+		BackendEndpoint:         "open-match-backend.open-match.svc:50505",
+		FrontendEndpoint:        "open-match-frontend.open-match.svc:50504",
+		QueryEndpoint:           "open-match-query.open-match.svc:50503",
+		FunctionHost:            "matchfunction.towerdefence.svc",
+		FunctionPort:            50502,
+		MinTimeBetweenRuns:      1000 * time.Millisecond,
+		RunTimeout:              30 * time.Second,
+		MaxRunBackoff:           30 * time.Second,
+		DrainPeriod:             4 * time.Second,
+		FetchTimeout:            deadline.FetchMatches.Timeout(),
+		OpenMatchTimeout:        deadline.OpenMatch.Timeout(),
+		AllocationTimeout:       deadline.Allocation.Timeout(),
+		KubernetesTimeout:       deadline.Kubernetes.Timeout(),
+		PlayerTrackerTimeout:    deadline.PlayerTracker.Timeout(),
+		GameServerTimeout:       deadline.GameServer.Timeout(),
+		MatchFunctionTimeout:    deadline.MatchFunction.Timeout(),
+		OrphanGracePeriod:       30 * time.Second,
+		OrphanReconcileInterval: 15 * time.Second,
+		HealthCheckInterval:     5 * time.Second,
+		RunStaleness:            2 * time.Minute,
+		BackendStaleness:        30 * time.Second,
+		KubernetesStaleness:     30 * time.Second,
+		ApiPort:                 8080,
+		RouteTTL:                2 * time.Minute,
+		RoutesConfigMap:         "director-routes",
+		BackfillPort:            8082,
+		AdminPort:               8081,
+		AdminStateConfigMap:     "director-admin",
+		AdminSyncInterval:       2 * time.Second,
+		MatchFunctionControl:    "http://matchfunction.towerdefence.svc:8080",
+		MaxHoldTime:             30 * time.Second,
+		CapacityResync:          30 * time.Second,
+
+		MaxParallelAllocations:         16,
+		MaxParallelAllocationsPerFleet: 4,
+
+		LeaderElection: true,
+		LeaseName:      "director",
+		LeaseDuration:  15 * time.Second,
+		RenewDeadline:  10 * time.Second,
+		RetryPeriod:    2 * time.Second,
+
+		TracingExporter: tracing.NoExporter,
+	}
+
+	// gameServerAllocator allocates through the Kubernetes API, or the Agones allocator
+	// service of the clusters in the AllocatorClustersFile if set.
+	gameServerAllocator allocator.Allocator
+
+	// the outcome of every match is counted by fleets.RecordOutcome
+	allocationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "allocation_duration_seconds",
+		Help:      "Time taken to allocate a GameServer for a match, including retries",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{metrics.ProfileLabel, metrics.FleetLabel})
+	assignTicketsFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "assign_tickets_failures_total",
+		Help:      "Failed AssignTickets requests, and tickets Open Match couldn't assign by cause",
+	}, []string{metrics.ProfileLabel, "cause"})
+	timeToMatch = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "time_to_match_seconds",
+		Help:      "Time from a ticket being created to it being assigned a GameServer",
+		Buckets:   []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90, 120, 300, 600},
+	}, []string{metrics.ProfileLabel})
+
+	// capacityTracker checks fleets have capacity before allocating. It is nil when allocating through the
+	// allocator service as the GameServers of other clusters aren't cached, then every match is allocated.
+	capacityTracker *capacity.Tracker
+
+	// healthChecker tracks each profile's runs while this replica runs them, and the Open Match Backend and
+	// Kubernetes API, for the API's /healthz and /readyz probes
+	healthChecker = health.NewChecker()
+
+	// adminState holds the modes paused and fleet weights set through the admin API, it is shared by every replica.
+	// It is nil if the admin API is disabled.
+	adminState *admin.State
+
+	loopsLock sync.Mutex
+	// loops map[profileName]*loop.Loop are the profiles this replica is running, so the admin API can trigger them
+	loops = make(map[string]*loop.Loop)
+
+	// allocationLimiter is shared by every profile's loop and keyed by fleet name
+	allocationLimiter *limiter.Limiter
+
+	heldLock sync.Mutex
+	// heldMatches map[profileName][]heldMatch are waiting for capacity, their tickets stay pending in Open Match
+	heldMatches = make(map[string][]heldMatch)
 )
 
+// allocatedMatch is a match with an allocated GameServer whose tickets haven't been assigned yet
+type allocatedMatch struct {
+	match            *pb.Match
+	fleet            string
+	ticketIDs        []string
+	connection       string
+	gameServerName   string
+	allocatedMatchId string
+	// cluster the GameServer was allocated in
+	cluster kubernetes.Cluster
+	// joined is true if the match was added to a match already running on the GameServer, e.g. a join or
+	// spectator match, so the running match isn't the director's to cancel
+	joined bool
+	// span of the match, ended by completeAssignment or cancelAllocation
+	span trace.Span
+}
+
+// heldMatch is a match that no fleet had capacity for when it was fetched
+type heldMatch struct {
+	match  *pb.Match
+	heldAt time.Time
+}
+
 func main() {
+	appconfig.MustLoad("director", &cfg)
+	if err := config.Load(cfg.ModesFile); err != nil {
+		logger.Fatal("Failed to load modes", zap.Error(err))
+	}
+	allocationLimiter = limiter.New(cfg.MaxParallelAllocations, cfg.MaxParallelAllocationsPerFleet)
+	deadline.FetchMatches.Set(cfg.FetchTimeout)
+	deadline.OpenMatch.Set(cfg.OpenMatchTimeout)
+	deadline.Allocation.Set(cfg.AllocationTimeout)
+	deadline.Kubernetes.Set(cfg.KubernetesTimeout)
+	deadline.PlayerTracker.Set(cfg.PlayerTrackerTimeout)
+	deadline.GameServer.Set(cfg.GameServerTimeout)
+	deadline.MatchFunction.Set(cfg.MatchFunctionTimeout)
+
+	stopTracing, err := tracing.Init("director", cfg.TracingExporter, cfg.OtlpEndpoint)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+
 	// Connect to OM Backend.
-	conn, err := grpc.Dial(omBackendEndpoint,
+	conn, err := grpc.Dial(cfg.BackendEndpoint,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`),
 	)
 
 	if err != nil {
-		logger.Error("Failed to connect to Open Match Backend, got %s", zap.Error(err))
+		logger.Error("Failed to connect to Open Match Backend", zap.Error(err))
 	}
 
 	defer conn.Close()
 	be := pb.NewBackendServiceClient(conn)
 
+	// Connect to OM Frontend.
+	feConn, err := grpc.Dial(cfg.FrontendEndpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`),
+	)
+
+	if err != nil {
+		logger.Error("Failed to connect to Open Match Frontend", zap.Error(err))
+	}
+
+	defer feConn.Close()
+	fe := pb.NewFrontendServiceClient(feConn)
+
+	// the allocator adds the clusters the services look GameServers up in, so it is created first
+	gameServerAllocator, err = createAllocator()
+	if err != nil {
+		logger.Fatal("Failed to create allocator", zap.Error(err))
+	}
+
+	backfills := backfill.NewManager(cfg.Namespace, fe)
+	go backfill.NewService(backfills, cfg.GameServerToken).Start(cfg.BackfillPort)
+	go api.NewServer(cfg.Namespace, fe, healthChecker, cfg.RouteTTL, cfg.RoutesConfigMap, cfg.GameServerToken).Start(cfg.ApiPort)
+
+	if _, ok := gameServerAllocator.(*allocator.KubernetesAllocator); ok {
+		capacityTracker = capacity.NewTracker(cfg.Namespace, cfg.CapacityResync)
+		if err := capacityTracker.Start(make(chan struct{})); err != nil {
+			logger.Fatal("Failed to start capacity tracker", zap.Error(err))
+		}
+	}
+
 	modeProfiles := config.ModeProfiles
 
 	logger.Info("Fetching matches for profiles",
@@ -56,121 +391,755 @@ func main() {
 		zap.Any("profiles", modeProfiles),
 	)
 
-	// Only run every x milliseconds, but if that has already passed, run immediately.
-	for {
-		lastRunTime := time.Now()
-		run(be, modeProfiles)
-		timeSinceLastRun := time.Since(lastRunTime)
-		if timeSinceLastRun < minTimeBetweenRuns {
-			time.Sleep(minTimeBetweenRuns - timeSinceLastRun)
-		}
+	profiles := make([]modeprofile.ModeProfile, 0, len(modeProfiles))
+	for _, p := range modeProfiles {
+		profiles = append(profiles, p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if cfg.adminEnabled() {
+		closeAdmin := startAdmin(ctx, modeProfiles, fe)
+		defer closeAdmin()
+	} else {
+		logger.Warn("No admin tokens file, the admin API is disabled")
 	}
+
+	go healthChecker.Probe(ctx, "openmatch-backend", cfg.HealthCheckInterval, cfg.BackendStaleness, func(ctx context.Context) error {
+		return deadline.OpenMatch.Call(ctx, "HealthCheck", func(ctx context.Context) error {
+			return health.CheckConn(ctx, conn)
+		})
+	})
+	go healthChecker.Probe(ctx, "kubernetes", cfg.HealthCheckInterval, cfg.KubernetesStaleness, func(ctx context.Context) error {
+		return deadline.Kubernetes.Call(ctx, "Readyz", func(ctx context.Context) error {
+			return kubernetes.KubeClient.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+		})
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runDirector(ctx, be, profiles, backfills)
+	}()
+
+	shutdown.Add(func() {
+		logger.Info("Shutting down, draining in-flight matches", zap.Duration("drainPeriod", cfg.DrainPeriod))
+		cancel()
+		<-done
+		// the spans of drained matches are flushed once they have ended
+		if err := stopTracing(context.Background()); err != nil {
+			logger.Error("Failed to flush spans", zap.Error(err))
+		}
+	})
+	shutdown.Listen(syscall.SIGINT, syscall.SIGTERM)
 }
 
-func run(be pb.BackendServiceClient, profiles map[string]modeprofile.ModeProfile) {
+// runDirector runs the profiles, the orphan reconciler and the acknowledgement of backfills until the context is done,
+// only while holding their Lease if leader election is on. With a Lease per profile the reconciler and backfills run
+// under the director's own Lease.
+func runDirector(ctx context.Context, be pb.BackendServiceClient, profiles []modeprofile.ModeProfile, backfills *backfill.Manager) {
+	reconciler := orphan.NewReconciler(cfg.Namespace, cfg.OrphanGracePeriod, cfg.OrphanReconcileInterval)
+	if !cfg.LeaderElection {
+		go reconciler.Start(ctx)
+		go backfills.Start(ctx)
+		runProfiles(ctx, be, profiles)
+		return
+	}
+
+	if cfg.Identity == "" {
+		identity, err := os.Hostname()
+		if err != nil {
+			logger.Fatal("Failed to get hostname for leader election identity", zap.Error(err))
+		}
+		cfg.Identity = identity
+	}
+	elector := leader.NewElector(kubernetes.KubeClient, cfg.Namespace, cfg.Identity, cfg.LeaseDuration, cfg.RenewDeadline, cfg.RetryPeriod)
+	if !cfg.LeasePerProfile {
+		if err := elector.Run(ctx, cfg.LeaseName, func(ctx context.Context) {
+			go reconciler.Start(ctx)
+			go backfills.Start(ctx)
+			runProfiles(ctx, be, profiles)
+		}); err != nil && ctx.Err() == nil {
+			logger.Fatal("Leader election failed", zap.Error(err))
+		}
+		return
+	}
+
 	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := elector.Run(ctx, cfg.LeaseName, func(ctx context.Context) {
+			go backfills.Start(ctx)
+			reconciler.Start(ctx)
+		}); err != nil && ctx.Err() == nil {
+			logger.Fatal("Leader election failed", zap.String("lease", cfg.LeaseName), zap.Error(err))
+		}
+	}()
 	for _, p := range profiles {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup, p modeprofile.ModeProfile) {
+		go func(p modeprofile.ModeProfile) {
 			defer wg.Done()
-			matches, err := fetch(be, p.MatchProfile)
-			if err != nil {
-				logger.Info("Failed to fetch matches", zap.String("profileName", p.Name), zap.Error(err))
-				return
+			if err := elector.Run(ctx, cfg.LeaseName+"-"+p.Name, func(ctx context.Context) {
+				runProfiles(ctx, be, []modeprofile.ModeProfile{p})
+			}); err != nil && ctx.Err() == nil {
+				logger.Fatal("Leader election failed", zap.String("profileName", p.Name), zap.Error(err))
 			}
+		}(p)
+	}
+	wg.Wait()
+}
 
-			logger.Info("Generated matches", zap.Int("generated", len(matches)), zap.String("profileName", p.Name))
-			if err := assign(be, p, matches); err != nil {
-				logger.Error("Failed to assign servers to matches", zap.Error(err))
-				return
+// runProfiles runs every profile on its own loop so a slow profile doesn't delay the others,
+// until the context is done, e.g. when the director shuts down or its Lease is lost.
+// The tickets of matches still held for capacity are then released as no other replica knows about them.
+func runProfiles(ctx context.Context, be pb.BackendServiceClient, profiles []modeprofile.ModeProfile) {
+	var wg sync.WaitGroup
+	for _, p := range profiles {
+		wg.Add(1)
+		go func(p modeprofile.ModeProfile) {
+			defer wg.Done()
+			interval, timeout := getRunTimings(p)
+
+			// the profile is only tracked while this replica runs it
+			component := "run/" + p.Name
+			staleness := interval + timeout + cfg.MaxRunBackoff
+			if cfg.RunStaleness > staleness {
+				staleness = cfg.RunStaleness
 			}
-		}(&wg, p)
-	}
+			healthChecker.Track(component, staleness, true)
+			defer healthChecker.Untrack(component)
 
+			l := loop.New(p.Name, interval, timeout, cfg.MaxRunBackoff, cfg.DrainPeriod, func(ctx context.Context) error {
+				err := run(ctx, be, p)
+				healthChecker.Record(component, err)
+				return err
+			})
+			loopsLock.Lock()
+			loops[p.Name] = l
+			loopsLock.Unlock()
+			defer func() {
+				loopsLock.Lock()
+				delete(loops, p.Name)
+				loopsLock.Unlock()
+			}()
+
+			l.Start(ctx)
+		}(p)
+	}
 	wg.Wait()
+
+	releaseHeldMatches(be, profiles)
 }
 
-func fetch(be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error) {
+// startAdmin syncs the admin State and serves the admin API once its tokens exist. The returned func closes
+// the connection to the QueryService.
+func startAdmin(ctx context.Context, modeProfiles map[string]modeprofile.ModeProfile, fe pb.FrontendServiceClient) func() {
+	adminState = admin.NewState(kubernetes.KubeClient, cfg.Namespace, cfg.AdminStateConfigMap, triggerLoop)
+	adminState.Start(ctx, cfg.AdminSyncInterval)
+
+	tokens, err := loadAdminTokens()
+	if err != nil {
+		logger.Fatal("Failed to load admin tokens", zap.Error(err))
+	}
+	if len(tokens) == 0 {
+		logger.Warn("No admin tokens, the admin API is disabled", zap.String("adminTokensFile", cfg.AdminTokensFile))
+		return func() {}
+	}
+	if cfg.MatchFunctionControlToken == "" {
+		logger.Warn("No match function control token, countdowns can't be started or cancelled through the admin API")
+	}
+
+	// Connect to OM QueryService.
+	queryConn, err := grpc.Dial(cfg.QueryEndpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`),
+	)
+
+	if err != nil {
+		logger.Error("Failed to connect to Open Match QueryService", zap.Error(err))
+	}
+	query := pb.NewQueryServiceClient(queryConn)
+
+	go admin.NewServer(tokens, modeProfiles, adminState, fe, query, mmfcontrol.NewClient(cfg.MatchFunctionControl, cfg.MatchFunctionControlToken)).Start(cfg.AdminPort)
+	return func() {
+		queryConn.Close()
+	}
+}
+
+// loadAdminTokens loads the tokens of the admin API, there are none if the AdminTokensFile isn't set or doesn't exist.
+func loadAdminTokens() (map[string]string, error) {
+	if cfg.AdminTokensFile == "" {
+		return nil, nil
+	}
+	tokens, err := admin.LoadTokens(cfg.AdminTokensFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return tokens, err
+}
+
+// triggerLoop runs the profile straight away if this replica is running it, e.g. when requested through the admin API.
+func triggerLoop(profileName string) {
+	loopsLock.Lock()
+	defer loopsLock.Unlock()
+
+	if l, ok := loops[profileName]; ok {
+		l.Trigger()
+	}
+}
+
+// getRunTimings returns the interval and timeout of the profile's loop, falling back to the director's defaults.
+func getRunTimings(p modeprofile.ModeProfile) (time.Duration, time.Duration) {
+	interval := p.RunInterval
+	if interval <= 0 {
+		interval = cfg.MinTimeBetweenRuns
+	}
+	timeout := p.RunTimeout
+	if timeout <= 0 {
+		timeout = cfg.RunTimeout
+	}
+	return interval, timeout
+}
+
+// fleetWeights returns the runtime weight overrides of the profile's fleets set through the admin API.
+func fleetWeights(profileName string) map[string]int {
+	if adminState == nil {
+		return nil
+	}
+	return adminState.FleetWeights(profileName)
+}
+
+// run fetches and assigns the profile's matches, unless its mode was paused through the admin API.
+func run(ctx context.Context, be pb.BackendServiceClient, p modeprofile.ModeProfile) (err error) {
+	if adminState != nil && adminState.IsPaused(p.Name) {
+		logger.Debug("Mode is paused, skipping run", zap.String("profileName", p.Name))
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "director.run", attribute.String(metrics.ProfileLabel, p.Name))
+	defer func() { tracing.End(span, err) }()
+
+	matches, err := fetch(ctx, be, p.MatchProfile)
+	if err != nil {
+		return fmt.Errorf("failed to fetch matches: %w", err)
+	}
+
+	logger.Info("Generated matches", zap.Int("generated", len(matches)), zap.String("profileName", p.Name))
+	if err := assign(ctx, be, p, matches); err != nil {
+		return fmt.Errorf("failed to assign servers to matches: %w", err)
+	}
+	return nil
+}
+
+func fetch(ctx context.Context, be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error) {
 	req := &pb.FetchMatchesRequest{
 		Config: &pb.FunctionConfig{
-			Host: functionHostName,
-			Port: functionPort,
+			Host: cfg.FunctionHost,
+			Port: cfg.FunctionPort,
 			Type: pb.FunctionConfig_GRPC,
 		},
 		Profile: p,
 	}
 
-	stream, err := be.FetchMatches(context.Background(), req)
+	ctx, span := tracing.Start(ctx, "director.fetch")
+	var result []*pb.Match
+	err := deadline.FetchMatches.Call(ctx, "FetchMatches", func(ctx context.Context) error {
+		stream, err := be.FetchMatches(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			result = append(result, resp.GetMatch())
+		}
+	})
+	span.SetAttributes(attribute.Int("matches", len(result)))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+// assign allocates a GameServer for each placed match in parallel, then assigns the tickets of every
+// allocated match in one batch. A match that fails to allocate or assign has its tickets released
+// without affecting the others. Once the context is done, e.g. the drain period after shutting down or losing the
+// profile's Lease has passed, no more matches are allocated and their tickets are released, but matches that already
+// have a GameServer are still assigned.
+func assign(ctx context.Context, be pb.BackendServiceClient, profile modeprofile.ModeProfile, matches []*pb.Match) error {
+	ctx, span := tracing.Start(ctx, "director.assign", attribute.Int("matches", len(matches)))
+	defer span.End()
+
+	var lock sync.Mutex
+	var allocated []allocatedMatch
+	var wg sync.WaitGroup
+	for _, match := range placeMatches(ctx, be, profile, matches) {
+		if !match.GetAllocateGameserver() {
+			continue
+		}
+		wg.Add(1)
+		go func(match *pb.Match) {
+			defer wg.Done()
+			if a, ok := allocateMatch(ctx, be, profile, match); ok {
+				lock.Lock()
+				allocated = append(allocated, a)
+				lock.Unlock()
+			}
+		}(match)
+	}
+	wg.Wait()
+
+	assignTickets(ctx, be, profile, allocated)
+	return nil
+}
+
+// allocateMatch allocates a GameServer from the match's fleet once the allocation limiter has room.
+// The match's tickets are released if it can't be allocated.
+// The match's span, continuing the trace of its proposal, is ended once the match is assigned or cancelled.
+func allocateMatch(ctx context.Context, be pb.BackendServiceClient, profile modeprofile.ModeProfile, match *pb.Match) (allocatedMatch, bool) {
+	ticketIDs := getTicketIds(match)
+	ctx, span := tracing.StartMatch(ctx, "director.match", match, attribute.String(metrics.ProfileLabel, profile.Name))
+
+	fleet, err := fleets.Assign(profile, match, fleetWeights(profile.Name))
 	if err != nil {
-		return nil, err
+		logger.Error("Failed to pick fleet, releasing tickets", zap.String("matchId", match.MatchId), zap.Error(err))
+		releaseTickets(ctx, be, profile, match, ticketIDs, notifier.DelayNoFleet)
+		tracing.End(span, err)
+		return allocatedMatch{}, false
 	}
+	span.SetAttributes(attribute.String(metrics.FleetLabel, fleet))
 
-	var result []*pb.Match
-	for {
-		resp, err := stream.Recv()
-		if err == io.EOF {
-			break
+	if err := allocationLimiter.Acquire(ctx, fleet); err != nil {
+		logger.Error("Failed to wait for an allocation worker, releasing tickets", zap.String("matchId", match.MatchId), zap.Error(err))
+		releaseTickets(ctx, be, profile, match, ticketIDs, notifier.DelayAllocationFailed)
+		tracing.End(span, err)
+		return allocatedMatch{}, false
+	}
+	start := time.Now()
+	allocation, err := allocateWithRetry(ctx, profile, match)
+	allocationLimiter.Release(fleet)
+	allocationDuration.WithLabelValues(profile.Name, fleet).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		logger.Error("Failed to allocate server, releasing tickets", zap.String("matchId", match.MatchId), zap.String("fleet", fleet), zap.Error(err))
+		fleets.RecordOutcome(profile.Name, fleet, fleets.AllocationFailed)
+		releaseTickets(ctx, be, profile, match, ticketIDs, notifier.DelayAllocationFailed)
+		tracing.End(span, err)
+		return allocatedMatch{}, false
+	}
+	status := allocation.Status
+	cluster, err := kubernetes.GetCluster(status.Source, cfg.Namespace)
+	if err != nil {
+		logger.Error("Allocated in an unknown cluster, releasing tickets", zap.String("matchId", match.MatchId), zap.String("gameServer", status.GameServerName), zap.Error(err))
+		fleets.RecordOutcome(profile.Name, fleet, fleets.AllocationFailed)
+		releaseTickets(ctx, be, profile, match, ticketIDs, notifier.DelayAllocationFailed)
+		tracing.End(span, err)
+		return allocatedMatch{}, false
+	}
+	fleets.RecordOutcome(profile.Name, fleet, fleets.Allocated)
+	conn := fmt.Sprintf("%s:%d", status.Address, status.Ports[0].Port)
+	logger.Debug("Allocation created", zap.String("connection", conn), zap.String("matchId", match.MatchId))
+	span.SetAttributes(attribute.String("gameserver.name", status.GameServerName), attribute.String("gameserver.cluster", cluster.Name))
+
+	allocatedMatchId := allocation.Spec.MetaPatch.Annotations[annotations.MatchIdKey]
+	return allocatedMatch{
+		match:            match,
+		fleet:            fleet,
+		ticketIDs:        ticketIDs,
+		connection:       conn,
+		gameServerName:   status.GameServerName,
+		allocatedMatchId: allocatedMatchId,
+		cluster:          cluster,
+		joined:           allocatedMatchId != match.GetMatchId(),
+		span:             span,
+	}, true
+}
+
+// assignTickets assigns the tickets of the allocated matches in a single request with an AssignmentGroup for each
+// match. If the batch fails each match is retried on its own so one bad match doesn't fail the others.
+// Tickets that Open Match reports as failed, e.g. because they were deleted, are left out of their match.
+// The tickets are assigned even if ctx was cancelled while allocating, as the GameServers are already waiting.
+func assignTickets(ctx context.Context, be pb.BackendServiceClient, profile modeprofile.ModeProfile, allocated []allocatedMatch) {
+	if len(allocated) == 0 {
+		return
+	}
+
+	links := make([]trace.Link, 0, len(allocated))
+	for _, a := range allocated {
+		links = append(links, trace.Link{SpanContext: a.span.SpanContext()})
+	}
+	ctx, span := tracing.Start(tracing.Detach(ctx), "director.assignTickets", attribute.Int("matches", len(allocated)))
+	span.AddLink(links...)
+
+	req := &pb.AssignTicketsRequest{}
+	for _, a := range allocated {
+		req.Assignments = append(req.Assignments, &pb.AssignmentGroup{
+			TicketIds: a.ticketIDs,
+			Assignment: &pb.Assignment{
+				Connection: a.connection,
+			},
+		})
+	}
+
+	var resp *pb.AssignTicketsResponse
+	err := deadline.OpenMatch.Call(ctx, "AssignTickets", func(ctx context.Context) error {
+		var err error
+		resp, err = be.AssignTickets(ctx, req)
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		assignTicketsFailures.WithLabelValues(profile.Name, "request").Inc()
+		if len(allocated) > 1 {
+			logger.Error("Batched AssignTickets failed, assigning matches one by one", zap.Int("matches", len(allocated)), zap.Error(err))
+			for _, a := range allocated {
+				assignTickets(ctx, be, profile, []allocatedMatch{a})
+			}
+			return
 		}
+		a := allocated[0]
+		logger.Error("AssignTickets failed, cancelling allocation", zap.String("matchId", a.match.GetMatchId()), zap.Error(err))
+		cancelAllocation(be, profile, a, err)
+		return
+	}
 
-		if err != nil {
-			return nil, err
+	failed := make(map[string]bool, len(resp.GetFailures()))
+	for _, f := range resp.GetFailures() {
+		logger.Info("Ticket wasn't assigned", zap.String("ticketId", f.GetTicketId()), zap.String("cause", f.GetCause().String()))
+		assignTicketsFailures.WithLabelValues(profile.Name, f.GetCause().String()).Inc()
+		failed[f.GetTicketId()] = true
+	}
+
+	var wg sync.WaitGroup
+	for _, a := range allocated {
+		assigned := 0
+		for _, ticket := range a.match.GetTickets() {
+			if !failed[ticket.GetId()] {
+				assigned++
+				timeToMatch.WithLabelValues(profile.Name).Observe(time.Since(ticket.GetCreateTime().AsTime()).Seconds())
+			}
+		}
+		a.span.AddEvent("tickets assigned", trace.WithAttributes(
+			attribute.Int("assigned", assigned),
+			attribute.Int("failed", len(a.ticketIDs)-assigned),
+		))
+		if assigned == 0 {
+			logger.Error("No tickets of the match were assigned, cancelling allocation", zap.String("matchId", a.match.GetMatchId()))
+			cancelAllocation(be, profile, a, errors.New("no tickets were assigned"))
+			continue
 		}
 
-		result = append(result, resp.GetMatch())
+		wg.Add(1)
+		go func(a allocatedMatch) {
+			defer wg.Done()
+			completeAssignment(profile, a)
+		}(a)
+	}
+	wg.Wait()
+}
+
+// completeAssignment marks the match's allocation as assigned so it isn't reconciled as an orphan
+// and tells its players where to connect.
+func completeAssignment(profile modeprofile.ModeProfile, a allocatedMatch) {
+	defer a.span.End()
+	ctx := trace.ContextWithSpan(context.Background(), a.span)
+	fleets.RecordOutcome(profile.Name, a.fleet, fleets.Assigned)
+
+	if err := orphan.MarkAssigned(ctx, a.cluster, a.gameServerName, a.allocatedMatchId); err != nil {
+		logger.Error("Failed to mark allocation as assigned", zap.String("matchId", a.match.GetMatchId()), zap.Error(err))
 	}
 
-	return result, nil
+	notifier.NotifyPlayersOfMatch(ctx, a.match)
+
+	logger.Info("Assigned server to match", zap.String("conn", a.connection), zap.Any("match", a.match))
 }
 
-func assign(be pb.BackendServiceClient, profile modeprofile.ModeProfile, matches []*pb.Match) error {
+// cancelAllocation cancels the allocation of a match whose tickets couldn't be assigned and releases them.
+// Joined matches aren't cancelled as that would stop the game of the players already on the GameServer,
+// their players are left out of it by not being assigned.
+func cancelAllocation(be pb.BackendServiceClient, profile modeprofile.ModeProfile, a allocatedMatch, cause error) {
+	defer tracing.End(a.span, cause)
+	ctx := trace.ContextWithSpan(context.Background(), a.span)
+
+	if a.joined {
+		logger.Info("Not cancelling the running match the tickets were joining",
+			zap.String("matchId", a.match.GetMatchId()),
+			zap.String("gameServer", a.gameServerName),
+			zap.String("allocatedMatchId", a.allocatedMatchId),
+		)
+	} else if err := orphan.Cancel(ctx, a.cluster, a.gameServerName, a.allocatedMatchId); err != nil {
+		logger.Error("Failed to cancel orphaned allocation", zap.String("matchId", a.match.GetMatchId()), zap.Error(err))
+	}
+	fleets.RecordOutcome(profile.Name, a.fleet, fleets.AssignmentFailed)
+	releaseTickets(ctx, be, profile, a.match, a.ticketIDs, notifier.DelayAssignmentFailed)
+}
+
+// placeMatches returns the fetched and previously held matches that their fleet has capacity for, the largest and
+// then oldest first. The rest are held until capacity frees up and their players are told the match is delayed.
+// Matches held for longer than MaxHoldTime have their tickets released back to the pool.
+func placeMatches(ctx context.Context, be pb.BackendServiceClient, profile modeprofile.ModeProfile, matches []*pb.Match) []*pb.Match {
+	if capacityTracker == nil {
+		return matches
+	}
+
+	heldLock.Lock()
+	candidates := heldMatches[profile.Name]
+	delete(heldMatches, profile.Name)
+	heldLock.Unlock()
+
 	for _, match := range matches {
-		if !match.GetAllocateGameserver() {
+		candidates = append(candidates, heldMatch{match: match})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].match, candidates[j].match
+		if len(a.GetTickets()) != len(b.GetTickets()) {
+			return len(a.GetTickets()) > len(b.GetTickets())
+		}
+		return getOldestTicketTime(a).Before(getOldestTicketTime(b))
+	})
+
+	capacities := make(map[string]*capacity.Capacity)
+	var placed []*pb.Match
+	var held []heldMatch
+	for _, candidate := range candidates {
+		match := candidate.match
+		// join matches target a GameServer that is already running, backfill matches aren't allocated
+		if _, ok := join.TargetFromMatch(match); ok || !match.GetAllocateGameserver() {
+			placed = append(placed, match)
 			continue
 		}
-		var ticketIDs []string
-		for _, t := range match.GetTickets() {
-			ticketIDs = append(ticketIDs, t.Id)
+		fleet, err := fleets.Assign(profile, match, fleetWeights(profile.Name))
+		if err != nil {
+			placed = append(placed, match) // assign releases the tickets
+			continue
 		}
 
-		// Request an allocation based on that defined in the ModeProfile
-		allocation, err := kubernetes.AgonesClient.AllocationV1().
-			GameServerAllocations(Namespace).
-			Create(context.Background(), profile.Selector(profile, match), v12.CreateOptions{})
-
-		if err != nil {
-			logger.Error("Failed to allocate server", zap.String("matchId", match.MatchId), zap.Error(err))
+		fleetCapacity, ok := capacities[fleet]
+		if !ok {
+			fleetCapacity, err = capacityTracker.Capacity(fleet)
+			if err != nil {
+				logger.Error("Failed to get fleet capacity", zap.String("fleet", fleet), zap.Error(err))
+			}
+			capacities[fleet] = fleetCapacity
+		}
+		if fleetCapacity == nil || fleetCapacity.Take(len(match.GetTickets()), profile.PlayerBased) {
+			placed = append(placed, match)
 			continue
 		}
-		status := allocation.Status
-		if status.State != v1.GameServerAllocationAllocated {
-			logger.Error("Failed to allocate server", zap.String("matchId", match.MatchId), zap.String("state", string(status.State)))
+
+		if candidate.heldAt.IsZero() {
+			logger.Info("Holding match until its fleet has capacity", zap.String("matchId", match.GetMatchId()), zap.String("fleet", fleet))
+			candidate.heldAt = time.Now()
+			notifier.NotifyPlayersOfDelayedMatch(tracing.ContextFromMatch(ctx, match), match, notifier.DelayNoCapacity, nextRunTime(profile))
+		} else if time.Since(candidate.heldAt) > cfg.MaxHoldTime {
+			logger.Info("Releasing held match", zap.String("matchId", match.GetMatchId()), zap.String("fleet", fleet))
+			// the players have already been told the match is delayed
+			if err := releaseTicketIds(ctx, be, getTicketIds(match)); err != nil {
+				logger.Error("Failed to release tickets", zap.String("matchId", match.GetMatchId()), zap.Error(err))
+			}
 			continue
 		}
-		conn := fmt.Sprintf("%s:%d", status.Address, status.Ports[0].Port)
-		logger.Debug("Allocation created", zap.String("connection", conn), zap.String("matchId", match.MatchId))
+		held = append(held, candidate)
+	}
 
-		if err != nil {
-			return err
+	if len(held) > 0 {
+		heldLock.Lock()
+		heldMatches[profile.Name] = append(heldMatches[profile.Name], held...)
+		heldLock.Unlock()
+	}
+	return placed
+}
+
+// releaseHeldMatches releases the tickets of the profiles' held matches so they don't stay pending
+// until Open Match times them out. Their players have already been told the match is delayed.
+func releaseHeldMatches(be pb.BackendServiceClient, profiles []modeprofile.ModeProfile) {
+	heldLock.Lock()
+	var held []heldMatch
+	for _, p := range profiles {
+		held = append(held, heldMatches[p.Name]...)
+		delete(heldMatches, p.Name)
+	}
+	heldLock.Unlock()
+
+	for _, h := range held {
+		if err := releaseTicketIds(context.Background(), be, getTicketIds(h.match)); err != nil {
+			logger.Error("Failed to release tickets of held match", zap.String("matchId", h.match.GetMatchId()), zap.Error(err))
 		}
-		req := &pb.AssignTicketsRequest{
-			Assignments: []*pb.AssignmentGroup{
-				{
-					TicketIds: ticketIDs,
-					Assignment: &pb.Assignment{
-						Connection: conn,
-					},
-				},
-			},
+	}
+}
+
+func getTicketIds(match *pb.Match) []string {
+	var ticketIDs []string
+	for _, t := range match.GetTickets() {
+		ticketIDs = append(ticketIDs, t.Id)
+	}
+	return ticketIDs
+}
+
+func getOldestTicketTime(match *pb.Match) time.Time {
+	var oldest time.Time
+	for _, t := range match.GetTickets() {
+		created := t.GetCreateTime().AsTime()
+		if oldest.IsZero() || created.Before(oldest) {
+			oldest = created
+		}
+	}
+	return oldest
+}
+
+// allocateWithRetry retries failed allocations with an exponential backoff.
+// An allocation that isn't in the Allocated state is returned as an error.
+// It stops retrying once the context is done.
+func allocateWithRetry(ctx context.Context, profile modeprofile.ModeProfile, match *pb.Match) (*v1.GameServerAllocation, error) {
+	backoff := initialAllocationBackoff
+	var lastErr error
+	for attempt := 1; attempt <= maxAllocationAttempts; attempt++ {
+		allocation, err := allocate(ctx, profile, match)
+		if err == nil && allocation.Status.State == v1.GameServerAllocationAllocated {
+			return allocation, nil
+		}
+		if errors.Is(err, errJoinTargetUnavailable) {
+			return nil, err
 		}
+		if err == nil {
+			err = fmt.Errorf("allocation state %s", allocation.Status.State)
+		}
+		lastErr = err
 
-		if _, err := be.AssignTickets(context.Background(), req); err != nil {
-			return fmt.Errorf("AssignTickets failed for match %v, got %w", match.GetMatchId(), err)
+		logger.Info("Failed to allocate server",
+			zap.String("matchId", match.MatchId),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+		if attempt < maxAllocationAttempts {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
 		}
+	}
+	return nil, lastErr
+}
 
-		notifier.NotifyPlayersOfMatch(match)
+// releaseTickets returns the tickets of a match that couldn't be allocated to the pool
+// so they don't sit pending until Open Match times them out, and tells its players why it was delayed.
+func releaseTickets(ctx context.Context, be pb.BackendServiceClient, profile modeprofile.ModeProfile, match *pb.Match,
+	ticketIDs []string, reason notifier.DelayReason) {
 
-		logger.Info("Assigned server %v to match %v", zap.String("conn", conn), zap.Any("match", match))
+	if err := releaseTicketIds(ctx, be, ticketIDs); err != nil {
+		logger.Error("Failed to release tickets", zap.String("matchId", match.MatchId), zap.Error(err))
+		return
 	}
 
-	return nil
+	notifier.NotifyPlayersOfDelayedMatch(ctx, match, reason, nextRunTime(profile))
+}
+
+// nextRunTime is when the profile's tickets are next matched, at the latest, which is when a delayed match is retried.
+func nextRunTime(profile modeprofile.ModeProfile) time.Time {
+	interval, _ := getRunTimings(profile)
+	return time.Now().Add(interval)
+}
+
+// releaseTicketIds isn't bound to ctx's cancellation as tickets must be released even if the run was cancelled,
+// ctx only carries the trace.
+func releaseTicketIds(ctx context.Context, be pb.BackendServiceClient, ticketIDs []string) error {
+	return deadline.OpenMatch.Call(tracing.Detach(ctx), "ReleaseTickets", func(ctx context.Context) error {
+		_, err := be.ReleaseTickets(ctx, &pb.ReleaseTicketsRequest{TicketIds: ticketIDs})
+		return err
+	})
+}
+
+// allocate requests an allocation based on that defined in the ModeProfile.
+// If the match is joining a target game, that GameServer is selected instead. If the target can no longer be
+// allocated errJoinTargetUnavailable is returned, so the tickets are released rather than allocated a new game
+// on their own, as they may be fewer than the mode's MinPlayers.
+// Spectator matches are always allocated to their target GameServer. Both try the target's cluster first.
+// The allocate span's trace context is patched onto the GameServer with the match's extensions.
+func allocate(ctx context.Context, profile modeprofile.ModeProfile, match *pb.Match) (allocation *v1.GameServerAllocation, err error) {
+	ctx, span := tracing.Start(ctx, "director.allocate")
+	defer func() { tracing.End(span, err) }()
+	if err := tracing.InjectMatch(ctx, match); err != nil {
+		return nil, err
+	}
+
+	if target, ok := join.TargetFromMatch(match); ok && join.IsSpectatorMatch(match) {
+		// spectators can't fall back to a normal allocation as they would be allocated as players
+		gsa, err := selector.SpectatorAllocation(profile, match, target)
+		if err != nil {
+			return nil, err
+		}
+		return createAllocation(ctx, preferCluster(profile, target.Cluster), gsa)
+	} else if ok {
+		gsa, err := selector.JoinAllocation(profile, match, target)
+		if err != nil {
+			return nil, err
+		}
+		allocation, err := createAllocation(ctx, preferCluster(profile, target.Cluster), gsa)
+		if err != nil {
+			return nil, err
+		}
+		if allocation.Status.State != v1.GameServerAllocationAllocated {
+			logger.Info("Join target can no longer be allocated, releasing tickets to normal matchmaking",
+				zap.String("matchId", match.MatchId),
+				zap.String("gameServer", target.GameServerName),
+				zap.String("state", string(allocation.Status.State)),
+			)
+			return nil, errJoinTargetUnavailable
+		}
+		return allocation, nil
+	}
+
+	gsa, err := selector.Allocation(profile, match)
+	if err != nil {
+		return nil, err
+	}
+	return createAllocation(ctx, profile, gsa)
+}
+
+// preferCluster returns the profile with the cluster tried before those of its own cluster preference.
+func preferCluster(profile modeprofile.ModeProfile, cluster string) modeprofile.ModeProfile {
+	if cluster == "" {
+		return profile
+	}
+	profile.Clusters = append([]string{cluster}, profile.Clusters...)
+	return profile
+}
+
+func createAllocation(ctx context.Context, profile modeprofile.ModeProfile, gsa *v1.GameServerAllocation) (*v1.GameServerAllocation, error) {
+	var allocation *v1.GameServerAllocation
+	err := deadline.Allocation.Call(ctx, "Allocate", func(ctx context.Context) error {
+		var err error
+		allocation, err = gameServerAllocator.Allocate(ctx, profile, gsa)
+		return err
+	})
+	return allocation, err
+}
+
+func createAllocator() (allocator.Allocator, error) {
+	if cfg.AllocatorClustersFile == "" {
+		return allocator.NewKubernetesAllocator(cfg.Namespace), nil
+	}
+
+	clusters, err := allocator.LoadClusters(cfg.AllocatorClustersFile)
+	if err != nil {
+		return nil, err
+	}
+	// the clusters' GameServers are managed through their Kubernetes API once allocated
+	for _, c := range clusters {
+		err := kubernetes.AddCluster(kubernetes.ClusterConfig{Name: c.Name, Namespace: c.Namespace, KubeconfigFile: c.KubeconfigFile})
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger.Info("Allocating through the allocator service", zap.Any("clusters", clusters))
+	return allocator.NewServiceAllocator(clusters)
 }
@@ -0,0 +1,136 @@
+package orphan
+
+import (
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"matchmaker/pkg/common/annotations"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/utils/kubernetes"
+	"matchmaker/pkg/director/fleets"
+	"time"
+)
+
+var (
+	logger, _ = zap.NewProduction()
+)
+
+// MarkAssigned records that the tickets of the match allocated to the GameServer have been assigned.
+// GameServers whose match is never marked as assigned are cleaned up by the Reconciler.
+func MarkAssigned(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, matchId string) error {
+	return patchAnnotations(ctx, cluster, gameServerName, map[string]any{
+		annotations.AssignedMatchIdKey: matchId,
+	})
+}
+
+// Cancel compensates an allocation whose tickets could not be assigned. The match annotations are dropped
+// and the match is marked as cancelled, so the GameServer can stop the match (and shut down if it was
+// dedicated to it) instead of waiting for players that will never come.
+func Cancel(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, matchId string) error {
+	logger.Info("Cancelling orphaned match", zap.String("cluster", cluster.Name), zap.String("gameServer", gameServerName), zap.String("matchId", matchId))
+	return patchAnnotations(ctx, cluster, gameServerName, map[string]any{
+		annotations.CancelledMatchIdKey: matchId,
+		annotations.ExpectedPlayersKey:  "[]",
+	})
+}
+
+func patchAnnotations(ctx context.Context, cluster kubernetes.Cluster, gameServerName string, patchedAnnotations map[string]any) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": patchedAnnotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return deadline.Kubernetes.Call(ctx, "PatchGameServer", func(ctx context.Context) error {
+		_, err := cluster.Agones.AgonesV1().GameServers(cluster.Namespace).
+			Patch(ctx, gameServerName, types.MergePatchType, patch, v1.PatchOptions{})
+		return err
+	})
+}
+
+// Reconciler periodically finds allocated GameServers whose expected players never got assignments,
+// e.g. because the director stopped between allocating and assigning, and cancels their match.
+// The GameServers of every cluster the director allocates in are reconciled, see kubernetes.Clusters.
+type Reconciler struct {
+	namespace string
+	// gracePeriod is how long after an allocation the tickets must have been assigned by
+	gracePeriod time.Duration
+	interval    time.Duration
+}
+
+func NewReconciler(namespace string, gracePeriod time.Duration, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		namespace:   namespace,
+		gracePeriod: gracePeriod,
+		interval:    interval,
+	}
+}
+
+// Start reconciles on the interval until the context is done, e.g. when the director loses its Lease
+// as only one replica should reconcile. This blocks until then.
+func (r *Reconciler) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, cluster := range kubernetes.Clusters(r.namespace) {
+			if err := r.reconcile(ctx, cluster); err != nil && ctx.Err() == nil {
+				logger.Error("Failed to reconcile orphaned allocations", zap.String("cluster", cluster.Name), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, cluster kubernetes.Cluster) error {
+	var gameServers *agonesv1.GameServerList
+	err := deadline.Kubernetes.Call(ctx, "ListGameServers", func(ctx context.Context) error {
+		var err error
+		gameServers, err = cluster.Agones.AgonesV1().GameServers(cluster.Namespace).List(ctx, v1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, gs := range gameServers.Items {
+		if !r.isOrphaned(gs) {
+			continue
+		}
+
+		matchId := gs.ObjectMeta.Annotations[annotations.MatchIdKey]
+		if err := Cancel(ctx, cluster, gs.ObjectMeta.Name, matchId); err != nil {
+			logger.Error("Failed to cancel orphaned match", zap.String("gameServer", gs.ObjectMeta.Name), zap.String("matchId", matchId), zap.Error(err))
+			continue
+		}
+		fleets.RecordOutcome(gs.ObjectMeta.Annotations[annotations.ExtensionPrefix+"mode"], gs.ObjectMeta.Labels["agones.dev/fleet"], fleets.Orphaned)
+	}
+	return nil
+}
+
+func (r *Reconciler) isOrphaned(gs agonesv1.GameServer) bool {
+	if gs.Status.State != agonesv1.GameServerStateAllocated {
+		return false
+	}
+
+	gsAnnotations := gs.ObjectMeta.Annotations
+	matchId := gsAnnotations[annotations.MatchIdKey]
+	if matchId == "" || matchId == gsAnnotations[annotations.AssignedMatchIdKey] || matchId == gsAnnotations[annotations.CancelledMatchIdKey] {
+		return false
+	}
+
+	lastAllocated, err := time.Parse(time.RFC3339, gsAnnotations[annotations.LastAllocatedKey])
+	if err != nil {
+		return false
+	}
+	return time.Since(lastAllocated) > r.gracePeriod
+}
@@ -1,14 +1,110 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"matchmaker/pkg/common/appconfig"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/metrics"
+	"matchmaker/pkg/common/modeprofile/config"
+	"matchmaker/pkg/common/tracing"
+	"matchmaker/pkg/common/utils/kubernetes"
 	"matchmaker/pkg/matchfunction/mmf"
+	"time"
 )
 
-const (
-	queryServiceAddress = "open-match-query.open-match.svc:50503" // Address of the QueryService endpoint.
-	serverPort          = 50502                                   // The port for hosting the Match Function.
-)
+// matchFunctionConfig is loaded by appconfig from flags, environment variables and an optional file.
+type matchFunctionConfig struct {
+	QueryServiceAddress string `json:"queryServiceAddress" env:"OM_QUERY_ENDPOINT" flag:"om_query_endpoint" usage:"Address of the Open Match QueryService"`
+	ServerPort          int    `json:"serverPort" env:"PORT" flag:"port" usage:"The port for hosting the Match Function"`
+	// ModesFile is the JSON file of the modes, see modeprofile/config.File. The default modes are used if it doesn't exist.
+	ModesFile string `json:"modesFile" env:"MODES_FILE" flag:"modes_file" usage:"JSON file of the modes and allocation definitions"`
+	// ClustersFile lists the other clusters join targets are looked up in, see kubernetes.LoadClusters.
+	// It is the same file as the director's allocatorClustersFile.
+	ClustersFile string `json:"clustersFile" env:"CLUSTERS_FILE" flag:"clusters_file" usage:"JSON file of the clusters GameServers run in"`
+	// DrainPeriod is how long runs in progress have to finish on shutdown before they are cut off.
+	DrainPeriod time.Duration `json:"drainPeriod" env:"DRAIN_PERIOD" flag:"drain_period" usage:"Time in-flight runs have to finish on shutdown"`
+	// The openmatch.MatchFunction gRPC health service stops serving once the QueryService hasn't been reachable for QueryStaleness.
+	HealthCheckInterval time.Duration `json:"healthCheckInterval" env:"HEALTH_CHECK_INTERVAL" flag:"health_check_interval" usage:"Time between checks of the QueryService"`
+	QueryStaleness      time.Duration `json:"queryStaleness" env:"QUERY_STALENESS" flag:"query_staleness" usage:"Time the QueryService can be unreachable"`
+	// MetricsPort serves the Prometheus metrics on /metrics
+	MetricsPort int `json:"metricsPort" env:"METRICS_PORT" flag:"metrics_port" usage:"The port for hosting metrics"`
+	// ControlPort serves the countdown control API used by the director's admin API, which must send the ControlToken.
+	// The control API is disabled without a ControlToken.
+	ControlPort  int    `json:"controlPort" env:"CONTROL_PORT" flag:"control_port" usage:"The port for hosting the control API"`
+	ControlToken string `json:"controlToken" env:"CONTROL_TOKEN" flag:"control_token" usage:"Token the director sends to the control API" secret:"true"`
+
+	// Timeouts of each outbound call by dependency, see the deadline package.
+	OpenMatchTimeout     time.Duration `json:"openMatchTimeout" env:"OPEN_MATCH_TIMEOUT" flag:"open_match_timeout" usage:"Timeout of querying Open Match pools"`
+	KubernetesTimeout    time.Duration `json:"kubernetesTimeout" env:"KUBERNETES_TIMEOUT" flag:"kubernetes_timeout" usage:"Timeout of calls to the Kubernetes API"`
+	PlayerTrackerTimeout time.Duration `json:"playerTrackerTimeout" env:"PLAYER_TRACKER_TIMEOUT" flag:"player_tracker_timeout" usage:"Timeout of calls to the player tracker"`
+	GameServerTimeout    time.Duration `json:"gameServerTimeout" env:"GAMESERVER_TIMEOUT" flag:"gameserver_timeout" usage:"Timeout of notifying game servers of countdowns"`
+
+	// TracingExporter is where spans are exported, see tracing.Init. OtlpEndpoint is the collector of the otlp exporter.
+	TracingExporter string `json:"tracingExporter" env:"TRACING_EXPORTER" flag:"tracing_exporter" usage:"Span exporter: none, stdout or otlp"`
+	OtlpEndpoint    string `json:"otlpEndpoint" env:"OTLP_ENDPOINT" flag:"otlp_endpoint" usage:"OTLP collector endpoint of the otlp exporter"`
+}
+
+func (c *matchFunctionConfig) Validate() error {
+	if c.QueryServiceAddress == "" {
+		return fmt.Errorf("queryServiceAddress is required")
+	}
+	if c.ServerPort <= 0 || c.MetricsPort <= 0 || c.ControlPort <= 0 {
+		return fmt.Errorf("ports must be greater than 0")
+	}
+	if c.DrainPeriod <= 0 {
+		return fmt.Errorf("drainPeriod must be greater than 0")
+	}
+	if c.HealthCheckInterval <= 0 || c.QueryStaleness <= c.HealthCheckInterval {
+		return fmt.Errorf("queryStaleness must be greater than the health check interval")
+	}
+	if c.OpenMatchTimeout <= 0 || c.KubernetesTimeout <= 0 || c.PlayerTrackerTimeout <= 0 || c.GameServerTimeout <= 0 {
+		return fmt.Errorf("timeouts must be greater than 0")
+	}
+	return nil
+}
 
 func main() {
-	mmf.Start(queryServiceAddress, serverPort)
+	cfg := matchFunctionConfig{
+		QueryServiceAddress: "open-match-query.open-match.svc:50503",
+		ServerPort:          50502,
+		DrainPeriod:         10 * time.Second,
+		MetricsPort:         9090,
+		ControlPort:         8080,
+		HealthCheckInterval: 5 * time.Second,
+		QueryStaleness:      30 * time.Second,
+
+		OpenMatchTimeout:     deadline.OpenMatch.Timeout(),
+		KubernetesTimeout:    deadline.Kubernetes.Timeout(),
+		PlayerTrackerTimeout: deadline.PlayerTracker.Timeout(),
+		GameServerTimeout:    deadline.GameServer.Timeout(),
+
+		TracingExporter: tracing.NoExporter,
+	}
+	appconfig.MustLoad("matchfunction", &cfg)
+	if err := config.Load(cfg.ModesFile); err != nil {
+		log.Fatalf("Failed to load modes, got %s", err.Error())
+	}
+	if err := kubernetes.LoadClusters(cfg.ClustersFile); err != nil {
+		log.Fatalf("Failed to load clusters, got %s", err.Error())
+	}
+	deadline.OpenMatch.Set(cfg.OpenMatchTimeout)
+	deadline.Kubernetes.Set(cfg.KubernetesTimeout)
+	deadline.PlayerTracker.Set(cfg.PlayerTrackerTimeout)
+	deadline.GameServer.Set(cfg.GameServerTimeout)
+
+	stopTracing, err := tracing.Init("matchfunction", cfg.TracingExporter, cfg.OtlpEndpoint)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing, got %s", err.Error())
+	}
+
+	go metrics.Serve(cfg.MetricsPort)
+	go mmf.StartControl(cfg.ControlPort, cfg.ControlToken)
+	mmf.Start(cfg.QueryServiceAddress, cfg.ServerPort, cfg.DrainPeriod, cfg.HealthCheckInterval, cfg.QueryStaleness)
+
+	// Start returns once the runs have drained, the spans of their proposals are flushed before exiting
+	if err := stopTracing(context.Background()); err != nil {
+		log.Printf("Failed to flush spans, got %s", err.Error())
+	}
 }
@@ -0,0 +1,102 @@
+package mmf
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	commonmmf "matchmaker/pkg/common/mmf"
+	"net/http"
+	"strings"
+)
+
+// CountdownRequest addresses the countdown of a mode's pool.
+type CountdownRequest struct {
+	Mode string `json:"mode"`
+	Pool string `json:"pool"`
+}
+
+// StartControl hosts the control API the director's admin API uses to act on countdowns, which only the
+// match function knows about. Requests must have an `Authorization: Bearer <token>` header with the token shared
+// with the director, the API is disabled without one. This blocks until the server fails.
+func StartControl(port int, token string) {
+	if token == "" {
+		log.Printf("No control token, the control API is disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/countdowns", authorize(token, "GetCountdowns", http.HandlerFunc(handleCountdowns)))
+	mux.Handle("/v1/countdowns/start", authorize(token, "StartCountdown", handleCountdownAction(commonmmf.ForceStartCountdown)))
+	mux.Handle("/v1/countdowns/cancel", authorize(token, "CancelCountdown", handleCountdownAction(commonmmf.CancelCountdown)))
+
+	log.Printf("Starting control API on port %v", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		log.Printf("Control API failed, got %s", err.Error())
+	}
+}
+
+// authorize only passes on requests bearing the control token. Every request is logged with its action and
+// whether it was allowed, the director's admin API audits who asked for it.
+func authorize(token string, action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) != 1 {
+
+			log.Printf("Denied control request %s from %s", action, r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, errors.New("a valid bearer token is required"))
+			return
+		}
+		log.Printf("Control request %s from %s", action, r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleCountdowns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, commonmmf.Countdowns())
+}
+
+func handleCountdownAction(action func(mode string, pool string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		var req CountdownRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		if req.Mode == "" || req.Pool == "" {
+			writeError(w, http.StatusBadRequest, errors.New("mode and pool are required"))
+			return
+		}
+
+		if err := action(req.Mode, req.Pool); errors.Is(err, commonmmf.ErrNoCountdown) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct{}{})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response, got %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
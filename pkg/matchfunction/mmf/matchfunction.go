@@ -1,21 +1,51 @@
 package mmf
 
 import (
+	"context"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
 	"log"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/metrics"
+	commonmmf "matchmaker/pkg/common/mmf"
 	"matchmaker/pkg/common/modeprofile"
 	"matchmaker/pkg/common/modeprofile/config"
+	"matchmaker/pkg/common/tracing"
+	"matchmaker/pkg/common/utils"
 	"open-match.dev/open-match/pkg/matchfunction"
 	"open-match.dev/open-match/pkg/pb"
+	"sort"
 	"strings"
 )
 
-const (
-	poolName = "all"
+var (
+	poolTicketCounts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "pool_tickets",
+		Help:      "Tickets in each pool at the last run",
+	}, []string{metrics.ProfileLabel, "pool"})
+	proposalsPerRun = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "proposals_per_run",
+		Help:      "Match proposals made by each run",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{metrics.ProfileLabel})
+	matchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "match_size_tickets",
+		Help:      "Tickets in each match proposal",
+		Buckets:   prometheus.LinearBuckets(1, 2, 10),
+	}, []string{metrics.ProfileLabel})
 )
 
 // Run is this match function's implementation of the gRPC call defined in api/matchfunction.proto.
-func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_RunServer) error {
+// Each proposal starts a trace that the director continues, see the tracing package.
+func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_RunServer) (err error) {
+	ctx, span := tracing.Start(stream.Context(), "mmf.run", attribute.String(metrics.ProfileLabel, req.GetProfile().GetName()))
+	defer func() { tracing.End(span, err) }()
+
 	// Fetch tickets for the pools specified in the Match Profile.
 	log.Printf("Generating proposals for function %v", req.GetProfile().GetName())
 	matchProfile := req.GetProfile()
@@ -26,30 +56,57 @@ func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_R
 		return err
 	}
 
-	poolTickets, err := matchfunction.QueryPools(stream.Context(), s.queryServiceClient, req.GetProfile().GetPools())
+	var poolTickets map[string][]*pb.Ticket
+	err = deadline.OpenMatch.Call(ctx, "QueryPools", func(ctx context.Context) error {
+		var err error
+		poolTickets, err = matchfunction.QueryPools(ctx, s.queryServiceClient, req.GetProfile().GetPools())
+		return err
+	})
 	if err != nil {
 		log.Printf("Failed to query tickets for the given pools, got %s", err.Error())
 		return err
 	}
 
-	//poolBackfills, err := matchfunction.QueryBackfillPools(stream.Context(), s.queryServiceClient, req.GetProfile().GetPools())
-	//if err != nil {
-	//	log.Printf("Failed to query backfills for the given pools, got %s", err.Error())
-	//	return err
-	//}
+	var poolBackfills map[string][]*pb.Backfill
+	err = deadline.OpenMatch.Call(ctx, "QueryBackfillPools", func(ctx context.Context) error {
+		var err error
+		poolBackfills, err = matchfunction.QueryBackfillPools(ctx, s.queryServiceClient, req.GetProfile().GetPools())
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to query backfills for the given pools, got %s", err.Error())
+		return err
+	}
 
+	for poolName, tickets := range poolTickets {
+		poolTicketCounts.WithLabelValues(modeProfile.Name, poolName).Set(float64(len(tickets)))
+	}
 	ticketCount := getTicketCount(poolTickets)
-	//backfillCount := getBackfillCount(poolBackfills)
-	log.Printf("Got %v tickets for pools [%v]", ticketCount, strings.Join(getPoolNames(req.GetProfile().GetPools()), ", "))
+	backfillCount := getBackfillCount(poolBackfills)
+	log.Printf("Got %v tickets and %v backfills for pools [%v]", ticketCount, backfillCount, strings.Join(getPoolNames(req.GetProfile().GetPools()), ", "))
 	log.Printf("Tickets: %v", poolTickets)
 
 	// Generate proposals.
-	proposals, err := makeMatches(modeProfile, makePoolMap(req.Profile.Pools), poolTickets)
+	proposals, err := makeMatches(ctx, modeProfile, makePoolMap(req.Profile.Pools), poolTickets, poolBackfills)
 	if err != nil {
 		log.Printf("Failed to generate matches, got %s", err.Error())
 		return err
 	}
 
+	proposalsPerRun.WithLabelValues(modeProfile.Name).Observe(float64(len(proposals)))
+	for _, proposal := range proposals {
+		matchSize.WithLabelValues(modeProfile.Name).Observe(float64(len(proposal.GetTickets())))
+
+		proposalCtx, proposalSpan := tracing.StartProposal(ctx, "mmf.proposal", proposal)
+		err := tracing.InjectMatch(proposalCtx, proposal)
+		tracing.End(proposalSpan, err)
+		if err != nil {
+			log.Printf("Failed to add the trace context to proposal %s, got %s", proposal.GetMatchId(), err.Error())
+			return err
+		}
+	}
+	span.SetAttributes(attribute.Int("proposals", len(proposals)))
+
 	log.Printf("Streaming %v proposals to Open Match", len(proposals))
 	// Stream the generated proposals back to Open Match.
 	for _, proposal := range proposals {
@@ -70,20 +127,63 @@ func makePoolMap(pools []*pb.Pool) map[string]*pb.Pool {
 	return poolMap
 }
 
-func makeMatches(modeProfile modeprofile.ModeProfile, pools map[string]*pb.Pool, poolTickets map[string][]*pb.Ticket) ([]*pb.Match, error) {
-	pool := pools[poolName]
-	tickets := poolTickets[poolName]
+// makeMatches matches the tickets of each pool separately, as every pool is a range of client versions
+// sent to a fleet that supports them (see modeprofile.VersionRoute).
+func makeMatches(ctx context.Context, modeProfile modeprofile.ModeProfile, pools map[string]*pb.Pool, poolTickets map[string][]*pb.Ticket, poolBackfills map[string][]*pb.Backfill) ([]*pb.Match, error) {
+	var matches []*pb.Match
+	for poolName, pool := range pools {
+		route, ok := modeProfile.Route(poolName)
+		if !ok {
+			log.Printf("Pool %s of profile %s has no version route", poolName, modeProfile.Name)
+			continue
+		}
+
+		poolMatches, err := makePoolMatches(ctx, modeProfile, route, pool, poolTickets[poolName], poolBackfills[poolName])
+		if err != nil {
+			return nil, err
+		}
+		// the director allocates from the fleet of the match's pool
+		for _, match := range poolMatches {
+			if err := utils.SetMatchStringExtension(match, modeprofile.PoolExtension, poolName); err != nil {
+				return nil, err
+			}
+		}
+		matches = append(matches, poolMatches...)
+	}
+	return matches, nil
+}
 
+func makePoolMatches(ctx context.Context, modeProfile modeprofile.ModeProfile, route modeprofile.VersionRoute, pool *pb.Pool, tickets []*pb.Ticket, backfills []*pb.Backfill) ([]*pb.Match, error) {
 	if len(tickets) == 0 {
 		return nil, nil
 	}
 
-	matches, err := modeProfile.MatchFunction(modeProfile, pool, tickets)
+	// tickets with a higher priority (e.g. players routed back to the lobby) are matched first.
+	sort.SliceStable(tickets, func(i, j int) bool {
+		return utils.ExtractPriorityFromTicket(tickets[i]) > utils.ExtractPriorityFromTicket(tickets[j])
+	})
+
+	// spectators never take a player slot so are matched separately.
+	matches, tickets := commonmmf.MakeSpectatorMatches(ctx, modeProfile, route, tickets)
+
+	// tickets joining a friend are matched to their game first, the rest go through normal matchmaking.
+	joinMatches, tickets := commonmmf.MakeJoinMatches(ctx, modeProfile, route, tickets)
+	matches = append(matches, joinMatches...)
+
+	// players from a finished match that opted in to a rematch are kept together.
+	rematchMatches, tickets := commonmmf.MakeRematchMatches(modeProfile, tickets)
+	matches = append(matches, rematchMatches...)
+
+	// games that requested more players are filled before new matches are made.
+	backfillMatches, tickets := commonmmf.FillBackfills(modeProfile, tickets, backfills)
+	matches = append(matches, backfillMatches...)
+
+	madeMatches, err := modeProfile.MatchFunction(modeProfile, pool, tickets)
 	if err != nil {
 		return nil, err
 	}
 
-	return matches, nil
+	return append(matches, madeMatches...), nil
 }
 
 func getPoolNames(pools []*pb.Pool) []string {
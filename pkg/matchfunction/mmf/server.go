@@ -1,16 +1,27 @@
 package mmf
 
 import (
+	"context"
 	"fmt"
+	"github.com/ztrue/shutdown"
 	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"log"
+	"matchmaker/pkg/common/deadline"
+	"matchmaker/pkg/common/health"
 	"matchmaker/pkg/common/modeprofile/config"
 	"net"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 	"open-match.dev/open-match/pkg/pb"
 )
 
+// matchFunctionService is the name of the Open Match MatchFunction gRPC service, used for its health status
+const matchFunctionService = "openmatch.MatchFunction"
+
 // MatchFunctionService implements pb.MatchFunctionServer, the server generated
 // by compiling the protobuf, by fulfilling the pb.MatchFunctionServer interface.
 type MatchFunctionService struct {
@@ -22,7 +33,11 @@ type MatchFunctionService struct {
 // Start creates and starts the Match Function server and also connects to Open
 // Match's queryService service. This connection is used at runtime to fetch tickets
 // for pools specified in MatchProfile.
-func Start(queryServiceAddr string, serverPort int) {
+// On SIGTERM the server stops accepting runs and waits up to drainPeriod for those in progress.
+//
+// The server implements gRPC health checking. The server as a whole ("") is serving until it shuts down, the
+// openmatch.MatchFunction service is only serving while the QueryService has been reachable within queryStaleness.
+func Start(queryServiceAddr string, serverPort int, drainPeriod time.Duration, healthCheckInterval time.Duration, queryStaleness time.Duration) {
 	// Connect to QueryService.
 	conn, err := grpc.Dial(queryServiceAddr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
@@ -43,14 +58,65 @@ func Start(queryServiceAddr string, serverPort int) {
 	// Create and host a new gRPC service on the configured port.
 	server := grpc.NewServer()
 	pb.RegisterMatchFunctionServer(server, &mmfService)
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	go watchQueryService(healthCtx, healthServer, conn, healthCheckInterval, queryStaleness)
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", serverPort))
 	if err != nil {
 		log.Fatalf("TCP net listener initialization failed for port %v, got %s", serverPort, err.Error())
 	}
 
 	log.Printf("TCP net listener initialized for port %v", serverPort)
-	err = server.Serve(ln)
-	if err != nil {
-		log.Fatalf("gRPC serve failed, got %s", err.Error())
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.Fatalf("gRPC serve failed, got %s", err.Error())
+		}
+	}()
+
+	shutdown.Add(func() {
+		log.Printf("Shutting down, draining runs for up to %v", drainPeriod)
+		stopHealthChecks()
+		healthServer.Shutdown()
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(drainPeriod):
+			log.Printf("Runs didn't finish in time, stopping")
+			server.Stop()
+		}
+	})
+	shutdown.Listen(syscall.SIGINT, syscall.SIGTERM)
+}
+
+// watchQueryService sets the serving status of the match function service from the reachability
+// of the QueryService, checked every interval until the context is done.
+func watchQueryService(ctx context.Context, healthServer *grpchealth.Server, conn *grpc.ClientConn, interval time.Duration, staleness time.Duration) {
+	checker := health.NewChecker()
+	go checker.Probe(ctx, "openmatch-query", interval, staleness, func(ctx context.Context) error {
+		return deadline.OpenMatch.Call(ctx, "HealthCheck", func(ctx context.Context) error {
+			return health.CheckConn(ctx, conn)
+		})
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if report := checker.Readiness(); !report.Healthy {
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		healthServer.SetServingStatus(matchFunctionService, servingStatus)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
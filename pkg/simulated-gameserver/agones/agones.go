@@ -8,6 +8,7 @@ import (
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/utils/strings/slices"
+	"matchmaker/pkg/common/annotations"
 	"matchmaker/pkg/simulated-gameserver/config"
 	"strconv"
 	"time"
@@ -28,6 +29,10 @@ var (
 )
 
 func UpdateShouldAllocate() {
+	// the 'games' Counter is incremented by Agones on allocation, so there is no label to update
+	if config.Current.CountersAndLists {
+		return
+	}
 	shouldAllocate := len(RunningMatchIds) < maxRunningGames
 	err := Sdk.SetLabel("should-allocate", strconv.FormatBool(shouldAllocate)) // translates to agonesSdk.dev/sdk-should-allocate
 	if err != nil {
@@ -35,16 +40,40 @@ func UpdateShouldAllocate() {
 	}
 }
 
+// SetNameLabel labels the GameServer with its own name so it can be selected directly
+// by an allocation, e.g. when a player joins a friend's game.
+func SetNameLabel(gs *sdk2.GameServer) {
+	err := Sdk.SetLabel("gameserver-name", gs.ObjectMeta.Name) // translates to agones.dev/sdk-gameserver-name
+	if err != nil {
+		logger.Error("Failed to set gameserver name label", zap.String("name", gs.ObjectMeta.Name), zap.Error(err))
+	}
+}
+
+// StartCountersAndListsIfEnabled sets the capacity of the 'games' Counter and 'players' List
+// used by selector.CountsAndListsDefinition. Both must be defined in the GameServer spec.
+func StartCountersAndListsIfEnabled() {
+	logger.Info("Attempting to start counters and lists", zap.Bool("enabled", config.Current.CountersAndLists))
+	if !config.Current.CountersAndLists {
+		return
+	}
+	if _, err := Sdk.Alpha().SetCounterCapacity("games", maxRunningGames); err != nil {
+		logger.Error("Could not set games counter capacity", zap.Error(err))
+	}
+	if _, err := Sdk.Alpha().SetListCapacity("players", config.Current.PlayerTrackingSlots); err != nil {
+		logger.Error("Could not set players list capacity", zap.Error(err))
+	}
+}
+
 func StartPlayerTrackingIfEnabled() {
-	logger.Info("Attempting to start player tracking", zap.Bool("enabled", *config.EnablePlayerTracking), zap.Int64("capacity", *config.PlayerTrackingSlots))
-	if !*config.EnablePlayerTracking {
+	logger.Info("Attempting to start player tracking", zap.Bool("enabled", config.Current.EnablePlayerTracking), zap.Int64("capacity", config.Current.PlayerTrackingSlots))
+	if !config.Current.EnablePlayerTracking {
 		return
 	}
-	err := Sdk.Alpha().SetPlayerCapacity(*config.PlayerTrackingSlots)
+	err := Sdk.Alpha().SetPlayerCapacity(config.Current.PlayerTrackingSlots)
 	if err != nil {
 		logger.Error("Could not set player capacity", zap.Error(err))
 	} else {
-		logger.Info("Set player capacity", zap.Int("capacity", int(*config.PlayerTrackingSlots)))
+		logger.Info("Set player capacity", zap.Int("capacity", int(config.Current.PlayerTrackingSlots)))
 	}
 }
 
@@ -68,21 +97,48 @@ func DoHealth() {
 	}
 }
 
+// IsNewAllocation checks if the GameServer has been allocated or had players backfilled
+// by the director since the last change.
 func IsNewAllocation(gs *sdk2.GameServer) bool {
-	str := gs.ObjectMeta.Annotations["agones.dev/last-allocated"]
-	if str == "" {
-		return false
+	isNew := false
+	for _, annotation := range []string{annotations.LastAllocatedKey, annotations.BackfilledAtKey} {
+		str := gs.ObjectMeta.Annotations[annotation]
+		if str == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			logger.Error("Could not parse allocation annotation", zap.String("annotation", annotation), zap.Error(err))
+			continue
+		}
+		if ts.After(lastAllocated) {
+			lastAllocated = ts
+			isNew = true
+		}
 	}
-	ts, err := time.Parse(time.RFC3339, str)
-	if err != nil {
-		logger.Error("Could not parse last-allocated annotation", zap.Error(err))
-		return false
+	return isNew
+}
+
+// GetCancelledMatch returns the ID of a match running on this GameServer that the director has cancelled,
+// because its players were never assigned.
+func GetCancelledMatch(gs *sdk2.GameServer) (string, bool) {
+	matchId := gs.ObjectMeta.Annotations[annotations.CancelledMatchIdKey]
+	if matchId == "" || !slices.Contains(RunningMatchIds, matchId) {
+		return "", false
 	}
-	if ts.After(lastAllocated) {
-		lastAllocated = ts
-		return true
+	return matchId, true
+}
+
+// RemoveMatch stops tracking a match on this GameServer
+func RemoveMatch(matchId string) {
+	var remaining []string
+	for _, id := range RunningMatchIds {
+		if id != matchId {
+			remaining = append(remaining, id)
+		}
 	}
-	return false
+	RunningMatchIds = remaining
+	delete(BackfillIds, matchId)
 }
 
 // IsBackfill checks if the match ID of the allocation is already
@@ -91,45 +147,24 @@ func IsBackfill(allocation Allocation) bool {
 	return slices.Contains(RunningMatchIds, allocation.MatchId)
 }
 
-type Allocation struct {
-	MatchId         string   `json:"matchId"`
-	ExpectedPlayers []string `json:"expectedPlayers"`
-	BackfillId      string   `json:"backfillId"`
+// IsSpectatorAllocation checks if the allocation only contains spectators
+// who are watching a match already running on this GameServer.
+func IsSpectatorAllocation(allocation Allocation) bool {
+	return len(allocation.ExpectedPlayers) == 0 && len(allocation.ExpectedSpectators) > 0
 }
 
-func ParseAllocation(gs *sdk2.GameServer) (Allocation, error) {
-	annotations := gs.ObjectMeta.Annotations
-
-	var matchId string
-	var playerIds []string
-	var backfillId string
-	for k, v := range annotations {
-		if k == "openmatch.dev/match-id" {
-			matchId = v
-			continue
-		}
-		if k == "openmatch.dev/backfill-id" {
-			backfillId = v
-			continue
-		}
-		if k == "openmatch.dev/expected-players" {
-			err := json.Unmarshal([]byte(v), &playerIds)
-			if err != nil {
-				return Allocation{}, fmt.Errorf("could not parse player-ids (%s) annotation: %w", v, err)
-			}
-			continue
-		}
-	}
+// Allocation is the match the GameServer has been allocated for, parsed from its annotations
+type Allocation = annotations.Match
 
-	return Allocation{
-		MatchId:         matchId,
-		ExpectedPlayers: playerIds,
-		BackfillId:      backfillId,
-	}, nil
+func ParseAllocation(gs *sdk2.GameServer) (Allocation, error) {
+	return annotations.Decode(gs.ObjectMeta.Annotations)
 }
 
 func TrackPlayersOnAgones(allocation Allocation) {
-	if !*config.EnablePlayerTracking {
+	if config.Current.CountersAndLists {
+		trackPlayersOnList(allocation)
+	}
+	if !config.Current.EnablePlayerTracking {
 		return
 	}
 	for _, pId := range allocation.ExpectedPlayers {
@@ -139,3 +174,20 @@ func TrackPlayersOnAgones(allocation Allocation) {
 		}
 	}
 }
+
+// trackPlayersOnList adds players that weren't added by the allocation (e.g. backfilled players) to the 'players' List.
+func trackPlayersOnList(allocation Allocation) {
+	for _, pId := range allocation.ExpectedPlayers {
+		contains, err := Sdk.Alpha().ListContains("players", pId)
+		if err != nil {
+			logger.Error("Could not check players list", zap.String("playerId", pId), zap.Error(err))
+			continue
+		}
+		if contains {
+			continue
+		}
+		if _, err := Sdk.Alpha().AppendListValue("players", pId); err != nil {
+			logger.Error("Could not add player to players list", zap.String("playerId", pId), zap.Error(err))
+		}
+	}
+}
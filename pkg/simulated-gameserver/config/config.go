@@ -1,13 +1,30 @@
 package config
 
-import "flag"
-
-var (
-	EnablePlayerTracking = flag.Bool("enable_player_tracking", false, "Enable player tracking with Agones")
-	PlayerTrackingSlots  = flag.Int64("player_tracking_slots", 50, "Number of slots/max players")
-	HighDensity          = flag.Bool("high_density", true, "Enable high density mode (multiple GameServers on one instance)")
+import (
+	"matchmaker/pkg/common/appconfig"
 )
 
+// Config is loaded by appconfig from flags, environment variables and an optional file.
+type Config struct {
+	EnablePlayerTracking bool   `json:"enablePlayerTracking" env:"ENABLE_PLAYER_TRACKING" flag:"enable_player_tracking" usage:"Enable player tracking with Agones"`
+	PlayerTrackingSlots  int64  `json:"playerTrackingSlots" env:"PLAYER_TRACKING_SLOTS" flag:"player_tracking_slots" usage:"Number of slots/max players"`
+	HighDensity          bool   `json:"highDensity" env:"HIGH_DENSITY" flag:"high_density" usage:"Enable high density mode (multiple GameServers on one instance)"`
+	CountersAndLists     bool   `json:"countersAndLists" env:"COUNTERS_AND_LISTS" flag:"counters_and_lists" usage:"Track game slots and players with Agones Counters and Lists instead of the should-allocate label"`
+	MatchSize            int    `json:"matchSize" env:"MATCH_SIZE" flag:"match_size" usage:"Players per match, more are requested from the director if a match has fewer (0 to disable)"`
+	DirectorEndpoint     string `json:"directorEndpoint" env:"DIRECTOR_ENDPOINT" flag:"director_endpoint" usage:"Address (host:port) of the director's backfill service"`
+	GameServerToken      string `json:"gameServerToken" env:"GAME_SERVER_TOKEN" flag:"game_server_token" usage:"Token the director's backfill service authenticates GameServers with" secret:"true"`
+	TracingExporter      string `json:"tracingExporter" env:"TRACING_EXPORTER" flag:"tracing_exporter" usage:"Span exporter: none, stdout or otlp"`
+	OtlpEndpoint         string `json:"otlpEndpoint" env:"OTLP_ENDPOINT" flag:"otlp_endpoint" usage:"OTLP collector endpoint of the otlp exporter"`
+}
+
+// Current is the loaded configuration, it holds the defaults until Init is called
+var Current = Config{
+	PlayerTrackingSlots: 50,
+	HighDensity:         true,
+	DirectorEndpoint:    "director.towerdefence.svc:8082",
+	TracingExporter:     "none",
+}
+
 func Init() {
-	flag.Parse()
+	appconfig.MustLoad("simulated-gameserver", &Current)
 }
@@ -0,0 +1,71 @@
+package director
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"matchmaker/pkg/director/backfill/backfillpb"
+	"matchmaker/pkg/simulated-gameserver/config"
+	"sync"
+	"time"
+)
+
+const (
+	requestTimeout = 5 * time.Second
+)
+
+var (
+	logger, _ = zap.NewDevelopment()
+
+	clientOnce sync.Once
+	client     backfillpb.BackfillClient
+)
+
+// getClient connects to the director's backfill service on first use.
+func getClient() backfillpb.BackfillClient {
+	clientOnce.Do(func() {
+		conn, err := grpc.Dial(config.Current.DirectorEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			logger.Fatal("Failed to connect to the director", zap.Error(err))
+		}
+		client = backfillpb.NewBackfillClient(conn)
+	})
+	return client
+}
+
+// newContext bounds a call by the request timeout and authenticates it with the director's GameServer token.
+func newContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+config.Current.GameServerToken), cancel
+}
+
+// RequestPlayers asks the director for more players for a match running on this GameServer.
+// The director creates a backfill which is filled from the pool without a new allocation.
+func RequestPlayers(gameServerName string, matchId string, players int32) (string, error) {
+	ctx, cancel := newContext()
+	defer cancel()
+
+	resp, err := getClient().RequestBackfill(ctx, &backfillpb.RequestBackfillRequest{
+		GameServerName: gameServerName,
+		MatchId:        matchId,
+		Players:        players,
+	})
+	if err != nil {
+		return "", err
+	}
+	backfillId := resp.BackfillId
+
+	logger.Info("Requested players", zap.String("matchId", matchId), zap.Int32("players", players), zap.String("backfillId", backfillId))
+	return backfillId, nil
+}
+
+// CancelBackfill tells the director no more players are needed for the backfill.
+func CancelBackfill(gameServerName string, backfillId string) error {
+	ctx, cancel := newContext()
+	defer cancel()
+
+	_, err := getClient().CancelBackfill(ctx, &backfillpb.CancelBackfillRequest{GameServerName: gameServerName, BackfillId: backfillId})
+	return err
+}
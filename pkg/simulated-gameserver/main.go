@@ -2,10 +2,14 @@ package main
 
 import (
 	sdk2 "agones.dev/agones/pkg/sdk"
+	"context"
+	"github.com/ztrue/shutdown"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"matchmaker/pkg/common/tracing"
 	"matchmaker/pkg/simulated-gameserver/agones"
 	"matchmaker/pkg/simulated-gameserver/config"
-	"matchmaker/pkg/simulated-gameserver/openmatch"
+	"matchmaker/pkg/simulated-gameserver/director"
 	"matchmaker/pkg/simulated-gameserver/tracker"
 )
 
@@ -20,6 +24,17 @@ func main() {
 	logger.Info("Starting simulated gameserver")
 	config.Init()
 
+	stopTracing, err := tracing.Init("simulated-gameserver", config.Current.TracingExporter, config.Current.OtlpEndpoint)
+	if err != nil {
+		logger.Error("Could not set up tracing", zap.Error(err))
+		return
+	}
+	shutdown.Add(func() {
+		if err := stopTracing(context.Background()); err != nil {
+			logger.Error("Could not flush spans", zap.Error(err))
+		}
+	})
+
 	go agones.DoHealth()
 
 	// Get self details
@@ -29,6 +44,7 @@ func main() {
 		return
 	}
 	logger.Debug("Got gameserver details", zap.Any("gameserver", gs))
+	agones.SetNameLabel(gs)
 
 	err = agones.Sdk.Ready()
 	if err != nil {
@@ -43,13 +59,19 @@ func main() {
 	}
 
 	agones.StartPlayerTrackingIfEnabled()
+	agones.StartCountersAndListsIfEnabled()
 
-	// Wait forever
-	select {}
+	// backfills are cancelled on shutdown so no more players are sent here
+	shutdown.Add(cancelBackfills)
+	shutdown.Listen()
 }
 
 func gameServerChange(gs *sdk2.GameServer) {
 	logger.Debug("GameServer changed", zap.Any("gameserver", gs))
+	if matchId, ok := agones.GetCancelledMatch(gs); ok {
+		cancelMatch(matchId)
+		return
+	}
 	if agones.IsNewAllocation(gs) {
 		allocation, err := agones.ParseAllocation(gs)
 		if err != nil {
@@ -58,12 +80,29 @@ func gameServerChange(gs *sdk2.GameServer) {
 		}
 		logger.Info("Allocation", zap.Any("allocation", allocation))
 
+		// continues the match's trace from the director's allocation
+		_, span := tracing.Start(tracing.ContextFromExtensions(context.Background(), allocation.Extensions), "gameserver.allocation",
+			attribute.String("match.id", allocation.MatchId),
+			attribute.String("gameserver.name", gs.ObjectMeta.Name),
+			attribute.Bool("backfill", agones.IsBackfill(allocation)),
+		)
+		defer span.End()
+
+		// spectators join an existing match and don't take a player slot
+		if agones.IsSpectatorAllocation(allocation) {
+			tracker.AddSpectators(allocation.MatchId, allocation.ExpectedSpectators...)
+			logger.Debug("Spectators now tracked", zap.Any("spectators", tracker.MatchSpectators))
+			return
+		}
+
 		trackPlayers(allocation)
 
-		agones.RunningMatchIds = append(agones.RunningMatchIds, allocation.MatchId)
+		if !agones.IsBackfill(allocation) {
+			agones.RunningMatchIds = append(agones.RunningMatchIds, allocation.MatchId)
+			requestPlayersIfNotFull(allocation)
+		}
 		if allocation.BackfillId != "" {
 			agones.BackfillIds[allocation.MatchId] = allocation.BackfillId
-			openmatch.RegisterBackfill(allocation.BackfillId)
 		}
 
 		// updates the label of whether this gameserver can take more allocations
@@ -71,8 +110,6 @@ func gameServerChange(gs *sdk2.GameServer) {
 		agones.UpdateShouldAllocate()
 		agones.TrackPlayersOnAgones(allocation)
 
-		openmatch.HandlePossibleUpdate(allocation)
-
 		logger.Debug("Players now tracked", zap.Any("players", tracker.MatchPlayers))
 	}
 }
@@ -85,3 +122,44 @@ func trackPlayers(allocation agones.Allocation) {
 		tracker.MatchPlayers[allocation.MatchId] = allocation.ExpectedPlayers
 	}
 }
+
+// requestPlayersIfNotFull asks the director for more players if a new match
+// was allocated with fewer players than the configured match size.
+func requestPlayersIfNotFull(allocation agones.Allocation) {
+	missing := config.Current.MatchSize - len(allocation.ExpectedPlayers)
+	if config.Current.MatchSize <= 0 || missing <= 0 {
+		return
+	}
+
+	backfillId, err := director.RequestPlayers(agones.SelfGs.ObjectMeta.Name, allocation.MatchId, int32(missing))
+	if err != nil {
+		logger.Error("Could not request players", zap.String("matchId", allocation.MatchId), zap.Error(err))
+		return
+	}
+	agones.BackfillIds[allocation.MatchId] = backfillId
+}
+
+func cancelBackfills() {
+	for matchId, backfillId := range agones.BackfillIds {
+		if err := director.CancelBackfill(agones.SelfGs.ObjectMeta.Name, backfillId); err != nil {
+			logger.Error("Could not cancel backfill", zap.String("matchId", matchId), zap.String("backfillId", backfillId), zap.Error(err))
+		}
+	}
+}
+
+// cancelMatch stops a match the director cancelled because its players were never assigned.
+// The GameServer is shut down if it isn't running any other matches.
+func cancelMatch(matchId string) {
+	logger.Info("Match cancelled by director", zap.String("matchId", matchId))
+	agones.RemoveMatch(matchId)
+	tracker.RemoveMatch(matchId)
+
+	if len(agones.RunningMatchIds) > 0 {
+		agones.UpdateShouldAllocate()
+		return
+	}
+
+	if err := agones.Sdk.Shutdown(); err != nil {
+		logger.Error("Could not shutdown", zap.Error(err))
+	}
+}
@@ -1,8 +1,21 @@
 package tracker
 
-// MatchPlayers is a map of match IDs to player IDs
-var MatchPlayers = make(map[string][]string)
+var (
+	// MatchPlayers is a map of match IDs to player IDs
+	MatchPlayers = make(map[string][]string)
+	// MatchSpectators is a map of match IDs to spectator IDs
+	MatchSpectators = make(map[string][]string)
+)
 
 func AddPlayers(matchId string, playerIds ...string) {
 	MatchPlayers[matchId] = append(MatchPlayers[matchId], playerIds...)
 }
+
+func AddSpectators(matchId string, spectatorIds ...string) {
+	MatchSpectators[matchId] = append(MatchSpectators[matchId], spectatorIds...)
+}
+
+func RemoveMatch(matchId string) {
+	delete(MatchPlayers, matchId)
+	delete(MatchSpectators, matchId)
+}
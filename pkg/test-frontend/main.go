@@ -2,28 +2,40 @@ package main
 
 import (
 	"context"
-	"flag"
+	"fmt"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	"log"
+	"matchmaker/pkg/common/appconfig"
 	"open-match.dev/open-match/pkg/pb"
 	"time"
 )
 
-var (
-	// retrieve the TICKETS_PER_SECOND environment variable
-	timeBetweenCreations = flag.Duration("time_between_creations", 1*time.Second, "The time between ticket creations")
-	ticketCreationAmount = flag.Int("ticket_creation_amount", 1, "The amount of tickets to create per duration")
-)
+// frontendConfig is loaded by appconfig from flags, environment variables and an optional file.
+type frontendConfig struct {
+	FrontendEndpoint     string        `json:"frontendEndpoint" env:"OM_FRONTEND_ENDPOINT" flag:"om_frontend_endpoint" usage:"Open Match Frontend endpoint"`
+	TimeBetweenCreations time.Duration `json:"timeBetweenCreations" env:"TIME_BETWEEN_CREATIONS" flag:"time_between_creations" usage:"The time between ticket creations"`
+	TicketCreationAmount int           `json:"ticketCreationAmount" env:"TICKET_CREATION_AMOUNT" flag:"ticket_creation_amount" usage:"The amount of tickets to create per duration"`
+	ClientVersion        int           `json:"clientVersion" env:"CLIENT_VERSION" flag:"client_version" usage:"The client protocol version of created tickets, 0 to not set one"`
+}
 
-const (
-	openMatchFrontendEndpoint = "open-match-frontend.open-match.svc:50504"
-)
+func (c *frontendConfig) Validate() error {
+	if c.TimeBetweenCreations <= 0 {
+		return fmt.Errorf("timeBetweenCreations must be greater than 0")
+	}
+	return nil
+}
 
 var (
+	cfg = frontendConfig{
+		FrontendEndpoint:     "open-match-frontend.open-match.svc:50504",
+		TimeBetweenCreations: 1 * time.Second,
+		TicketCreationAmount: 1,
+	}
+
 	feClient pb.FrontendServiceClient
 
 	ticketCounter = 0
@@ -31,9 +43,11 @@ var (
 )
 
 func main() {
-	flag.Parse()
+	if err := appconfig.Load("test-frontend", &cfg); err != nil {
+		log.Fatalf("Invalid configuration, got %v", err)
+	}
 
-	conn, err := grpc.Dial(openMatchFrontendEndpoint,
+	conn, err := grpc.Dial(cfg.FrontendEndpoint,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`),
 	)
@@ -45,11 +59,11 @@ func main() {
 	defer conn.Close()
 	feClient = pb.NewFrontendServiceClient(conn)
 
-	log.Printf("Creating %v ticket(s) every %v", *ticketCreationAmount, *timeBetweenCreations)
+	log.Printf("Creating %v ticket(s) every %v", cfg.TicketCreationAmount, cfg.TimeBetweenCreations)
 	log.Printf("Using modes: %v", modes)
 
-	for ; true; <-time.Tick(*timeBetweenCreations) {
-		for i := 0; i < *ticketCreationAmount; i++ {
+	for ; true; <-time.Tick(cfg.TimeBetweenCreations) {
+		for i := 0; i < cfg.TicketCreationAmount; i++ {
 			ticket := getTicket()
 			req := &pb.CreateTicketRequest{Ticket: ticket}
 			resp, err := feClient.CreateTicket(context.Background(), req)
@@ -77,7 +91,7 @@ func getTicket() *pb.Ticket {
 		log.Fatalf("Failed to create playerId, got %v", err)
 	}
 
-	return &pb.Ticket{
+	ticket := &pb.Ticket{
 		SearchFields: &pb.SearchFields{
 			Tags: []string{mode},
 		},
@@ -85,4 +99,8 @@ func getTicket() *pb.Ticket {
 			"playerId": playerId,
 		},
 	}
+	if cfg.ClientVersion > 0 {
+		ticket.SearchFields.DoubleArgs = map[string]float64{"clientVersion": float64(cfg.ClientVersion)}
+	}
+	return ticket
 }